@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"pudd/internal/audit"
+	"pudd/internal/model"
+	"pudd/internal/store"
+)
+
+// The daemon audits on its own every -audit-interval; `pudd audit` runs
+// one on demand and `pudd audit log` lists what audits have found.
+
+func cmdAudit(ctx context.Context, c *cli, args []string) error {
+	if len(args) > 0 && args[0] == "log" {
+		return cmdAuditLog(c, args[1:])
+	}
+	var sample int
+	var dryRun bool
+	fs := c.flags("audit")
+	fs.IntVar(&sample, "sample", c.cfg.AuditSample, "DONE files to check")
+	fs.BoolVar(&dryRun, "dry-run", false, "report what is wrong without requeuing anything")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	u, err := newUploader(ctx, c.cfg, false)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return errors.New("no bucket to audit, set -bucket")
+	}
+	rep, runErr := audit.Run(ctx, c.db, u, sample, dryRun)
+	if c.json {
+		if err := c.printJSON(rep); err != nil {
+			return err
+		}
+	} else {
+		tw := c.table()
+		fmt.Fprintln(tw, "FILE\tOBJECT\tPROBLEM\tDETAIL\tTO\tERROR")
+		for _, f := range rep.Findings {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", f.FileID, f.Object, f.Problem, f.Detail, f.To, f.Err)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		verb := "requeued"
+		if dryRun {
+			verb = "would requeue (dry run)"
+		}
+		fmt.Fprintf(c.out, "checked %d file(s), %s %d, %d unknown, %d failed\n", rep.Checked, verb, rep.Requeued(), rep.Unknown(), rep.Failed())
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if rep.Failed() > 0 {
+		return errExitOne
+	}
+	return nil
+}
+
+func cmdAuditLog(c *cli, args []string) error {
+	var limit int
+	fs := c.flags("audit log")
+	fs.IntVar(&limit, "limit", 50, "max problems to list")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	audits, err := store.ListAudits(c.db, limit)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		if audits == nil {
+			audits = []model.Audit{}
+		}
+		return c.printJSON(audits)
+	}
+	tw := c.table()
+	fmt.Fprintln(tw, "FOUND\tFILE\tOBJECT\tPROBLEM\tDETAIL")
+	for _, a := range audits {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", a.FoundAt, a.FileID, a.Object, a.Problem, a.Detail)
+	}
+	return tw.Flush()
+}
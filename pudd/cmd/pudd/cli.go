@@ -0,0 +1,840 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"pudd/internal/config"
+	"pudd/internal/discover"
+	"pudd/internal/hash"
+	"pudd/internal/model"
+	"pudd/internal/mount"
+	"pudd/internal/pipeline"
+	"pudd/internal/reconcile"
+	"pudd/internal/store"
+)
+
+// Operator subcommands. They only go through the store package, whose updates
+// are transactional and respect worker leases, so they are safe to run while
+// the daemon is up.
+
+type cli struct {
+	cfg  config.Config
+	db   *sql.DB
+	out  io.Writer
+	json bool
+}
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, c *cli, args []string) error
+}
+
+var commands = []command{
+	{"status", "status", cmdStatus},
+	{"ls", "ls [--device id] [--state STATE] [--from time] [--to time] [--limit n]", cmdLs},
+	{"retry", "retry <id>|--all-errors", cmdRetry},
+	{"skip", "skip <id>", cmdSkip},
+	{"rescan", "rescan <device>", cmdRescan},
+	{"check", "check <local-file>", cmdCheck},
+	{"history", "history <id>", cmdHistory},
+	{"failed", "failed [--device id]", cmdFailed},
+	{"requeue", "requeue <id>|--all [--device id]", cmdRequeue},
+	{"reconcile", "reconcile [--dry-run]", cmdReconcile},
+	{"audit", "audit [--sample n] [--dry-run] | audit log [--limit n]", cmdAudit},
+	{"devices", "devices [set <device> [--label name] [--owner who] [--quota size] [--priority n] [--tz zone] [--skew d] | calibrate <device> <id> <time>]", cmdDevices},
+	{"shoots", "shoots [group [--gap d] | show <id> | manifest <id>|--all [--out dir]]", cmdShoots},
+	{"db", "db migrate [--dry-run]", cmdDB},
+	{"graph", "graph", cmdGraph},
+}
+
+// returned by a command that already printed its result but should exit non-zero
+var errExitOne = errors.New("exit 1")
+
+func runCommand(cfg config.Config, args []string) int {
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == args[0] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "pudd: unknown command %q\n", args[0])
+		usage(os.Stderr)
+		return 2
+	}
+
+	c := &cli{cfg: cfg, out: os.Stdout}
+	// graph describes the pipeline built into this binary, not a db
+	if cmd.name != "graph" {
+		db, err := openDB(cfg, cmd.name != "db")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "pudd: %v\n", err)
+			return 1
+		}
+		defer db.Close()
+		c.db = db
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := cmd.run(ctx, c, args[1:]); err != nil {
+		if err == errExitOne {
+			return 1
+		}
+		if errors.Is(err, flag.ErrHelp) {
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "pudd %s: %v\n", cmd.name, err)
+		return 1
+	}
+	return 0
+}
+
+// openDB opens the daemon's db; with current set it also insists the schema
+// is up to date
+func openDB(cfg config.Config, current bool) (*sql.DB, error) {
+	// the CLI never creates or initializes the db; that is the daemon's job
+	if _, err := os.Stat(cfg.DBPath); err != nil {
+		return nil, err
+	}
+	db, err := store.Open(cfg.DBPath)
+	if err != nil {
+		return nil, fmt.Errorf("open db: %w", err)
+	}
+
+	// other commands read columns that only exist once the daemon (or
+	// `pudd db migrate`) has brought the schema up to date
+	if current {
+		pending, err := store.PendingMigrations(db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		if len(pending) > 0 {
+			db.Close()
+			return nil, fmt.Errorf("db schema is %d migration(s) behind; run `pudd db migrate`", len(pending))
+		}
+	}
+	return db, nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: pudd [flags] <command> [--json] [args]")
+	fmt.Fprintln(w, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\n", cmd.usage)
+	}
+}
+
+// flags returns a FlagSet for a subcommand with the shared --json flag bound to c
+func (c *cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.BoolVar(&c.json, "json", false, "print JSON instead of a table")
+	return fs
+}
+
+// parse lets flags and positional args be interleaved (`retry 12 --json`)
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var pos []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return pos, nil
+		}
+		pos = append(pos, args[0])
+		args = args[1:]
+	}
+}
+
+func (c *cli) printJSON(v any) error {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (c *cli) table() *tabwriter.Writer {
+	return tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+}
+
+func parseID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad file id %q", s)
+	}
+	return id, nil
+}
+
+// parseTime parses a time given on the command line: RFC 3339, or a UTC
+// date and optional time. A bare date as the end of a range takes in the
+// whole day.
+func parseTime(s string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02 15:04:05", s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("bad time %q, want e.g. 2024-06-01, \"2024-06-01 14:00:00\" (UTC) or 2024-06-01T16:00:00+02:00", s)
+}
+
+func cmdStatus(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("status")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	st, err := store.FetchStatus(c.db)
+	if err != nil {
+		return err
+	}
+	// the pools the daemon recorded at startup; before any did, this
+	// invocation's flags are the best guess
+	pools, started, err := store.ListPools(c.db)
+	if err != nil {
+		return err
+	}
+	source := "daemon"
+	if len(pools) == 0 {
+		pools, source = pipeline.Pools(c.cfg), "local config"
+	}
+	if c.json {
+		return c.printJSON(struct {
+			store.Status
+			Pools       []model.Pool `json:"pools"`
+			PoolsSource string       `json:"pools_source"`
+			PoolsSince  string       `json:"pools_since,omitempty"`
+		}{st, pools, source, started})
+	}
+
+	tw := c.table()
+	fmt.Fprintln(tw, "STATE\tFILES\tBYTES")
+	for _, s := range st.States {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", s.State, s.Files, s.Bytes)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "DEVICE\tFILES\tBYTES\tDONE\tERRORS\tFAILED\tCOPYING")
+	for _, d := range st.Devices {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\n", d.DeviceID, d.Files, d.Bytes, d.Done, d.Errors, d.Failed, d.Copying)
+	}
+	fmt.Fprintln(tw)
+	if source == "daemon" {
+		fmt.Fprintf(tw, "pools as the daemon started them at %s\n", started)
+	} else {
+		fmt.Fprintln(tw, "pools from local config, no daemon has recorded its own")
+	}
+	fmt.Fprintln(tw, "POOL\tWORKERS\tBUSY\tLIMIT")
+	for _, p := range pools {
+		limit := "-"
+		switch {
+		case p.PerDevice > 0:
+			limit = fmt.Sprintf("%d per device", p.PerDevice)
+		case p.PerDest > 0:
+			limit = fmt.Sprintf("%d per destination", p.PerDest)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", p.Stage, p.Workers, st.Busy[p.Stage], limit)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, "backing off:\t%d\n", st.BackingOff)
+	fmt.Fprintf(tw, "failed (dead letter):\t%d\n", st.Failed)
+	return tw.Flush()
+}
+
+func cmdLs(ctx context.Context, c *cli, args []string) error {
+	var filter store.ListFilter
+	var state, from, to string
+	fs := c.flags("ls")
+	fs.StringVar(&filter.DeviceID, "device", "", "only files from this device")
+	fs.StringVar(&state, "state", "", "only files in this state")
+	fs.StringVar(&from, "from", "", "only files captured at or after this time; a date is midnight UTC")
+	fs.StringVar(&to, "to", "", "only files captured before this time; a date includes that whole day (UTC)")
+	fs.IntVar(&filter.Limit, "limit", 0, "max rows (0 = all)")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	filter.State = model.FileState(state)
+	var err error
+	if from != "" {
+		if filter.CapturedFrom, err = parseTime(from, false); err != nil {
+			return err
+		}
+	}
+	if to != "" {
+		if filter.CapturedTo, err = parseTime(to, true); err != nil {
+			return err
+		}
+	}
+
+	files, err := store.ListFiles(c.db, filter)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		if files == nil {
+			files = []model.FileRow{}
+		}
+		return c.printJSON(files)
+	}
+
+	tw := c.table()
+	fmt.Fprintln(tw, "ID\tDEVICE\tSTATE\tSIZE\tCAPTURED\tATTEMPTS\tNEXT RUN\tSRC\tERROR")
+	for _, f := range files {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%d\t%s\t%s\t%s\n",
+			f.ID, f.DeviceID, f.State, f.Size, f.CapturedAt, f.Attempts, f.NextRunAt, f.SrcPath, f.LastError)
+	}
+	return tw.Flush()
+}
+
+func cmdRetry(ctx context.Context, c *cli, args []string) error {
+	var all bool
+	fs := c.flags("retry")
+	fs.BoolVar(&all, "all-errors", false, "retry every file with a recorded error")
+	pos, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		ID      int64 `json:"id,omitempty"`
+		Retried int64 `json:"retried"`
+	}
+
+	var res result
+	switch {
+	case all && len(pos) == 0:
+		res.Retried, err = store.RetryAllErrors(c.db)
+		if err != nil {
+			return err
+		}
+	case !all && len(pos) == 1:
+		res.ID, err = parseID(pos[0])
+		if err != nil {
+			return err
+		}
+		ok, err := store.Retry(c.db, res.ID)
+		if err != nil {
+			return err
+		}
+		if ok {
+			res.Retried = 1
+		}
+	default:
+		return errors.New("want exactly one of <id> or --all-errors")
+	}
+
+	if c.json {
+		return c.printJSON(res)
+	}
+	if res.ID != 0 && res.Retried == 0 {
+		fmt.Fprintf(c.out, "file %d not retried (missing, finished, skipped or leased)\n", res.ID)
+		return errExitOne
+	}
+	fmt.Fprintf(c.out, "retried %d file(s)\n", res.Retried)
+	return nil
+}
+
+func cmdSkip(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("skip")
+	pos, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return errors.New("want <id>")
+	}
+	id, err := parseID(pos[0])
+	if err != nil {
+		return err
+	}
+
+	ok, err := store.Skip(c.db, id)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(struct {
+			ID      int64 `json:"id"`
+			Skipped bool  `json:"skipped"`
+		}{id, ok})
+	}
+	if !ok {
+		fmt.Fprintf(c.out, "file %d not skipped (missing, finished or leased)\n", id)
+		return errExitOne
+	}
+	fmt.Fprintf(c.out, "file %d skipped\n", id)
+	return nil
+}
+
+func cmdRescan(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("rescan")
+	pos, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return errors.New("want <device>")
+	}
+	devID := pos[0]
+
+	// an unplugged device can leave its empty mountpoint behind
+	mp := filepath.Join(c.cfg.MountRoot, devID)
+	if !mount.IsMounted(mp) {
+		return fmt.Errorf("device %s is not mounted at %s", devID, mp)
+	}
+
+	n, err := discover.DiscoverAndInsert(ctx, c.db, devID, mp, c.cfg.StageRoot)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(struct {
+			DeviceID string `json:"device_id"`
+			New      int    `json:"new"`
+		}{devID, n})
+	}
+	fmt.Fprintf(c.out, "rescanned %s: %d new file(s)\n", devID, n)
+	return nil
+}
+
+func cmdCheck(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("check")
+	pos, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return errors.New("want <local-file>")
+	}
+
+	h, err := hash.Compute(ctx, pos[0])
+	if err != nil {
+		return err
+	}
+	files, err := store.FindBySHA256(c.db, h.SHA256)
+	if err != nil {
+		return err
+	}
+
+	uploaded := false
+	for _, f := range files {
+		if f.State.Uploaded() {
+			uploaded = true
+		}
+	}
+
+	if c.json {
+		if files == nil {
+			files = []model.FileRow{}
+		}
+		err := c.printJSON(struct {
+			Path     string          `json:"path"`
+			Size     int64           `json:"size"`
+			SHA256   string          `json:"sha256"`
+			Uploaded bool            `json:"uploaded"`
+			Matches  []model.FileRow `json:"matches"`
+		}{pos[0], h.Size, h.SHA256, uploaded, files})
+		if err == nil && !uploaded {
+			return errExitOne
+		}
+		return err
+	}
+
+	fmt.Fprintf(c.out, "%s\nsize=%d sha256=%s\n", pos[0], h.Size, h.SHA256)
+	for _, f := range files {
+		fmt.Fprintf(c.out, "  file %d device=%s src=%s state=%s\n", f.ID, f.DeviceID, f.SrcPath, f.State)
+	}
+	if !uploaded {
+		fmt.Fprintln(c.out, "NOT uploaded")
+		return errExitOne
+	}
+	fmt.Fprintln(c.out, "uploaded")
+	return nil
+}
+
+func cmdHistory(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("history")
+	pos, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return errors.New("want <id>")
+	}
+	id, err := parseID(pos[0])
+	if err != nil {
+		return err
+	}
+
+	f, err := store.GetFile(c.db, id)
+	if err != nil {
+		return err
+	}
+	events, err := store.FileHistory(c.db, id)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		if events == nil {
+			events = []model.FileEvent{}
+		}
+		return c.printJSON(struct {
+			File   model.FileRow     `json:"file"`
+			Events []model.FileEvent `json:"events"`
+		}{f, events})
+	}
+
+	fmt.Fprintf(c.out, "file %d device=%s src=%s state=%s attempts=%d\n", f.ID, f.DeviceID, f.SrcPath, f.State, f.Attempts)
+	tw := c.table()
+	fmt.Fprintln(tw, "AT\tFROM\tTO\tWORKER\tBYTES\tERROR")
+	for _, ev := range events {
+		from := string(ev.From)
+		if from == "" {
+			from = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", ev.At, from, ev.To, ev.Worker, ev.Bytes, ev.Error)
+	}
+	return tw.Flush()
+}
+
+func cmdFailed(ctx context.Context, c *cli, args []string) error {
+	filter := store.ListFilter{State: model.StateFailed}
+	fs := c.flags("failed")
+	fs.StringVar(&filter.DeviceID, "device", "", "only files from this device")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	files, err := store.ListFiles(c.db, filter)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		if files == nil {
+			files = []model.FileRow{}
+		}
+		return c.printJSON(files)
+	}
+
+	tw := c.table()
+	fmt.Fprintln(tw, "ID\tDEVICE\tFAILED IN\tATTEMPTS\tAT\tSRC\tREASON")
+	for _, f := range files {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			f.ID, f.DeviceID, f.FailedFrom, f.Attempts, f.UpdatedAt, f.SrcPath, f.LastError)
+	}
+	return tw.Flush()
+}
+
+func cmdRequeue(ctx context.Context, c *cli, args []string) error {
+	var all bool
+	var deviceID string
+	fs := c.flags("requeue")
+	fs.BoolVar(&all, "all", false, "requeue every FAILED file")
+	fs.StringVar(&deviceID, "device", "", "with --all, only files from this device")
+	pos, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		ID       int64 `json:"id,omitempty"`
+		Requeued int64 `json:"requeued"`
+	}
+
+	var res result
+	switch {
+	case all && len(pos) == 0:
+		res.Requeued, err = store.RequeueFailed(c.db, deviceID)
+		if err != nil {
+			return err
+		}
+	case !all && deviceID == "" && len(pos) == 1:
+		res.ID, err = parseID(pos[0])
+		if err != nil {
+			return err
+		}
+		ok, err := store.Requeue(c.db, res.ID)
+		if err != nil {
+			return err
+		}
+		if ok {
+			res.Requeued = 1
+		}
+	default:
+		return errors.New("want exactly one of <id> or --all [--device id]")
+	}
+
+	if c.json {
+		return c.printJSON(res)
+	}
+	if res.ID != 0 && res.Requeued == 0 {
+		fmt.Fprintf(c.out, "file %d not requeued (missing or not FAILED)\n", res.ID)
+		return errExitOne
+	}
+	fmt.Fprintf(c.out, "requeued %d file(s)\n", res.Requeued)
+	return nil
+}
+
+func cmdDevices(ctx context.Context, c *cli, args []string) error {
+	if len(args) > 0 && args[0] == "set" {
+		return cmdDevicesSet(c, args[1:])
+	}
+	if len(args) > 0 && args[0] == "calibrate" {
+		return cmdDevicesCalibrate(c, args[1:])
+	}
+	fs := c.flags("devices")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	devices, err := store.ListDevices(c.db)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		if devices == nil {
+			devices = []model.Device{}
+		}
+		return c.printJSON(devices)
+	}
+
+	tw := c.table()
+	fmt.Fprintln(tw, "DEVICE\tLABEL\tOWNER\tPRESENT\tMOUNT\tFILES\tBYTES\tQUOTA\tPRIORITY\tTZ\tSKEW\tFIRST SEEN\tLAST SEEN\tID SOURCE")
+	for _, d := range devices {
+		present := "no"
+		if d.Present {
+			present = "yes"
+		}
+		quota := "default"
+		if d.QuotaBytes > 0 {
+			quota = config.FormatBytes(d.QuotaBytes)
+		}
+		tz := d.Timezone
+		if tz == "" {
+			tz = "UTC"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			d.DeviceID, d.Label, d.Owner, present, d.MountPoint, d.FilesIngested, d.BytesIngested, quota, d.Priority,
+			tz, time.Duration(d.ClockSkew)*time.Second, d.FirstSeen, d.LastSeen, d.IDSource)
+	}
+	return tw.Flush()
+}
+
+func cmdDevicesSet(c *cli, args []string) error {
+	var label, owner, tz string
+	var quota config.Bytes
+	var priority int64
+	var skew time.Duration
+	fs := c.flags("devices set")
+	fs.StringVar(&label, "label", "", "nickname shown for the device")
+	fs.StringVar(&owner, "owner", "", "who the device belongs to")
+	fs.Var(&quota, "quota", "max bytes of this device in staging at once, e.g. 200G (0 = the -device-quota default)")
+	fs.Int64Var(&priority, "priority", 0, "scheduling priority; devices with a higher one are served first")
+	fs.StringVar(&tz, "tz", "", "timezone the device's clock is set to, e.g. Europe/Berlin or +02:00 (empty = UTC)")
+	fs.DurationVar(&skew, "skew", 0, "how far the device's clock runs ahead of true time, e.g. 90s or -2m; see devices calibrate")
+	pos, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return errors.New("want <device>")
+	}
+
+	d, err := store.GetDevice(c.db, pos[0])
+	if err != nil {
+		return err
+	}
+	// flags that weren't given keep their current value
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "label":
+			d.Label = label
+		case "owner":
+			d.Owner = owner
+		case "quota":
+			d.QuotaBytes = int64(quota)
+		case "priority":
+			d.Priority = priority
+		case "tz":
+			d.Timezone = tz
+		case "skew":
+			d.ClockSkew = int64(skew.Round(time.Second) / time.Second)
+		}
+	})
+	if _, err := store.SetDeviceInfo(c.db, d); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(d)
+	}
+	fmt.Fprintf(c.out, "device %s: label=%q owner=%q quota=%s priority=%d tz=%q skew=%s\n", d.DeviceID, d.Label, d.Owner,
+		config.FormatBytes(d.QuotaBytes), d.Priority, d.Timezone, time.Duration(d.ClockSkew)*time.Second)
+	return nil
+}
+
+// cmdDevicesCalibrate sets a device's clock skew from a file it recorded at
+// a known time, typically a shot of a phone's clock
+func cmdDevicesCalibrate(c *cli, args []string) error {
+	fs := c.flags("devices calibrate")
+	pos, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 3 {
+		return errors.New("want <device> <file-id> <true time of the recording>")
+	}
+	id, err := parseID(pos[1])
+	if err != nil {
+		return err
+	}
+	d, err := store.GetDevice(c.db, pos[0])
+	if err != nil {
+		return err
+	}
+	f, err := store.GetFile(c.db, id)
+	if err != nil {
+		return err
+	}
+	if f.DeviceID != d.DeviceID {
+		return fmt.Errorf("file %d is from device %s, not %s", id, f.DeviceID, d.DeviceID)
+	}
+	// a time without an offset is read in the device's timezone
+	truth, err := time.Parse(time.RFC3339, pos[2])
+	if err != nil {
+		truth, err = time.ParseInLocation("2006-01-02 15:04:05", pos[2], d.Clock().Zone)
+	}
+	if err != nil {
+		return fmt.Errorf("bad time %q, want e.g. \"2024-06-01 14:00:00\" (device time) or 2024-06-01T14:00:00+02:00", pos[2])
+	}
+
+	recorded, source, err := store.RecordedAt(c.db, id)
+	if err != nil {
+		return err
+	}
+	d.ClockSkew = int64(recorded.Sub(truth).Round(time.Second) / time.Second)
+	if _, err := store.SetDeviceInfo(c.db, d); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(d)
+	}
+	fmt.Fprintf(c.out, "device %s: clock runs %s ahead (file %d recorded %s by its %s, truly %s)\n", d.DeviceID,
+		time.Duration(d.ClockSkew)*time.Second, id, recorded.Format(time.RFC3339), source, truth.UTC().Format(time.RFC3339))
+	return nil
+}
+
+func cmdReconcile(ctx context.Context, c *cli, args []string) error {
+	var dryRun bool
+	fs := c.flags("reconcile")
+	fs.BoolVar(&dryRun, "dry-run", false, "report what would change without changing it")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	// stale mounts are left to the daemon's startup pass, see reconcile
+	rep, runErr := reconcile.Run(ctx, c.db, c.cfg, dryRun, false)
+	if c.json {
+		if err := c.printJSON(rep); err != nil {
+			return err
+		}
+	} else {
+		tw := c.table()
+		fmt.Fprintln(tw, "ACTION\tFILE\tFROM\tTO\tPATH\tDETAIL\tERROR")
+		for _, a := range rep.Actions {
+			id := ""
+			if a.FileID != 0 {
+				id = strconv.FormatInt(a.FileID, 10)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", a.Kind, id, a.From, a.To, a.Path, a.Detail, a.Err)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		verb := "made"
+		if dryRun {
+			verb = "would make (dry run)"
+		}
+		fmt.Fprintf(c.out, "%s %d change(s), %d failed\n", verb, rep.Changes(), rep.Failed())
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if rep.Failed() > 0 {
+		return errExitOne
+	}
+	return nil
+}
+
+// cmdGraph prints the legal state transitions as a Graphviz digraph, one
+// cluster per stage: `pudd graph | dot -Tsvg > states.svg`
+func cmdGraph(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags("graph")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	edges := model.Transitions()
+	if c.json {
+		return c.printJSON(edges)
+	}
+
+	// operator edges would drown the pipeline; they are drawn dashed
+	style := map[model.EdgeKind]string{
+		model.EdgeClaim:   `color="black"`,
+		model.EdgeAdvance: `color="black", penwidth=2`,
+		model.EdgeReset:   `color="gray"`,
+		model.EdgeRetry:   `color="orange"`,
+		model.EdgeReroute: `color="blue"`,
+		model.EdgeError:   `color="red"`,
+		model.EdgeFail:    `color="red", penwidth=2`,
+		model.EdgeRequeue: `color="purple", style=dashed`,
+		model.EdgeSkip:    `color="gray", style=dashed`,
+		model.EdgeCorrupt: `color="brown", penwidth=2`,
+		model.EdgeAudit:   `color="darkgreen", style=dashed`,
+	}
+
+	w := bufio.NewWriter(c.out)
+	fmt.Fprintln(w, "digraph pudd {")
+	fmt.Fprintln(w, "\trankdir=LR;")
+	fmt.Fprintln(w, "\tnode [shape=box, style=rounded];")
+	for _, d := range model.Stages() {
+		fmt.Fprintf(w, "\tsubgraph \"cluster_%s\" {\n", d.Name)
+		fmt.Fprintf(w, "\t\tlabel=%q;\n", string(d.Name))
+		fmt.Fprintf(w, "\t\t%q;\n", string(d.Input))
+		fmt.Fprintf(w, "\t\t%q [style=\"rounded,bold\"];\n", string(d.Claim))
+		for _, s := range d.Via {
+			fmt.Fprintf(w, "\t\t%q;\n", string(s))
+		}
+		fmt.Fprintln(w, "\t}")
+	}
+	for _, s := range []model.FileState{model.StateDone, model.StateCorrupt} {
+		fmt.Fprintf(w, "\t%q [shape=doublecircle];\n", string(s))
+	}
+	for _, e := range edges {
+		fmt.Fprintf(w, "\t%q -> %q [label=%q, %s];\n", string(e.From), string(e.To), string(e.Kind), style[e.Kind])
+	}
+	fmt.Fprintln(w, "}")
+	return w.Flush()
+}
@@ -3,101 +3,367 @@ package main
 import (
 	"context"
 	"database/sql"
-	"log"
+	"flag"
+	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"sync"
+	"syscall"
+	"time"
+	// device timezones are IANA names; the dock may have no zoneinfo
+	_ "time/tzdata"
 
+	"pudd/internal/admission"
+	"pudd/internal/audit"
 	"pudd/internal/config"
 	"pudd/internal/deviceid"
 	"pudd/internal/discover"
+	"pudd/internal/gcs"
+	"pudd/internal/logging"
+	"pudd/internal/metrics"
+	"pudd/internal/model"
 	"pudd/internal/mount"
 	"pudd/internal/pipeline"
+	"pudd/internal/reconcile"
+	"pudd/internal/scheduler"
 	"pudd/internal/store"
 	"pudd/internal/udev"
-	"pudd/internal/worker"
 )
 
+// mounted is what we remember about a plugged-in device between add and remove
+type mounted struct {
+	deviceID   string
+	mountPoint string
+	session    string
+}
+
 func main() {
-	logger := log.New(os.Stdout, "", log.LstdFlags|log.Lmicroseconds)
 	cfg := config.FromFlags()
 
+	logger, err := logging.New(os.Stdout, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pudd: %v\n", err)
+		os.Exit(2)
+	}
+	// packages without a logger parameter log through the default
+	slog.SetDefault(logger)
+
+	// anything left after the flags is an operator subcommand
+	if args := flag.Args(); len(args) > 0 {
+		os.Exit(runCommand(cfg, args))
+	}
+
 	db, err := store.Open(cfg.DBPath)
 	if err != nil {
-		logger.Fatalf("open db: %v", err)
+		fatal(logger, "open db", err)
 	}
 	defer db.Close()
 
-	if err := store.Init(db); err != nil {
-		logger.Fatalf("init db: %v", err)
+	mig, err := migrateDB(db, cfg.DBPath, false)
+	if err != nil {
+		fatal(logger, "migrate db", err)
+	}
+	if len(mig.Applied) > 0 {
+		logger.Info("db migrated", "from", mig.From, "to", mig.To, "backup", mig.Backup)
 	}
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer cancel()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Start pipeline
-	var uploader worker.Uploader
-	go pipeline.Run(ctx, logger, db, cfg, uploader)
+	if cfg.MetricsAddr != "" {
+		if err := metrics.RegisterStore(db, cfg.StageRoot); err != nil {
+			fatal(logger, "register metrics", err)
+		}
+		go func() {
+			if err := metrics.Serve(ctx, cfg.MetricsAddr); err != nil {
+				logger.Error("metrics server failed", "addr", cfg.MetricsAddr, logging.Err, err)
+			}
+		}()
+	}
 
-	// Map devnode -> mountpoint (so remove can unmount the right path)
+	// Map devnode -> mount (so remove can unmount the right path)
 	var mu sync.Mutex
-	devToMount := map[string]string{}
+	devToMount := restoreDevices(logger, db)
+	metrics.ActiveDevices.Set(float64(len(devToMount)))
 
-	_ = os.MkdirAll(cfg.ProbeRoot, 0o755)
-	_ = os.MkdirAll(cfg.MountRoot, 0o755)
+	// StageRoot has to exist for admission control to statfs it
+	for _, dir := range []string{cfg.ProbeRoot, cfg.MountRoot, cfg.StageRoot} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			logger.Error("create root dir failed", "path", dir, logging.Err, err)
+		}
+	}
+
+	// clean up after a crash before any worker looks at the db
+	startupReconcile(ctx, logger, db, cfg)
+
+	// Start pipeline
+	sched, err := scheduler.New(cfg)
+	if err != nil {
+		fatal(logger, "scheduler", err)
+	}
+	var uploader pipeline.Uploader
+	u, err := newUploader(ctx, cfg, cfg.VerifyReadBack)
+	if err != nil {
+		fatal(logger, "gcs", err)
+	}
+	// a nil *gcs.Uploader in the interface would not be nil
+	if u != nil {
+		uploader = u
+	} else {
+		logger.Warn("no -bucket set, uploads will wait")
+	}
+	// for `pudd status`, which can't see this process's flags
+	if err := store.SavePools(db, pipeline.Pools(cfg)); err != nil {
+		logger.Error("record worker pools failed", logging.Err, err)
+	}
+	wake := pipeline.NewNotifier()
+	drained := make(chan pipeline.Summary, 1)
+	go func() {
+		drained <- pipeline.Run(ctx, logger, db, cfg, sched, uploader, wake)
+	}()
+	if u != nil && cfg.AuditInterval > 0 {
+		go auditLoop(ctx, logger, db, cfg, u, wake)
+	}
 
+	udevDone := make(chan struct{})
 	go func() {
-		err := udev.Run(ctx, func(ev udev.Event) {
-			switch ev.Action {
-			case "add":
-				handleAdd(ctx, logger, db, cfg, &mu, devToMount, ev)
-			case "remove":
-				handleRemove(logger, &mu, devToMount, ev)
+		defer close(udevDone)
+		for {
+			err := udev.Run(ctx, func(ev udev.Event) {
+				switch ev.Action {
+				case "add":
+					handleAdd(ctx, logger, db, cfg, wake, &mu, devToMount, ev)
+				case "remove":
+					handleRemove(logger, db, &mu, devToMount, ev)
+				}
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			// udevadm should never exit on its own; restart it after a pause
+			logger.Error("udev monitor exited, restarting", logging.Err, err)
+			metrics.UdevRestarts.Inc()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
 			}
-		})
-		if err != nil && err != context.Canceled {
-			logger.Printf("udev monitor error: %v", err)
-			cancel()
 		}
 	}()
 
 	<-ctx.Done()
-	logger.Println("pudd exiting")
+	// a second signal kills pudd without waiting for the drain
+	stop()
+	logger.Info("shutting down", "grace", cfg.ShutdownGrace)
+
+	// no add/remove may run while devices are being unmounted
+	<-udevDone
+	sum := <-drained
+
+	mu.Lock()
+	unmounted := unmountAll(logger, db, devToMount)
+	metrics.ActiveDevices.Set(0)
+	mu.Unlock()
+
+	for _, f := range sum.Corrupt {
+		logger.Warn("corrupt file quarantined this session", logging.FileID, f.ID, logging.DeviceID, f.DeviceID,
+			"src", f.SrcPath, "state", f.State, "problems", f.Corrupt)
+	}
+	logger.Info("pudd exiting",
+		"copied", sum.Copied,
+		"uploaded", sum.Uploaded,
+		"done", sum.Done,
+		"errors", sum.Errors,
+		"failed", sum.Failed,
+		"corrupt", len(sum.Corrupt),
+		"released", sum.Released,
+		"unmounted", unmounted,
+	)
+}
+
+// unmountAll unmounts every device pudd mounted and reports how many it
+// managed. Their rows wait for the next add event.
+func unmountAll(logger *slog.Logger, db *sql.DB, devToMount map[string]mounted) int {
+	n := 0
+	for devNode, m := range devToMount {
+		log := logger.With("dev", devNode, logging.DeviceID, m.deviceID, logging.Session, m.session)
+		// still mounted, the device stays present for restoreDevices to pick up
+		if err := mount.Unmount(m.mountPoint); err != nil {
+			log.Warn("unmount failed", "mount", m.mountPoint, logging.Err, err)
+			continue
+		}
+		if err := store.DeviceRemoved(db, m.deviceID); err != nil {
+			log.Error("record device removal failed", logging.Err, err)
+		}
+		delete(devToMount, devNode)
+		n++
+		log.Info("device unmounted", "mount", m.mountPoint)
+	}
+	return n
+}
+
+// newUploader connects to cfg.Bucket; nil without one
+func newUploader(ctx context.Context, cfg config.Config, readBack bool) (*gcs.Uploader, error) {
+	if cfg.Bucket == "" {
+		return nil, nil
+	}
+	client, err := gcs.NewClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return gcs.NewUploader(client, cfg.Bucket, cfg.ObjectPrefix, cfg.QuarantinePrefix, cfg.ObjectLayout, readBack)
+}
+
+// auditLoop audits a sample of DONE files every cfg.AuditInterval until ctx
+// is done, waking the pipeline for the files it sends back
+func auditLoop(ctx context.Context, logger *slog.Logger, db *sql.DB, cfg config.Config, bucket audit.Bucket, wake *pipeline.Notifier) {
+	log := logger.With(logging.Stage, "audit")
+	tick := time.NewTicker(cfg.AuditInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		rep, err := audit.Run(ctx, db, bucket, cfg.AuditSample, false)
+		for _, f := range rep.Findings {
+			attrs := []any{logging.FileID, f.FileID, "object", f.Object}
+			if f.Problem != "" {
+				attrs = append(attrs, "problem", f.Problem, "detail", f.Detail, "to", f.To)
+			}
+			if f.Err != "" {
+				log.Warn("audit check failed", append(attrs, logging.Err, f.Err)...)
+				continue
+			}
+			if f.Problem == model.AuditUnknown {
+				log.Warn("object not found under any name it may have, file left DONE", attrs...)
+				continue
+			}
+			log.Warn("object failed audit, file requeued for upload", attrs...)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Error("audit failed", logging.Err, err)
+		}
+		if rep.Requeued() > 0 {
+			wake.Notify()
+		}
+		log.Info("audit complete", "checked", rep.Checked, "requeued", rep.Requeued(), "unknown", rep.Unknown(), "failed", rep.Failed())
+	}
+}
+
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, logging.Err, err)
+	os.Exit(1)
+}
+
+// newSession names one plug-in of a device so its log lines can be grouped
+func newSession(devID string) string {
+	return devID + "-" + time.Now().UTC().Format("20060102T150405Z")
+}
+
+// restoreDevices picks up devices a previous run left mounted, so their
+// remove events are still handled. Anything else the db thinks is present
+// went away while pudd wasn't watching.
+func restoreDevices(logger *slog.Logger, db *sql.DB) map[string]mounted {
+	devToMount := map[string]mounted{}
+
+	devices, err := store.ListDevices(db)
+	if err != nil {
+		logger.Error("load devices failed", logging.Err, err)
+		return devToMount
+	}
+	for _, d := range devices {
+		if !d.Present {
+			continue
+		}
+		log := logger.With("dev", d.DevNode, logging.DeviceID, d.DeviceID)
+		if d.DevNode != "" && devNodeExists(d.DevNode) && mount.IsMounted(d.MountPoint) {
+			session := newSession(d.DeviceID)
+			devToMount[d.DevNode] = mounted{deviceID: d.DeviceID, mountPoint: d.MountPoint, session: session}
+			log.Info("device still mounted", logging.Session, session, "mount", d.MountPoint)
+			continue
+		}
+		if err := store.DeviceRemoved(db, d.DeviceID); err != nil {
+			log.Error("record device removal failed", logging.Err, err)
+			continue
+		}
+		log.Info("device gone since last run")
+	}
+	return devToMount
+}
+
+func startupReconcile(ctx context.Context, logger *slog.Logger, db *sql.DB, cfg config.Config) {
+	rep, err := reconcile.Run(ctx, db, cfg, false, true)
+	for _, a := range rep.Actions {
+		attrs := []any{"kind", a.Kind, "path", a.Path}
+		if a.FileID != 0 {
+			attrs = append(attrs, logging.FileID, a.FileID, "from", a.From, "to", a.To)
+		}
+		if a.Detail != "" {
+			attrs = append(attrs, "detail", a.Detail)
+		}
+		if a.Err != "" {
+			logger.Warn("reconcile action failed", append(attrs, logging.Err, a.Err)...)
+			continue
+		}
+		logger.Info("reconciled", attrs...)
+	}
+	if err != nil {
+		logger.Error("reconcile failed", logging.Err, err)
+		return
+	}
+	logger.Info("reconcile complete", "changes", rep.Changes(), "failed", rep.Failed())
+}
+
+func devNodeExists(devNode string) bool {
+	_, err := os.Stat(devNode)
+	return err == nil
 }
 
 func handleAdd(
 	ctx context.Context,
-	logger *log.Logger,
+	logger *slog.Logger,
 	db *sql.DB,
 	cfg config.Config,
+	wake *pipeline.Notifier,
 	mu *sync.Mutex,
-	devToMount map[string]string,
+	devToMount map[string]mounted,
 	ev udev.Event,
 ) {
 	if ev.DevName == "" {
 		return
 	}
+	log := logger.With("dev", ev.DevName)
 
 	// 1) Mount to a probe location first (so we can read .pudd)
 	probeMP := filepath.Join(cfg.ProbeRoot, filepath.Base(ev.DevName))
-	_ = mount.Unmount(probeMP) // ignore errors
-	if err := mount.MountRO(ev.DevName, probeMP); err != nil {
-		logger.Printf("[add] mount probe failed dev=%s: %v", ev.DevName, err)
+	unmountStale(log, probeMP)
+	if err := mount.MountRO(ev.DevName, probeMP, ev.Props["ID_FS_TYPE"]); err != nil {
+		log.Error("mount probe failed", "mount", probeMP, logging.Err, err)
 		return
 	}
 
 	// 2) Derive final device_id (prefers pudd file if present)
 	devID, src := deviceid.Derive(probeMP, ev.Props)
 	finalMP := filepath.Join(cfg.MountRoot, devID)
+	session := newSession(devID)
+	log = log.With(logging.DeviceID, devID, logging.Session, session)
 
 	// 3) If probe mountpoint isn't the final desired mountpoint, remount.
 	if probeMP != finalMP {
-		_ = mount.Unmount(probeMP)
-		_ = os.MkdirAll(finalMP, 0o755)
-		_ = mount.Unmount(finalMP)
-		if err := mount.MountRO(ev.DevName, finalMP); err != nil {
-			logger.Printf("[add] mount final failed dev=%s id=%s: %v", ev.DevName, devID, err)
+		if err := mount.Unmount(probeMP); err != nil {
+			log.Warn("unmount probe failed", "mount", probeMP, logging.Err, err)
+		}
+		if err := os.MkdirAll(finalMP, 0o755); err != nil {
+			log.Error("create mountpoint failed", "mount", finalMP, logging.Err, err)
+			return
+		}
+		unmountStale(log, finalMP)
+		if err := mount.MountRO(ev.DevName, finalMP, ev.Props["ID_FS_TYPE"]); err != nil {
+			log.Error("mount final failed", "mount", finalMP, logging.Err, err)
 			return
 		}
 	} else {
@@ -105,36 +371,110 @@ func handleAdd(
 	}
 
 	mu.Lock()
-	devToMount[ev.DevName] = finalMP
+	devToMount[ev.DevName] = mounted{deviceID: devID, mountPoint: finalMP, session: session}
+	metrics.ActiveDevices.Set(float64(len(devToMount)))
 	mu.Unlock()
 
-	logger.Printf("[add] dev=%s device_id=%s (source=%s) mount=%s", ev.DevName, devID, src, finalMP)
+	log.Info("device added", "id_source", src, "mount", finalMP)
+
+	label, owner := deviceid.Labels(finalMP)
+	if err := store.DeviceAdded(db, model.Device{
+		DeviceID:   devID,
+		IDSource:   string(src),
+		Label:      label,
+		Owner:      owner,
+		DevNode:    ev.DevName,
+		MountPoint: finalMP,
+	}); err != nil {
+		log.Error("record device failed", logging.Err, err)
+	}
+
+	// rows that failed because this device was unplugged can go right away
+	if n, err := store.WakeDevice(db, devID); err != nil {
+		log.Error("wake device rows failed", logging.Err, err)
+	} else if n > 0 {
+		log.Info("woke rows waiting for device", "rows", n)
+	}
+	// rows left from an earlier plug-in can start while discovery runs
+	wake.Notify()
 
 	// 4) Discover files and insert DISCOVERED rows (idempotent)
-	if err := discover.DiscoverAndInsert(ctx, db, devID, finalMP, cfg.StageRoot); err != nil {
-		logger.Printf("[add] discover failed id=%s: %v", devID, err)
+	start := time.Now()
+	n, err := discover.DiscoverAndInsert(ctx, db, devID, finalMP, cfg.StageRoot)
+	if err != nil {
+		log.Error("discover failed", logging.Err, err)
+		return
+	}
+	log.Info("discover complete", "new", n, logging.Duration, time.Since(start))
+	if n > 0 {
+		wake.Notify()
+	}
+
+	preflight(log, db, cfg, devID)
+}
+
+// preflight warns at plug time when a card won't fit in staging in one go.
+// Nothing is refused here: copies are admitted one at a time and wait for
+// uploads to free up space.
+func preflight(log *slog.Logger, db *sql.DB, cfg config.Config, devID string) {
+	e, err := admission.Preflight(db, cfg, devID)
+	if err != nil {
+		log.Error("staging preflight failed", logging.Err, err)
 		return
 	}
-	logger.Printf("[add] discover complete id=%s", devID)
+	attrs := []any{
+		"pending_files", e.PendingFiles,
+		"pending_bytes", e.PendingBytes,
+		"free", e.Free,
+		"reserve", e.Reserve,
+		"staged", e.Staged,
+		"quota", e.Quota,
+	}
+	switch {
+	case !e.FitsDisk:
+		log.Warn("card is larger than free staging space; copies will wait for uploads", attrs...)
+	case !e.FitsQuota:
+		log.Warn("card exceeds the device staging quota; copies will wait for uploads", attrs...)
+	default:
+		log.Info("staging preflight ok", attrs...)
+	}
+}
+
+// unmountStale clears a mountpoint before reuse. Nothing being mounted there
+// is the normal case, so failures are only logged at debug.
+func unmountStale(log *slog.Logger, mp string) {
+	if err := mount.Unmount(mp); err != nil {
+		log.Debug("unmount before mount", "mount", mp, logging.Err, err)
+	}
 }
 
 func handleRemove(
-	logger *log.Logger,
+	logger *slog.Logger,
+	db *sql.DB,
 	mu *sync.Mutex,
-	devToMount map[string]string,
+	devToMount map[string]mounted,
 	ev udev.Event,
 ) {
 	if ev.DevName == "" {
 		return
 	}
 	mu.Lock()
-	mp := devToMount[ev.DevName]
+	m, ok := devToMount[ev.DevName]
 	delete(devToMount, ev.DevName)
+	metrics.ActiveDevices.Set(float64(len(devToMount)))
 	mu.Unlock()
 
-	if mp == "" {
+	if !ok {
+		return
+	}
+	log := logger.With("dev", ev.DevName, logging.DeviceID, m.deviceID, logging.Session, m.session)
+	if err := store.DeviceRemoved(db, m.deviceID); err != nil {
+		log.Error("record device removal failed", logging.Err, err)
+	}
+	// the device is already gone; a failed unmount leaves a stale mountpoint behind
+	if err := mount.Unmount(m.mountPoint); err != nil {
+		log.Warn("unmount failed", "mount", m.mountPoint, logging.Err, err)
 		return
 	}
-	_ = mount.Unmount(mp)
-	logger.Printf("[remove] dev=%s unmounted=%s", ev.DevName, mp)
+	log.Info("device removed", "mount", m.mountPoint)
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"pudd/internal/store"
+)
+
+type migrateResult struct {
+	From    int               `json:"from_version"`
+	To      int               `json:"to_version"`
+	Backup  string            `json:"backup,omitempty"`
+	Applied []store.Migration `json:"applied"`
+	DryRun  bool              `json:"dry_run"`
+}
+
+// migrateDB brings the schema up to date. When there is anything to apply the
+// db is first copied next to itself, so a bad migration can be rolled back by
+// hand. A dry run only reports the pending migrations and writes nothing;
+// db may be opened read-only for it.
+func migrateDB(db *sql.DB, dbPath string, dryRun bool) (migrateResult, error) {
+	res := migrateResult{DryRun: dryRun, Applied: []store.Migration{}}
+
+	if !dryRun {
+		if err := store.Init(db); err != nil {
+			return res, fmt.Errorf("init: %w", err)
+		}
+	}
+	from, err := store.SchemaVersion(db)
+	if err != nil {
+		return res, err
+	}
+	res.From, res.To = from, from
+
+	pending, err := store.PendingMigrations(db)
+	if err != nil || len(pending) == 0 {
+		return res, err
+	}
+
+	if dryRun {
+		res.Applied = pending
+		res.To = pending[len(pending)-1].Version
+		return res, nil
+	}
+
+	res.Backup = fmt.Sprintf("%s.v%d-%s.bak", dbPath, from, time.Now().UTC().Format("20060102T150405Z"))
+	if err := store.Backup(db, res.Backup); err != nil {
+		return res, fmt.Errorf("backup to %s: %w", res.Backup, err)
+	}
+
+	applied, err := store.Migrate(db)
+	if err != nil {
+		return res, err
+	}
+	res.Applied = applied
+	res.To = applied[len(applied)-1].Version
+	return res, nil
+}
+
+func cmdDB(ctx context.Context, c *cli, args []string) error {
+	if len(args) == 0 || args[0] != "migrate" {
+		return errors.New("want: db migrate [--dry-run]")
+	}
+
+	var dryRun bool
+	fs := c.flags("db migrate")
+	fs.BoolVar(&dryRun, "dry-run", false, "list pending migrations without touching the db")
+	if _, err := parse(fs, args[1:]); err != nil {
+		return err
+	}
+
+	db := c.db
+	if dryRun {
+		ro, err := store.OpenReadOnly(c.cfg.DBPath)
+		if err != nil {
+			return fmt.Errorf("open db: %w", err)
+		}
+		defer ro.Close()
+		db = ro
+	}
+	res, err := migrateDB(db, c.cfg.DBPath, dryRun)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(res)
+	}
+
+	if len(res.Applied) == 0 {
+		fmt.Fprintf(c.out, "schema is up to date at version %d\n", res.From)
+		return nil
+	}
+	verb := "applied"
+	if dryRun {
+		verb = "would apply (dry run)"
+	}
+	fmt.Fprintf(c.out, "%s %d migration(s), version %d -> %d\n", verb, len(res.Applied), res.From, res.To)
+	for _, m := range res.Applied {
+		fmt.Fprintf(c.out, "  %04d %s\n", m.Version, m.Name)
+	}
+	if res.Backup != "" {
+		fmt.Fprintf(c.out, "backup: %s\n", res.Backup)
+	}
+	return nil
+}
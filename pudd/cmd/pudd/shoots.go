@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pudd/internal/gcs"
+	"pudd/internal/model"
+	"pudd/internal/shoot"
+	"pudd/internal/store"
+)
+
+// Shoots group files of different devices recorded at the same time, see
+// package shoot. Grouping is on demand: run `shoots group` after ingesting
+// a shoot's devices, then hand editors the manifests.
+
+func cmdShoots(ctx context.Context, c *cli, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "group":
+			return cmdShootsGroup(c, args[1:])
+		case "show":
+			return cmdShootsShow(c, args[1:])
+		case "manifest":
+			return cmdShootsManifest(c, args[1:])
+		}
+	}
+	fs := c.flags("shoots")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	shoots, err := store.ListShoots(c.db)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		if shoots == nil {
+			shoots = []model.Shoot{}
+		}
+		return c.printJSON(shoots)
+	}
+	tw := c.table()
+	fmt.Fprintln(tw, "SHOOT\tSTART\tEND\tDEVICES\tGROUPED")
+	for _, s := range shoots {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\n", s.ID, s.StartedAt, s.EndedAt, s.Devices, s.GroupedAt)
+	}
+	return tw.Flush()
+}
+
+func cmdShootsGroup(c *cli, args []string) error {
+	var gap time.Duration
+	fs := c.flags("shoots group")
+	fs.DurationVar(&gap, "gap", 2*time.Minute, "longest pause between recordings that still counts as the same shoot")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	res, err := shoot.Regroup(c.db, gap)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(res)
+	}
+	fmt.Fprintf(c.out, "%d shoot(s) among %d file(s) with a capture time\n", len(res.Shoots), res.Files)
+	tw := c.table()
+	fmt.Fprintln(tw, "SHOOT\tSTART\tEND\tDEVICES\tFILES")
+	for _, s := range res.Shoots {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\n", s.ID, s.StartedAt, s.EndedAt, s.Devices, len(s.Files))
+	}
+	return tw.Flush()
+}
+
+func cmdShootsShow(c *cli, args []string) error {
+	fs := c.flags("shoots show")
+	pos, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return errors.New("want <shoot-id>")
+	}
+	id, err := parseShootID(pos[0])
+	if err != nil {
+		return err
+	}
+
+	s, err := store.GetShoot(c.db, id)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(s)
+	}
+	fmt.Fprintf(c.out, "shoot %d: %s to %s, %d device(s)\n", s.ID, s.StartedAt, s.EndedAt, s.Devices)
+	tw := c.table()
+	fmt.Fprintln(tw, "FILE\tDEVICE\tSTART\tEND\tBASIS\tPATH")
+	for _, sf := range s.Files {
+		f, err := store.GetFile(c.db, sf.FileID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", sf.FileID, sf.DeviceID, sf.StartAt, sf.EndAt, sf.Basis, f.SrcPath)
+	}
+	return tw.Flush()
+}
+
+// cmdShootsManifest prints a shoot's manifest as JSON, or with --out writes
+// shoot-<id>.json per shoot into a directory
+func cmdShootsManifest(c *cli, args []string) error {
+	var all bool
+	var out string
+	fs := c.flags("shoots manifest")
+	fs.BoolVar(&all, "all", false, "every shoot")
+	fs.StringVar(&out, "out", "", "directory to write shoot-<id>.json files to, instead of printing")
+	pos, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	switch {
+	case all && len(pos) == 0:
+		shoots, err := store.ListShoots(c.db)
+		if err != nil {
+			return err
+		}
+		for _, s := range shoots {
+			ids = append(ids, s.ID)
+		}
+	case !all && len(pos) == 1:
+		id, err := parseShootID(pos[0])
+		if err != nil {
+			return err
+		}
+		ids = []int64{id}
+	default:
+		return errors.New("want <shoot-id> or --all")
+	}
+
+	object, err := objectURL(c)
+	if err != nil {
+		return err
+	}
+	manifests := []shoot.Manifest{}
+	for _, id := range ids {
+		m, err := shoot.BuildManifest(c.db, id, object)
+		if err != nil {
+			return err
+		}
+		manifests = append(manifests, m)
+	}
+
+	if out == "" {
+		if all {
+			return c.printJSON(manifests)
+		}
+		return c.printJSON(manifests[0])
+	}
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return err
+	}
+	for _, m := range manifests {
+		b, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(out, fmt.Sprintf("shoot-%d.json", m.Shoot))
+		if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out, path)
+	}
+	return nil
+}
+
+// objectURL names where uploaded files are in the bucket, as the uploader
+// names them; nil without a bucket
+func objectURL(c *cli) (func(model.FileRow) string, error) {
+	if c.cfg.Bucket == "" {
+		return nil, nil
+	}
+	u, err := gcs.NewUploader(nil, c.cfg.Bucket, c.cfg.ObjectPrefix, c.cfg.QuarantinePrefix, c.cfg.ObjectLayout, false)
+	if err != nil {
+		return nil, err
+	}
+	return func(f model.FileRow) string {
+		if !f.State.Uploaded() {
+			return ""
+		}
+		// files uploaded before object names were kept
+		name := f.Object
+		if name == "" {
+			name = u.ObjectName(f)
+		}
+		return "gs://" + c.cfg.Bucket + "/" + name
+	}, nil
+}
+
+func parseShootID(s string) (int64, error) {
+	id, err := parseID(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad shoot id %q", s)
+	}
+	return id, nil
+}
@@ -0,0 +1,155 @@
+package admission
+
+import (
+	"database/sql"
+	"sync"
+
+	"pudd/internal/config"
+	"pudd/internal/diskspace"
+	"pudd/internal/model"
+	"pudd/internal/store"
+)
+
+// Admission control for the copy stage. A copy is only claimed if the staging
+// filesystem can take the whole file on top of cfg.StageReserve and the
+// copies already running, and if it keeps the device within its quota. A
+// file too big for the room left waits on its own while smaller files that
+// fit go ahead; the stage only waits when none fits.
+
+type Decision int
+
+const (
+	Admit Decision = iota
+	// not enough free space for any copy; the whole copy stage should wait
+	NoSpace
+	// this device has too much in staging; other devices can go ahead
+	OverQuota
+	// not enough free space for this file, but for smaller ones that can go
+	// ahead
+	TooBig
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Admit:
+		return "admit"
+	case NoSpace:
+		return "no_space"
+	case OverQuota:
+		return "over_quota"
+	case TooBig:
+		return "too_big"
+	}
+	return "unknown"
+}
+
+// Controller tracks the bytes reserved by copies in progress, which statfs
+// doesn't see until they are written
+type Controller struct {
+	cfg config.Config
+
+	mu       sync.Mutex
+	reserved int64
+}
+
+func New(cfg config.Config) *Controller {
+	return &Controller{cfg: cfg}
+}
+
+// Admit decides whether f may be copied now. On Admit the file's size is
+// reserved until Release.
+func (c *Controller) Admit(db *sql.DB, f model.FileRow) (Decision, error) {
+	quota, err := c.quota(db, f.DeviceID)
+	if err != nil {
+		return NoSpace, err
+	}
+	if quota > 0 {
+		staged, err := store.StagedBytes(db, f.DeviceID)
+		if err != nil {
+			return NoSpace, err
+		}
+		// a file bigger than the quota would never fit; let it through alone
+		if staged > 0 && staged+f.Size > quota {
+			return OverQuota, nil
+		}
+	}
+
+	free, err := diskspace.Free(c.cfg.StageRoot)
+	if err != nil {
+		return NoSpace, err
+	}
+
+	c.mu.Lock()
+	room := int64(free) - c.reserved - int64(c.cfg.StageReserve)
+	if f.Size <= room {
+		c.reserved += f.Size
+		c.mu.Unlock()
+		return Admit, nil
+	}
+	c.mu.Unlock()
+
+	smallest, ok, err := store.SmallestRunnable(db, store.RunnableQuery{States: []model.FileState{f.State}})
+	if err != nil {
+		return NoSpace, err
+	}
+	if ok && smallest <= room {
+		return TooBig, nil
+	}
+	return NoSpace, nil
+}
+
+// Release gives back the reservation of an admitted file once its copy is over
+func (c *Controller) Release(f model.FileRow) {
+	c.mu.Lock()
+	c.reserved -= f.Size
+	c.mu.Unlock()
+}
+
+func (c *Controller) quota(db *sql.DB, deviceID string) (int64, error) {
+	q, err := store.DeviceQuota(db, deviceID)
+	if err != nil || q > 0 {
+		return q, err
+	}
+	return int64(c.cfg.DeviceQuota), nil
+}
+
+// Estimate compares what is left to copy off a device with the room staging
+// has for it
+type Estimate struct {
+	DeviceID     string `json:"device_id"`
+	PendingFiles int64  `json:"pending_files"`
+	PendingBytes int64  `json:"pending_bytes"`
+	Free         int64  `json:"free"`    // on the staging filesystem
+	Reserve      int64  `json:"reserve"` // of Free, kept back
+	Staged       int64  `json:"staged"`  // device's files in staging now
+	Quota        int64  `json:"quota"`   // 0 = none
+	FitsDisk     bool   `json:"fits_disk"`
+	FitsQuota    bool   `json:"fits_quota"`
+}
+
+// Preflight estimates whether a device's pending files fit, before any of
+// them is copied. Nothing is reserved; copies are admitted one by one later.
+func Preflight(db *sql.DB, cfg config.Config, deviceID string) (Estimate, error) {
+	e := Estimate{DeviceID: deviceID, Reserve: int64(cfg.StageReserve)}
+
+	var err error
+	e.PendingFiles, e.PendingBytes, err = store.PendingBytes(db, deviceID)
+	if err != nil {
+		return e, err
+	}
+	if e.Staged, err = store.StagedBytes(db, deviceID); err != nil {
+		return e, err
+	}
+	if e.Quota, err = New(cfg).quota(db, deviceID); err != nil {
+		return e, err
+	}
+	free, err := diskspace.Free(cfg.StageRoot)
+	if err != nil {
+		return e, err
+	}
+	e.Free = int64(free)
+
+	e.FitsDisk = e.Free-e.Reserve >= e.PendingBytes
+	e.FitsQuota = e.Quota == 0 || e.Staged+e.PendingBytes <= e.Quota
+	return e, nil
+}
@@ -0,0 +1,189 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"pudd/internal/hash"
+	"pudd/internal/metrics"
+	"pudd/internal/model"
+	"pudd/internal/store"
+)
+
+// Auditing checks that what pudd uploaded is still in the bucket as it was
+// uploaded. Each run looks at a sample of DONE files, those never or least
+// recently audited first, and compares their objects' size, CRC32C and
+// sha256 metadata with the file's. A file whose object is missing or
+// altered is uploaded again: straight from staging if its staged copy is
+// still there and hashes as before, else after copying it off its card
+// again. Files uploaded before object names were kept are looked for under
+// the current layout's name and the name files got before layouts; if
+// neither is there the file is reported but left alone, as the layout or its
+// capture time may have changed since. The daemon audits every
+// cfg.AuditInterval and `pudd audit` does on demand.
+
+// Bucket is where the files were uploaded to
+type Bucket interface {
+	ObjectName(f model.FileRow) string
+	LegacyObjectName(f model.FileRow) string
+	Attrs(ctx context.Context, name string) (model.ObjectAttrs, bool, error)
+}
+
+type Finding struct {
+	FileID  int64  `json:"file_id"`
+	Object  string `json:"object"`
+	Problem string `json:"problem,omitempty"` // model.AuditMissing, model.AuditAltered or model.AuditUnknown
+	Detail  string `json:"detail,omitempty"`
+	// where the file is sent back to for upload (or would be, in a dry run)
+	To  model.FileState `json:"to,omitempty"`
+	Err string          `json:"error,omitempty"` // the object or the finding couldn't be checked or recorded
+}
+
+type Report struct {
+	DryRun   bool      `json:"dry_run"`
+	Checked  int       `json:"checked"`
+	Findings []Finding `json:"findings"`
+}
+
+// Requeued counts the files sent back for upload (or that would be, in a
+// dry run)
+func (r Report) Requeued() int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.To != "" && f.Err == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Unknown counts the files whose object couldn't be found under any name
+// they may have been uploaded under
+func (r Report) Unknown() int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Problem == model.AuditUnknown && f.Err == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed counts the files that couldn't be audited
+func (r Report) Failed() int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Err != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Run audits up to sample DONE files. It stops early if ctx is done.
+func Run(ctx context.Context, db *sql.DB, bucket Bucket, sample int, dryRun bool) (Report, error) {
+	rep := Report{DryRun: dryRun, Findings: []Finding{}}
+	files, err := store.AuditSample(db, sample)
+	if err != nil {
+		return rep, err
+	}
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return rep, err
+		}
+		rep.Checked++
+		fd, ok := check(ctx, bucket, f)
+		if !ok {
+			metrics.Audited.WithLabelValues("error").Inc()
+			rep.Findings = append(rep.Findings, fd)
+			continue
+		}
+		if fd.Problem == "" {
+			metrics.Audited.WithLabelValues("ok").Inc()
+			if !dryRun {
+				if err := store.AuditPassed(db, f.ID, fd.Object); err != nil {
+					return rep, err
+				}
+			}
+			continue
+		}
+		metrics.Audited.WithLabelValues(fd.Problem).Inc()
+		a := model.Audit{FileID: f.ID, Object: fd.Object, Problem: fd.Problem, Detail: fd.Detail}
+		if fd.Problem == model.AuditUnknown {
+			if !dryRun {
+				if err := store.AuditUnresolved(db, a); err != nil {
+					fd.Err = err.Error()
+				}
+			}
+			rep.Findings = append(rep.Findings, fd)
+			continue
+		}
+		fd.To = resend(ctx, f)
+		if !dryRun {
+			requeued, err := store.AuditFailed(db, a, fd.To)
+			switch {
+			case err != nil:
+				fd.Err = err.Error()
+			case !requeued:
+				fd.Err = "file is no longer DONE"
+			}
+		}
+		rep.Findings = append(rep.Findings, fd)
+	}
+	return rep, nil
+}
+
+// check compares f's object with f; false if the bucket couldn't be asked
+func check(ctx context.Context, bucket Bucket, f model.FileRow) (Finding, bool) {
+	names := []string{f.Object}
+	if f.Object == "" {
+		names = slices.Compact([]string{bucket.ObjectName(f), bucket.LegacyObjectName(f)})
+	}
+	fd := Finding{FileID: f.ID, Object: names[0]}
+
+	var attrs model.ObjectAttrs
+	found := false
+	for _, name := range names {
+		a, ok, err := bucket.Attrs(ctx, name)
+		if err != nil {
+			fd.Err = err.Error()
+			return fd, false
+		}
+		if ok {
+			fd.Object, attrs, found = name, a, true
+			break
+		}
+	}
+	switch {
+	case !found && f.Object == "":
+		fd.Problem, fd.Detail = model.AuditUnknown, "no object named "+strings.Join(names, " or ")
+	case !found:
+		fd.Problem, fd.Detail = model.AuditMissing, "no such object"
+	case attrs.Size != f.Size:
+		fd.Problem, fd.Detail = model.AuditAltered, fmt.Sprintf("size: local=%d remote=%d", f.Size, attrs.Size)
+	case attrs.CRC32C != f.CRC32C:
+		fd.Problem, fd.Detail = model.AuditAltered, fmt.Sprintf("crc32c: local=%d remote=%d", f.CRC32C, attrs.CRC32C)
+	case attrs.SHA256 != f.SHA256:
+		fd.Problem, fd.Detail = model.AuditAltered, fmt.Sprintf("sha256 metadata: local=%s remote=%q", f.SHA256, attrs.SHA256)
+	}
+	return fd, true
+}
+
+// resend picks where a flagged file goes: straight back to upload when its
+// staged copy is still there and intact, else back to be copied off the card
+func resend(ctx context.Context, f model.FileRow) model.FileState {
+	fromCard := model.Stages()[0].Input
+	info, err := os.Stat(f.StagedPath)
+	if err != nil || info.Size() != f.Size {
+		return fromCard
+	}
+	sum, err := hash.Compute(ctx, f.StagedPath)
+	if err != nil || sum.SHA256 != f.SHA256 {
+		return fromCard
+	}
+	return model.StageUpload.RetryState()
+}
@@ -1,9 +1,13 @@
 package camerautil
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
+
+	"pudd/internal/logging"
 )
 
 func RemountRW(mountPoint string) error {
@@ -16,17 +20,24 @@ func RemountRO(mountPoint string) error {
 
 // DeleteFromCamera deletes a file at absolute path under mountPoint.
 // Caller should ensure mountPoint is the correct mounted camera filesystem.
-func DeleteFromCamera(mountPoint string, absPath string) error {
+func DeleteFromCamera(mountPoint string, absPath string) (err error) {
 	// remount RW, delete, sync, remount RO
 	if err := RemountRW(mountPoint); err != nil {
 		return fmt.Errorf("remount rw: %w", err)
 	}
-	defer func() { _ = RemountRO(mountPoint) }()
+	defer func() {
+		// leaving the card writable is worth reporting even if the delete worked
+		if roErr := RemountRO(mountPoint); roErr != nil {
+			err = errors.Join(err, fmt.Errorf("remount ro: %w", roErr))
+		}
+	}()
 
 	if err := os.Remove(absPath); err != nil {
 		return fmt.Errorf("remove: %w", err)
 	}
 	// Best-effort sync
-	_ = exec.Command("sync").Run()
+	if err := exec.Command("sync").Run(); err != nil {
+		slog.Warn("sync after camera delete failed", "mount", mountPoint, logging.Err, err)
+	}
 	return nil
 }
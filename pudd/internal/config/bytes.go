@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Bytes is a size flag. It takes a plain byte count or a number with a K, M,
+// G or T suffix (powers of 1024), e.g. 512M or 1.5T.
+type Bytes int64
+
+func (b *Bytes) String() string {
+	if b == nil {
+		return "0"
+	}
+	return FormatBytes(int64(*b))
+}
+
+func (b *Bytes) Set(s string) error {
+	n, err := ParseBytes(s)
+	if err != nil {
+		return err
+	}
+	*b = Bytes(n)
+	return nil
+}
+
+var byteUnits = []string{"K", "M", "G", "T"}
+
+func ParseBytes(s string) (int64, error) {
+	num := strings.ToUpper(strings.TrimSpace(s))
+	num = strings.TrimSuffix(num, "B")
+	num = strings.TrimSuffix(num, "I") // MiB, GiB
+	mult := int64(1)
+	for i, u := range byteUnits {
+		if strings.HasSuffix(num, u) {
+			num = strings.TrimSuffix(num, u)
+			mult = 1 << (10 * (i + 1))
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return int64(f * float64(mult)), nil
+}
+
+// FormatBytes is the inverse of ParseBytes, rounded to one decimal
+func FormatBytes(n int64) string {
+	if n < 1024 {
+		return strconv.FormatInt(n, 10)
+	}
+	f := float64(n)
+	unit := ""
+	for _, u := range byteUnits {
+		if f < 1024 {
+			break
+		}
+		f /= 1024
+		unit = u
+	}
+	return strings.TrimSuffix(strconv.FormatFloat(f, 'f', 1, 64), ".0") + unit
+}
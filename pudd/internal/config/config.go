@@ -3,47 +3,174 @@ package config
 import (
 	"flag"
 	"time"
+
+	"pudd/internal/model"
 )
 
 type Config struct {
 	DBPath string
+	// worker pools, one per stage. Workers, when set, sizes every pool
+	// not given a size of its own.
 	Workers int
+	CopyWorkers int
+	ValidateWorkers int
+	ProbeWorkers int
+	HashWorkers int
+	UploadWorkers int
+	CleanWorkers int
+	// concurrent copies off one device; cards and USB readers slow down
+	// when read in parallel
+	CopyPerDevice int
+	// concurrent uploads to one destination (0 = as many as Workers)
+	UploadPerDest int
 	PollInterval time.Duration
 	Lease time.Duration
 
 	// GCS
 	Bucket string
 	ObjectPrefix string
+	// where files that failed validation are uploaded instead
+	QuarantinePrefix string
+	// object name below the prefix, with placeholders; see gcs.CheckLayout
+	ObjectLayout string
 	CredsJSON string
+	// read each object back after upload and check its SHA-256
+	VerifyReadBack bool
+	// how often the daemon audits a sample of DONE files' objects (0 = never)
+	AuditInterval time.Duration
+	AuditSample int
 
 	// Serial/device
 	MountRoot string
 	ProbeRoot string
 	StageRoot string
+	// free space kept on StageRoot; copies that would eat into it wait
+	StageReserve Bytes
+	// max bytes a device may have in staging at once (0 = no limit);
+	// `pudd devices set --quota` overrides it per device
+	DeviceQuota Bytes
 
 	// File management behavior
 	DeleteCameraAfterCopy bool
 	DeleteLocalAfterVerify bool
+
+	// Retries: attempts per stage before a file goes FAILED (0 = retry forever)
+	MaxAttemptsCopy int
+	MaxAttemptsValidate int
+	MaxAttemptsProbe int
+	MaxAttemptsHash int
+	MaxAttemptsUpload int
+	MaxAttemptsClean int
+	// how long a stage stays paused after bad credentials before trying again
+	AuthPause time.Duration
+	// same, after running out of staging space, unless space frees up sooner
+	DiskFullPause time.Duration
+
+	// on SIGINT/SIGTERM, how long in-flight copies and uploads get to finish
+	ShutdownGrace time.Duration
+
+	// Scheduling: "fair" (round-robin across devices) or "fifo"
+	Schedule string
+	// order of a device's files: discovered, smallest, oldest-capture or class
+	ScheduleOrder string
+	// with ScheduleOrder class, media classes most urgent first
+	ClassOrder string
+
+	// Observability
+	MetricsAddr string
+	LogFormat string
+	LogLevel string
 }
 
 func FromFlags() Config {
 	var cfg Config
 	flag.StringVar(&cfg.DBPath, "db", "./pudd.db", "path to sqlite DB")
-	flag.IntVar(&cfg.Workers, "workers", 2, "number of upload workers")
-	flag.DurationVar(&cfg.PollInterval, "poll", 750 * time.Millisecond, "scheduler poll interval")
-	flag.DurationVar(&cfg.Lease, "lease", 2 * time.Minute, "upload lease duration")
+	flag.IntVar(&cfg.Workers, "workers", 0, "number of workers in each stage's pool, unless set per stage with -copy-workers, -upload-workers etc. (0 = each pool's default)")
+	flag.IntVar(&cfg.CopyWorkers, "copy-workers", 2, "number of copy workers")
+	flag.IntVar(&cfg.ValidateWorkers, "validate-workers", 1, "number of workers checking copies for truncation")
+	flag.IntVar(&cfg.ProbeWorkers, "probe-workers", 1, "number of metadata probe workers")
+	flag.IntVar(&cfg.HashWorkers, "hash-workers", 1, "number of hash workers")
+	flag.IntVar(&cfg.UploadWorkers, "upload-workers", 2, "number of upload workers")
+	flag.IntVar(&cfg.CleanWorkers, "clean-workers", 1, "number of cleanup workers")
+	flag.IntVar(&cfg.CopyPerDevice, "copy-per-device", 1, "max concurrent copies off one device")
+	flag.IntVar(&cfg.UploadPerDest, "upload-per-dest", 0, "max concurrent uploads to one destination (0 = as many as upload workers)")
+	flag.DurationVar(&cfg.PollInterval, "poll", 15 * time.Second, "how often to look for work queued by other processes (pudd retry, reconcile); work from this process is picked up right away")
+	flag.DurationVar(&cfg.Lease, "lease", 2 * time.Minute, "claim lease duration; renewed every lease/3 while a worker holds the file")
 
 	flag.StringVar(&cfg.Bucket, "bucket", "", "GCS bucket name")
 	flag.StringVar(&cfg.ObjectPrefix, "prefix", "pudd", "GCS object key prefix")
+	flag.StringVar(&cfg.QuarantinePrefix, "quarantine-prefix", "pudd-quarantine", "GCS object key prefix for files that failed validation")
+	flag.StringVar(&cfg.ObjectLayout, "object-layout", "{device}/{id}.bin", "GCS object name below the prefix; placeholders {device} {id} {name} {ext}, and capture time in UTC as {date} {year} {month} {day} {hour}; must include {id}")
 	flag.StringVar(&cfg.CredsJSON, "creds", "", "path to service account JSON")
+	flag.BoolVar(&cfg.VerifyReadBack, "verify-readback", false, "after upload, download the object again and check its SHA-256 before marking it VERIFIED")
+	flag.DurationVar(&cfg.AuditInterval, "audit-interval", time.Hour, "how often to check that a sample of DONE files are still in the bucket as uploaded (0 disables)")
+	flag.IntVar(&cfg.AuditSample, "audit-sample", 20, "DONE files checked per audit, those never or least recently audited first")
 
 	flag.StringVar(&cfg.MountRoot, "mount-root", "/mnt/dock", "mount root for cameras")
 	flag.StringVar(&cfg.ProbeRoot, "probe-root", "/mnt/dock/_probe", "temporary probe mounts")
 	flag.StringVar(&cfg.StageRoot, "stage-root", "/var/lib/pudd/staging", "staging root on SSD")
+	cfg.StageReserve = 5 << 30
+	flag.Var(&cfg.StageReserve, "stage-reserve", "free space to keep on the staging filesystem, e.g. 5G")
+	flag.Var(&cfg.DeviceQuota, "device-quota", "max bytes per device in staging at once, e.g. 200G (0 = no limit)")
 
 	flag.BoolVar(&cfg.DeleteCameraAfterCopy, "delete-camera-after-copy", false, "DANGEROUS: delete camera file after successful copy (requires RW remount)")
 	flag.BoolVar(&cfg.DeleteLocalAfterVerify, "delete-local-after-verify", true, "delete staged file after GCS verify")
 
+	flag.IntVar(&cfg.MaxAttemptsCopy, "max-attempts-copy", 5, "copy attempts before a file is marked FAILED (0 = unlimited)")
+	flag.IntVar(&cfg.MaxAttemptsValidate, "max-attempts-validate", 3, "validation attempts before a file is marked FAILED (0 = unlimited)")
+	flag.IntVar(&cfg.MaxAttemptsProbe, "max-attempts-probe", 3, "probe attempts before a file is marked FAILED (0 = unlimited)")
+	flag.IntVar(&cfg.MaxAttemptsHash, "max-attempts-hash", 5, "hash attempts before a file is marked FAILED (0 = unlimited)")
+	flag.IntVar(&cfg.MaxAttemptsUpload, "max-attempts-upload", 10, "upload attempts before a file is marked FAILED (0 = unlimited)")
+	flag.IntVar(&cfg.MaxAttemptsClean, "max-attempts-clean", 5, "cleanup attempts before a file is marked FAILED (0 = unlimited)")
+
+	flag.DurationVar(&cfg.AuthPause, "auth-pause", 5 * time.Minute, "pause a stage this long after an auth error")
+	flag.DurationVar(&cfg.DiskFullPause, "disk-full-pause", 5 * time.Minute, "max pause of a stage after running out of staging space")
+
+	flag.DurationVar(&cfg.ShutdownGrace, "shutdown-grace", 30 * time.Second, "on shutdown, time in-flight work gets to finish before it is interrupted")
+
+	flag.StringVar(&cfg.Schedule, "schedule", "fair", "scheduler: fair (round-robin across devices, by device priority) or fifo")
+	flag.StringVar(&cfg.ScheduleOrder, "order", "discovered", "order of each device's files: discovered, smallest, oldest-capture or class")
+	flag.StringVar(&cfg.ClassOrder, "class-order", "video,audio,photo,other", "with -order class, media classes most urgent first")
+
+	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", "127.0.0.1:9273", "listen address for the Prometheus /metrics endpoint (empty disables); loopback only by default, as metrics name devices and paths")
+
+	flag.StringVar(&cfg.LogFormat, "log-format", "text", "log output format: text or json")
+	flag.StringVar(&cfg.LogLevel, "log-level", "info", "minimum log level: debug, info, warn or error")
+
 	flag.Parse()
+	if cfg.Workers > 0 {
+		// -workers used to size the one pool there was; it still sizes them all
+		pools := map[string]*int{
+			"copy-workers": &cfg.CopyWorkers,
+			"validate-workers": &cfg.ValidateWorkers,
+			"probe-workers": &cfg.ProbeWorkers,
+			"hash-workers": &cfg.HashWorkers,
+			"upload-workers": &cfg.UploadWorkers,
+			"clean-workers": &cfg.CleanWorkers,
+		}
+		flag.Visit(func(f *flag.Flag) { delete(pools, f.Name) })
+		for _, n := range pools {
+			*n = cfg.Workers
+		}
+	}
 	return cfg
-}
\ No newline at end of file
+}
+
+// MaxAttempts returns the attempt limit for a stage (0 = unlimited)
+func (c Config) MaxAttempts(stage model.Stage) int {
+	switch stage {
+	case model.StageCopy:
+		return c.MaxAttemptsCopy
+	case model.StageValidate:
+		return c.MaxAttemptsValidate
+	case model.StageProbe:
+		return c.MaxAttemptsProbe
+	case model.StageHash:
+		return c.MaxAttemptsHash
+	case model.StageUpload:
+		return c.MaxAttemptsUpload
+	case model.StageClean:
+		return c.MaxAttemptsClean
+	}
+	return 0
+}
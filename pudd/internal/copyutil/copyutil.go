@@ -1,51 +1,91 @@
 package copyutil
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+
+	"pudd/internal/errclass"
+	"pudd/internal/logging"
+	"pudd/internal/metrics"
 )
 
-func CopyAtomic(src, dst string) error {
+// CopyAtomic copies src to dst through a tmp file, so dst either doesn't
+// exist or is complete. Errors are classified (see errclass); a missing src
+// is reported as DeviceAbsent since that is what an unplugged card looks like.
+// Cancelling ctx stops the copy and removes the tmp file.
+func CopyAtomic(ctx context.Context, src, dst string) error {
 	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
-		return err
+		return errclass.FromOS(err)
 	}
 
 	tmp := dst + ".tmp"
 
 	in, err := os.Open(src)
 	if err != nil {
-		return err
+		if os.IsNotExist(err) {
+			return errclass.Wrap(errclass.DeviceAbsent, err)
+		}
+		return errclass.FromOS(err)
 	}
 	defer in.Close()
 
 	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
 	if err != nil {
-		return err
+		return errclass.FromOS(err)
 	}
 
-	_, copyErr := io.Copy(out, in)
+	n, copyErr := io.Copy(out, Reader(ctx, in))
+	metrics.Transferred(metrics.StageCopy, n, copyErr == nil)
 	syncErr := out.Sync()
 	closeErr := out.Close()
 
 	if copyErr != nil {
-		_ = os.Remove(tmp)
-		return copyErr
+		removeTmp(tmp)
+		return errclass.FromOS(copyErr)
 	}
 	if syncErr != nil {
-		_ = os.Remove(tmp)
-		return syncErr
+		removeTmp(tmp)
+		return errclass.FromOS(syncErr)
 	}
 	if closeErr != nil {
-		_ = os.Remove(tmp)
-		return closeErr
+		removeTmp(tmp)
+		return errclass.FromOS(closeErr)
 	}
 
 	// Atomic rename: tmp -> final
 	if err := os.Rename(tmp, dst); err != nil {
-		_ = os.Remove(tmp)
-		return fmt.Errorf("rename tmp->final: %w", err)
+		removeTmp(tmp)
+		return errclass.FromOS(fmt.Errorf("rename tmp->final: %w", err))
 	}
 	return nil
 }
+
+// removeTmp cleans up after a failed copy; the copy error is what gets
+// returned, so a leftover tmp file is only worth a warning
+func removeTmp(tmp string) {
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		slog.Warn("remove tmp file failed", "path", tmp, logging.Err, err)
+	}
+}
+
+// Reader makes reads from r fail with ctx's error once ctx is done, so long
+// copies can be interrupted between chunks
+func Reader(ctx context.Context, r io.Reader) io.Reader {
+	return ctxReader{ctx, r}
+}
+
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
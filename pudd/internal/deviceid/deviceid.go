@@ -44,15 +44,17 @@ func Derive(mountPoint string, udevProps map[string]string) (deviceID string, so
 	return "usb-" + hex.EncodeToString(h[:8]), SourceDevPath
 }
 
-func readPuddID(mountPoint string) (string, bool) {
+func puddPaths(mountPoint string) []string {
 	// Primary location: DCIM/.pudd
-	paths := []string{
+	return []string{
 		filepath.Join(mountPoint, "DCIM", ".pudd"),
 		// optional fallbacks if you want:
 		// filepath.Join(mountPoint, ".pudd"),
 	}
+}
 
-	for _, p := range paths {
+func readPuddID(mountPoint string) (string, bool) {
+	for _, p := range puddPaths(mountPoint) {
 		b, err := os.ReadFile(p)
 		if err != nil {
 			continue
@@ -74,6 +76,34 @@ func readPuddID(mountPoint string) (string, bool) {
 	return "", false
 }
 
+// Labels reads the optional label= and owner= lines of DCIM/.pudd, e.g.
+//
+//	pudd_id=cam-a
+//	label=A-cam card 3
+//	owner=second-unit
+func Labels(mountPoint string) (label, owner string) {
+	for _, p := range puddPaths(mountPoint) {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			key, val, ok := strings.Cut(strings.TrimSpace(line), "=")
+			if !ok {
+				continue
+			}
+			switch strings.TrimSpace(key) {
+			case "label":
+				label = strings.TrimSpace(val)
+			case "owner":
+				owner = strings.TrimSpace(val)
+			}
+		}
+		return label, owner
+	}
+	return "", ""
+}
+
 func sanitize(s string) string {
 	// Keep it path-safe for mount folders and cloud prefixes.
 	// Replace whitespace with underscores; remove path separators.
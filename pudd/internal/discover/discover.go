@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -12,14 +13,56 @@ import (
 	"pudd/internal/store"
 )
 
-// DiscoverAndInsert scans known media directories and inserts DISCOVERED rows
-func DiscoverAndInsert(ctx context.Context, db *sql.DB, deviceID, mountPoint, stageRoot string) error {
+// extensions picked up, lowercase, and the top-level directories under the
+// mount each is looked for in, none meaning anywhere. Cameras keep clips in
+// Movies or, like GoPro, DJI and Insta360, in DCIM next to their stills,
+// with DJI's telemetry in .srt sidecars next to the clips. Recorders put
+// audio wherever their folder and project settings say.
+var (
+	clips  = []string{"Movies", "DCIM"}
+	stills = []string{"DCIM"}
+
+	discovered = map[string][]string{
+		".mp4": clips, ".mov": clips, ".m4v": clips, ".insv": clips, ".lrv": clips,
+		".srt": clips,
+		".jpg": stills, ".jpeg": stills, ".heic": stills, ".heif": stills, ".dng": stills, ".arw": stills,
+		".wav": nil,
+	}
+)
+
+// wanted reports whether the file at rel, slash-separated below the mount,
+// is one to ingest
+func wanted(rel string) bool {
+	dirs, known := discovered[strings.ToLower(path.Ext(rel))]
+	if !known {
+		return false
+	}
+	if len(dirs) == 0 {
+		return true
+	}
+	top, _, nested := strings.Cut(rel, "/")
+	if !nested {
+		return false
+	}
+	for _, d := range dirs {
+		// FAT is case-insensitive, and cameras don't agree on case
+		if strings.EqualFold(top, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// DiscoverAndInsert scans known media directories and inserts DISCOVERED rows.
+// Returns the number of rows that were new.
+func DiscoverAndInsert(ctx context.Context, db *sql.DB, deviceID, mountPoint, stageRoot string) (int, error) {
+	inserted := 0
 	roots := []string{
-		filepath.Join(mountPoint, "Movies"),
+		mountPoint,
 	}
 
 	for _, root := range roots {
-		// skip if movies/ doesn't exist
+		// skip if the mount is gone
 		if _, err := os.Stat(root); err != nil {
 			continue
 		}
@@ -33,25 +76,32 @@ func DiscoverAndInsert(ctx context.Context, db *sql.DB, deviceID, mountPoint, st
 				return ctx.Err()
 			default:
 			}
-			if d.IsDir() {
+			// hidden directories are the OS's (.Trashes, .Spotlight-V100),
+			// and ._ files macOS's resource forks, not media
+			if strings.HasPrefix(d.Name(), ".") && path != root {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
 				return nil
 			}
-
-			// files are in mp4
-			if !strings.HasSuffix(strings.ToLower(d.Name()), ".mp4") {
+			if d.IsDir() {
 				return nil
 			}
 
-			info, err := d.Info()
+			rel, err := filepath.Rel(mountPoint, path)
 			if err != nil {
 				return err
 			}
+			rel = filepath.ToSlash(rel)
 
-			rel, err := filepath.Rel(mountPoint, path)
+			if !wanted(rel) {
+				return nil
+			}
+
+			info, err := d.Info()
 			if err != nil {
 				return err
 			}
-			rel = filepath.ToSlash(rel)
 
 			srcRel := "/" + rel
 			stagedPath := filepath.Join(stageRoot, deviceID, filepath.FromSlash(rel))
@@ -62,14 +112,21 @@ func DiscoverAndInsert(ctx context.Context, db *sql.DB, deviceID, mountPoint, st
 				StagedPath: stagedPath,
 				Size: info.Size(),
 				State: model.StateDiscovered,
+				// cameras write the file when recording stops, by their own
+				// clock; see model.Clock
+				MTime: info.ModTime(),
 			}
-			return store.InsertDiscovered(db, row)
+			ok, err := store.InsertDiscovered(db, row)
+			if ok {
+				inserted++
+			}
+			return err
 		})
 
 		if err != nil {
-			return err
+			return inserted, err
 		}
 	}
 
-	return nil
+	return inserted, nil
 }
@@ -0,0 +1,99 @@
+package discover
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"pudd/internal/model"
+	"pudd/internal/store"
+)
+
+func TestDiscoverAndInsert(t *testing.T) {
+	mount := t.TempDir()
+	for _, rel := range []string{
+		"Movies/clip.mp4",
+		"DCIM/100GOPRO/GX010001.MP4",
+		"DCIM/100GOPRO/GL010001.LRV",
+		"DCIM/100MEDIA/DJI_0001.MP4",
+		"DCIM/100MEDIA/DJI_0001.SRT",
+		"DCIM/100MSDCF/DSC00001.JPG",
+		"DCIM/100MSDCF/DSC00001.ARW",
+		"DCIM/Camera01/VID_0001.insv",
+		"DCIM/100APPLE/IMG_0001.HEIC",
+		"DCIM/100APPLE/IMG_0002.MOV",
+		"dcim/100CANON/IMG_0001.jpeg",
+		"Sound/Day1/T001.WAV",
+		"T002.wav",
+		// not media, not where the camera keeps it, or not the camera's
+		"DCIM/100MSDCF/._DSC00001.JPG",
+		"DCIM/.thumbnails/DSC00001.JPG",
+		".Trashes/501/old.mp4",
+		"Photos/export.jpg",
+		"DSC00002.JPG",
+		"Movies/notes.txt",
+		"MISC/settings.dat",
+	} {
+		path := filepath.Join(mount, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(rel), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, err := store.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	n, err := DiscoverAndInsert(context.Background(), db, "cam", mount, "/stage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"/DCIM/100APPLE/IMG_0001.HEIC",
+		"/DCIM/100APPLE/IMG_0002.MOV",
+		"/DCIM/100GOPRO/GL010001.LRV",
+		"/DCIM/100GOPRO/GX010001.MP4",
+		"/DCIM/100MEDIA/DJI_0001.MP4",
+		"/DCIM/100MEDIA/DJI_0001.SRT",
+		"/DCIM/100MSDCF/DSC00001.ARW",
+		"/DCIM/100MSDCF/DSC00001.JPG",
+		"/DCIM/Camera01/VID_0001.insv",
+		"/Movies/clip.mp4",
+		"/Sound/Day1/T001.WAV",
+		"/T002.wav",
+		"/dcim/100CANON/IMG_0001.jpeg",
+	}
+	if n != len(want) {
+		t.Errorf("inserted %d, want %d", n, len(want))
+	}
+
+	files, err := store.ListFiles(db, store.ListFilter{DeviceID: "cam"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range files {
+		got = append(got, f.SrcPath)
+		if f.State != model.StateDiscovered {
+			t.Errorf("%s: state %s", f.SrcPath, f.State)
+		}
+		if stage := filepath.Join("/stage", "cam", filepath.FromSlash(f.SrcPath)); f.StagedPath != stage {
+			t.Errorf("%s: staged at %s, want %s", f.SrcPath, f.StagedPath, stage)
+		}
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("discovered\n%v\nwant\n%v", got, want)
+	}
+
+	// a rescan finds nothing new
+	if n, err := DiscoverAndInsert(context.Background(), db, "cam", mount, "/stage"); err != nil || n != 0 {
+		t.Errorf("rescan: %d new, err %v", n, err)
+	}
+}
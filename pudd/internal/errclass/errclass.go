@@ -0,0 +1,82 @@
+package errclass
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+// Errors from copyutil, hash, mount and the uploaders carry a Class that
+// tells the store how to retry the file. Unclassified errors are Transient.
+
+type Class string
+
+const (
+	// worth retrying with backoff: flaky I/O, 5xx, timeouts
+	Transient Class = "transient"
+	// the card went away; wait for it to be plugged back in
+	DeviceAbsent Class = "device_absent"
+	// out of disk or quota; retrying before something frees up is pointless
+	ResourceExhausted Class = "resource_exhausted"
+	// retrying can't help: bad request, file gone from a mounted card
+	Permanent Class = "permanent"
+	// credentials rejected; affects every file, not just this one
+	Auth Class = "auth"
+)
+
+type Error struct {
+	Class Class
+	Err   error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// Wrap tags err with class. nil stays nil, and an error that already has a
+// class keeps it.
+func Wrap(class Class, err error) error {
+	if err == nil {
+		return nil
+	}
+	var ce *Error
+	if errors.As(err, &ce) {
+		return err
+	}
+	return &Error{Class: class, Err: err}
+}
+
+// Wrapf is Wrap with a fmt.Errorf message; use %w for err
+func Wrapf(class Class, format string, args ...any) error {
+	return &Error{Class: class, Err: fmt.Errorf(format, args...)}
+}
+
+// Of returns the class of err, Transient if it has none
+func Of(err error) Class {
+	var ce *Error
+	if errors.As(err, &ce) {
+		return ce.Class
+	}
+	return Transient
+}
+
+// FromOS classifies a filesystem error by errno. Missing files are left to
+// the caller: whether that means the card is gone or the file is depends on
+// which side of the copy it happened.
+func FromOS(err error) error {
+	if err == nil {
+		return nil
+	}
+	var class Class
+	switch {
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		class = ResourceExhausted
+	case errors.Is(err, syscall.ENODEV), errors.Is(err, syscall.ENXIO), errors.Is(err, syscall.ESTALE):
+		class = DeviceAbsent
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, syscall.EROFS):
+		class = Permanent
+	default:
+		class = Transient
+	}
+	return Wrap(class, err)
+}
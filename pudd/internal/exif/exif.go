@@ -0,0 +1,226 @@
+package exif
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"pudd/internal/errclass"
+	"pudd/internal/mp4"
+)
+
+// A reader for the EXIF and XMP metadata of stills: JPEG, HEIC, and the
+// TIFF-based raw formats (DNG, ARW). Only the metadata is read.
+
+// Info is what a still says about itself. Fields it doesn't record are zero.
+type Info struct {
+	Format string `json:"format"` // jpeg, heic or tiff
+	Make   string `json:"make"`
+	Model  string `json:"model"`
+	Serial string `json:"serial"`
+	Lens   string `json:"lens"`
+	// when the shutter fired; Zoned is false if the file gives no UTC
+	// offset, in which case CapturedAt is camera local time labelled UTC
+	CapturedAt  time.Time `json:"captured_at"`
+	Zoned       bool      `json:"zoned"`
+	Orientation int       `json:"orientation"` // EXIF 1-8, 0 if not recorded
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	GPS         *GPS      `json:"gps,omitempty"`
+}
+
+// GPS is a position in decimal degrees, altitude in metres above sea level
+type GPS struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+	Alt float64 `json:"alt"`
+}
+
+var ErrUnknownFormat = errors.New("not a JPEG, HEIC or TIFF file")
+
+// ReadFile reads the metadata of the still at path
+func ReadFile(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, errclass.FromOS(err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return Info{}, errclass.FromOS(err)
+	}
+	return Read(f, st.Size())
+}
+
+// Read reads the metadata of a still of the given size, telling the format
+// from its first bytes
+func Read(r io.ReaderAt, size int64) (Info, error) {
+	var magic [12]byte
+	if _, err := r.ReadAt(magic[:], 0); err != nil {
+		return Info{}, ErrUnknownFormat
+	}
+
+	var info Info
+	var err error
+	switch {
+	case magic[0] == 0xff && magic[1] == 0xd8:
+		info.Format = "jpeg"
+		err = readJPEG(r, size, &info)
+	case string(magic[:4]) == "II*\x00" || string(magic[:4]) == "MM\x00*":
+		info.Format = "tiff"
+		err = readTIFF(r, size, &info, true)
+	case string(magic[4:8]) == "ftyp":
+		info.Format = "heic"
+		err = readHEIF(r, size, &info)
+	default:
+		return Info{}, ErrUnknownFormat
+	}
+	if err != nil {
+		return Info{}, fmt.Errorf("%s: %w", info.Format, err)
+	}
+	return info, nil
+}
+
+// readTIFF reads the EXIF in a TIFF block. In a raw file IFD0 may carry XMP
+// too; xmp says whether to look for it.
+func readTIFF(r io.ReaderAt, size int64, info *Info, xmp bool) error {
+	t, off, err := newTIFF(r, size)
+	if err != nil {
+		return err
+	}
+	ifd0, err := t.ifd(off)
+	if err != nil {
+		return err
+	}
+	exif := t.sub(ifd0, tagExifIFD)
+	gps := t.sub(ifd0, tagGPSIFD)
+
+	set(&info.Make, t.str(ifd0, tagMake))
+	set(&info.Model, t.str(ifd0, tagModel))
+	set(&info.Serial, t.str(exif, tagBodySerial))
+	set(&info.Serial, t.str(ifd0, tagDNGSerial))
+	lens := t.str(exif, tagLensModel)
+	if mk := t.str(exif, tagLensMake); mk != "" && lens != "" && !strings.HasPrefix(lens, mk) {
+		lens = mk + " " + lens
+	}
+	set(&info.Lens, lens)
+	if o, ok := t.uint(ifd0, tagOrientation); ok && info.Orientation == 0 {
+		info.Orientation = int(o)
+	}
+	if info.Width == 0 {
+		w, _ := t.uint(exif, tagPixelX)
+		h, _ := t.uint(exif, tagPixelY)
+		if w == 0 {
+			// a raw file's IFD0 describes the full-size image
+			w, _ = t.uint(ifd0, tagWidth)
+			h, _ = t.uint(ifd0, tagHeight)
+		}
+		info.Width, info.Height = int(w), int(h)
+	}
+
+	if info.CapturedAt.IsZero() {
+		date := t.str(exif, tagDateOriginal)
+		if date == "" {
+			date = t.str(ifd0, tagDateTime)
+		}
+		info.CapturedAt, info.Zoned = exifTime(date, t.str(exif, tagSubSecOrig), t.str(exif, tagOffsetOrig))
+	}
+
+	if gps != nil && info.GPS == nil {
+		lat, lon := t.rationals(gps, tagGPSLat), t.rationals(gps, tagGPSLon)
+		if len(lat) == 3 && len(lon) == 3 {
+			g := &GPS{Lat: dms(lat), Lon: dms(lon)}
+			if t.str(gps, tagGPSLatRef) == "S" {
+				g.Lat = -g.Lat
+			}
+			if t.str(gps, tagGPSLonRef) == "W" {
+				g.Lon = -g.Lon
+			}
+			if alt := t.rationals(gps, tagGPSAlt); len(alt) == 1 {
+				g.Alt = alt[0]
+				if ref, _ := t.uint(gps, tagGPSAltRef); ref == 1 {
+					g.Alt = -g.Alt
+				}
+			}
+			info.GPS = g
+		}
+	}
+
+	if xmp {
+		if e, ok := ifd0[tagXMP]; ok {
+			readXMP(string(t.value(e)), info)
+		}
+	}
+	return nil
+}
+
+func readHEIF(r io.ReaderAt, size int64, info *Info) error {
+	items, err := mp4.HEIFItems(r, size)
+	if err != nil {
+		return err
+	}
+	for _, it := range items {
+		switch {
+		case it.Type == "Exif":
+			b, err := it.Read(r)
+			if err != nil {
+				return err
+			}
+			// the item starts with the offset of the TIFF header past itself
+			if len(b) < 4 {
+				continue
+			}
+			skip := 4 + int(uint32(b[0])<<24|uint32(b[1])<<16|uint32(b[2])<<8|uint32(b[3]))
+			if skip > len(b) {
+				continue
+			}
+			tb := b[skip:]
+			if err := readTIFF(bytes.NewReader(tb), int64(len(tb)), info, false); err != nil {
+				return err
+			}
+		case it.Type == "mime" && it.ContentType == "application/rdf+xml":
+			b, err := it.Read(r)
+			if err != nil {
+				return err
+			}
+			readXMP(string(b), info)
+		}
+	}
+	return nil
+}
+
+// exifTime parses an EXIF date, "2006:01:02 15:04:05", with its sub-second
+// and offset tags
+func exifTime(date, subsec, offset string) (time.Time, bool) {
+	t, err := time.Parse("2006:01:02 15:04:05", date)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if subsec != "" {
+		if d, err := time.ParseDuration("0." + subsec + "s"); err == nil {
+			t = t.Add(d)
+		}
+	}
+	if zoned, err := time.Parse("-07:00", offset); err == nil {
+		_, secs := zoned.Zone()
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(),
+			time.FixedZone("", secs)), true
+	}
+	return t, false
+}
+
+// dms turns degrees, minutes and seconds into decimal degrees
+func dms(v []float64) float64 {
+	return v[0] + v[1]/60 + v[2]/3600
+}
+
+// set fills in *dst unless something more authoritative already did
+func set(dst *string, v string) {
+	if *dst == "" {
+		*dst = v
+	}
+}
@@ -0,0 +1,195 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// testTag is an IFD entry to write; data is the value in little endian
+type testTag struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+func asciiTag(tag uint16, s string) testTag {
+	return testTag{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func shortTag(tag uint16, v uint16) testTag {
+	return testTag{tag, 3, 1, binary.LittleEndian.AppendUint16(nil, v)}
+}
+
+func rationalTag(tag uint16, vals ...[2]uint32) testTag {
+	var b []byte
+	for _, v := range vals {
+		b = binary.LittleEndian.AppendUint32(b, v[0])
+		b = binary.LittleEndian.AppendUint32(b, v[1])
+	}
+	return testTag{tag, 5, uint32(len(vals)), b}
+}
+
+// buildTIFF writes a little-endian TIFF block with IFD0 and, if given, an
+// EXIF and a GPS IFD linked from it
+func buildTIFF(ifd0, exifIFD, gpsIFD []testTag) []byte {
+	ifds := [][]testTag{ifd0}
+	pointers := []uint16{}
+	if exifIFD != nil {
+		ifds, pointers = append(ifds, exifIFD), append(pointers, tagExifIFD)
+	}
+	if gpsIFD != nil {
+		ifds, pointers = append(ifds, gpsIFD), append(pointers, tagGPSIFD)
+	}
+	size := func(tags []testTag) int { return 2 + 12*len(tags) + 4 }
+
+	// IFDs follow the header, their long values follow the IFDs
+	offsets := []int{8}
+	end := 8 + size(ifd0) + 12*len(pointers)
+	for _, d := range ifds[1:] {
+		offsets = append(offsets, end)
+		end += size(d)
+	}
+	for i, tag := range pointers {
+		ifds[0] = append(ifds[0], testTag{tag, 4, 1, binary.LittleEndian.AppendUint32(nil, uint32(offsets[i+1]))})
+	}
+
+	out := []byte("II*\x00")
+	out = binary.LittleEndian.AppendUint32(out, 8)
+	var data []byte
+	for _, d := range ifds {
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(d)))
+		for _, t := range d {
+			out = binary.LittleEndian.AppendUint16(out, t.tag)
+			out = binary.LittleEndian.AppendUint16(out, t.typ)
+			out = binary.LittleEndian.AppendUint32(out, t.count)
+			if len(t.data) <= 4 {
+				out = append(out, t.data...)
+				out = append(out, make([]byte, 4-len(t.data))...)
+				continue
+			}
+			out = binary.LittleEndian.AppendUint32(out, uint32(end+len(data)))
+			data = append(data, t.data...)
+		}
+		out = binary.LittleEndian.AppendUint32(out, 0)
+	}
+	return append(out, data...)
+}
+
+// buildJPEG wraps EXIF and XMP in APP1 segments ahead of a baseline frame
+// header of w x h
+func buildJPEG(exif []byte, xmp string, w, h uint16) []byte {
+	segment := func(marker byte, body []byte) []byte {
+		b := []byte{0xff, marker}
+		b = binary.BigEndian.AppendUint16(b, uint16(len(body)+2))
+		return append(b, body...)
+	}
+	out := []byte{0xff, 0xd8}
+	if exif != nil {
+		out = append(out, segment(0xe1, append([]byte(exifPrefix), exif...))...)
+	}
+	if xmp != "" {
+		out = append(out, segment(0xe1, append([]byte(xmpPrefix), xmp...))...)
+	}
+	sof := []byte{8}
+	sof = binary.BigEndian.AppendUint16(sof, h)
+	sof = binary.BigEndian.AppendUint16(sof, w)
+	sof = append(sof, 1, 1, 0x11, 0)
+	out = append(out, segment(0xc0, sof)...)
+	return append(out, 0xff, 0xd9)
+}
+
+func sampleTIFF() []byte {
+	return buildTIFF(
+		[]testTag{
+			asciiTag(tagMake, "SONY"),
+			asciiTag(tagModel, "ILCE-7M4 "),
+			shortTag(tagOrientation, 6),
+			asciiTag(tagDateTime, "2024:05:01 09:00:00"),
+		},
+		[]testTag{
+			asciiTag(tagDateOriginal, "2024:05:01 10:11:12"),
+			asciiTag(tagOffsetOrig, "+02:00"),
+			asciiTag(tagSubSecOrig, "25"),
+			shortTag(tagPixelX, 6000),
+			shortTag(tagPixelY, 4000),
+			asciiTag(tagBodySerial, "1234567"),
+			asciiTag(tagLensModel, "FE 24-70mm F2.8 GM II"),
+		},
+		[]testTag{
+			asciiTag(tagGPSLatRef, "N"),
+			rationalTag(tagGPSLat, [2]uint32{52, 1}, [2]uint32{30, 1}, [2]uint32{0, 1}),
+			asciiTag(tagGPSLonRef, "W"),
+			rationalTag(tagGPSLon, [2]uint32{13, 1}, [2]uint32{15, 1}, [2]uint32{36, 1}),
+			rationalTag(tagGPSAlt, [2]uint32{345, 10}),
+		},
+	)
+}
+
+func TestReadJPEG(t *testing.T) {
+	b := buildJPEG(sampleTIFF(), "", 1920, 1080)
+	info, err := Read(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Format != "jpeg" || info.Make != "SONY" || info.Model != "ILCE-7M4" || info.Serial != "1234567" {
+		t.Errorf("camera: got %+v", info)
+	}
+	if info.Lens != "FE 24-70mm F2.8 GM II" || info.Orientation != 6 {
+		t.Errorf("lens, orientation: got %q, %d", info.Lens, info.Orientation)
+	}
+	// EXIF's pixel dimensions win over the frame header's
+	if info.Width != 6000 || info.Height != 4000 {
+		t.Errorf("size: got %dx%d", info.Width, info.Height)
+	}
+	want := time.Date(2024, 5, 1, 8, 11, 12, 250_000_000, time.UTC)
+	if !info.Zoned || !info.CapturedAt.Equal(want) {
+		t.Errorf("captured: got %v zoned=%v, want %v", info.CapturedAt, info.Zoned, want)
+	}
+	if g := info.GPS; g == nil || g.Lat != 52.5 || g.Lon != -(13+15.0/60+36.0/3600) || g.Alt != 34.5 {
+		t.Errorf("gps: got %+v", info.GPS)
+	}
+}
+
+func TestReadJPEGWithoutExif(t *testing.T) {
+	xmp := `<x:xmpmeta><rdf:RDF><rdf:Description tiff:Make="Canon" tiff:Model="EOS R5"
+ aux:SerialNumber="0042" exif:DateTimeOriginal="2024-05-01T10:11:12">
+ <aux:Lens>RF24-105mm F4 L IS USM</aux:Lens></rdf:Description></rdf:RDF></x:xmpmeta>`
+	b := buildJPEG(nil, xmp, 1920, 1080)
+	info, err := Read(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Make != "Canon" || info.Model != "EOS R5" || info.Serial != "0042" || info.Lens != "RF24-105mm F4 L IS USM" {
+		t.Errorf("camera from XMP: got %+v", info)
+	}
+	if info.Width != 1920 || info.Height != 1080 {
+		t.Errorf("size from frame header: got %dx%d", info.Width, info.Height)
+	}
+	want := time.Date(2024, 5, 1, 10, 11, 12, 0, time.UTC)
+	if info.Zoned || !info.CapturedAt.Equal(want) {
+		t.Errorf("captured: got %v zoned=%v, want %v", info.CapturedAt, info.Zoned, want)
+	}
+}
+
+func TestReadRaw(t *testing.T) {
+	b := sampleTIFF()
+	info, err := Read(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != "tiff" || info.Model != "ILCE-7M4" || info.Width != 6000 {
+		t.Errorf("got %+v", info)
+	}
+}
+
+func TestReadUnknown(t *testing.T) {
+	b := []byte("RIFF\x00\x00\x00\x00WAVEfmt ")
+	if _, err := Read(bytes.NewReader(b), int64(len(b))); err != ErrUnknownFormat {
+		t.Errorf("got %v, want ErrUnknownFormat", err)
+	}
+}
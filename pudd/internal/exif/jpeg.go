@@ -0,0 +1,89 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	exifPrefix = "Exif\x00\x00"
+	xmpPrefix  = "http://ns.adobe.com/xap/1.0/\x00"
+)
+
+// readJPEG walks the marker segments up to the image data, reading EXIF and
+// XMP from APP1 and the image size from the frame header
+func readJPEG(r io.ReaderAt, size int64, info *Info) error {
+	var exif, xmp []byte
+	var w, h int
+	var hdr [4]byte
+	for off := int64(2); off+4 <= size; {
+		if _, err := r.ReadAt(hdr[:], off); err != nil {
+			return err
+		}
+		if hdr[0] != 0xff {
+			return fmt.Errorf("no marker at %d", off)
+		}
+		marker := hdr[1]
+		switch {
+		case marker == 0xff: // fill byte
+			off++
+			continue
+		case marker == 0xd8 || (marker >= 0xd0 && marker <= 0xd7) || marker == 0x01:
+			// no length
+			off += 2
+			continue
+		case marker == 0xda || marker == 0xd9:
+			// start of scan or end of image: no metadata past here
+			off = size
+			continue
+		}
+
+		n := int64(binary.BigEndian.Uint16(hdr[2:]))
+		if n < 2 || off+2+n > size {
+			return fmt.Errorf("segment %#x at %d runs past the end", marker, off)
+		}
+		body := func() ([]byte, error) {
+			b := make([]byte, n-2)
+			_, err := r.ReadAt(b, off+4)
+			return b, err
+		}
+		switch {
+		case marker == 0xe1:
+			b, err := body()
+			if err != nil {
+				return err
+			}
+			switch {
+			case exif == nil && bytes.HasPrefix(b, []byte(exifPrefix)):
+				exif = b[len(exifPrefix):]
+			case xmp == nil && bytes.HasPrefix(b, []byte(xmpPrefix)):
+				xmp = b[len(xmpPrefix):]
+			}
+		case marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc:
+			// frame header: precision, height, width
+			b, err := body()
+			if err != nil {
+				return err
+			}
+			if len(b) >= 5 {
+				h, w = int(binary.BigEndian.Uint16(b[1:])), int(binary.BigEndian.Uint16(b[3:]))
+			}
+		}
+		off += 2 + n
+	}
+
+	if exif != nil {
+		if err := readTIFF(bytes.NewReader(exif), int64(len(exif)), info, false); err != nil {
+			return err
+		}
+	}
+	if xmp != nil {
+		readXMP(string(xmp), info)
+	}
+	if info.Width == 0 {
+		info.Width, info.Height = w, h
+	}
+	return nil
+}
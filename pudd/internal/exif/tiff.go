@@ -0,0 +1,196 @@
+package exif
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TIFF structure: a header naming the byte order and the first IFD, and
+// IFDs of 12-byte entries whose values sit inline when they fit in 4 bytes.
+// EXIF in JPEG and HEIC is a TIFF block; DNG and ARW files are TIFFs.
+
+// tags read from IFD0, the EXIF IFD and the GPS IFD
+const (
+	tagWidth        = 0x0100
+	tagHeight       = 0x0101
+	tagMake         = 0x010f
+	tagModel        = 0x0110
+	tagOrientation  = 0x0112
+	tagDateTime     = 0x0132
+	tagXMP          = 0x02bc
+	tagExifIFD      = 0x8769
+	tagGPSIFD       = 0x8825
+	tagDateOriginal = 0x9003
+	tagOffsetOrig   = 0x9011
+	tagSubSecOrig   = 0x9291
+	tagPixelX       = 0xa002
+	tagPixelY       = 0xa003
+	tagBodySerial   = 0xa431
+	tagLensMake     = 0xa433
+	tagLensModel    = 0xa434
+	tagDNGSerial    = 0xc62f
+
+	tagGPSLatRef = 1
+	tagGPSLat    = 2
+	tagGPSLonRef = 3
+	tagGPSLon    = 4
+	tagGPSAltRef = 5
+	tagGPSAlt    = 6
+)
+
+// bytes per value, by TIFF field type
+var typeSize = map[uint16]int64{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// values larger than this are skipped; nothing read here comes close
+const maxValue = 1 << 20
+
+type tiff struct {
+	r    io.ReaderAt
+	size int64
+	bo   binary.ByteOrder
+}
+
+type entry struct {
+	typ   uint16
+	count uint32
+	raw   [4]byte // the value, or its offset
+}
+
+type ifd map[uint16]entry
+
+func newTIFF(r io.ReaderAt, size int64) (*tiff, int64, error) {
+	var h [8]byte
+	if _, err := r.ReadAt(h[:], 0); err != nil {
+		return nil, 0, fmt.Errorf("tiff header: %w", err)
+	}
+	t := &tiff{r: r, size: size}
+	switch string(h[:4]) {
+	case "II*\x00":
+		t.bo = binary.LittleEndian
+	case "MM\x00*":
+		t.bo = binary.BigEndian
+	default:
+		return nil, 0, errors.New("no tiff header")
+	}
+	return t, int64(t.bo.Uint32(h[4:])), nil
+}
+
+// ifd reads the IFD at off
+func (t *tiff) ifd(off int64) (ifd, error) {
+	var b [12]byte
+	if off <= 0 || off+2 > t.size {
+		return nil, fmt.Errorf("ifd offset %d out of range", off)
+	}
+	if _, err := t.r.ReadAt(b[:2], off); err != nil {
+		return nil, err
+	}
+	n := int64(t.bo.Uint16(b[:]))
+	if off+2+n*12 > t.size {
+		return nil, fmt.Errorf("ifd at %d: %d entries run past the end", off, n)
+	}
+	out := ifd{}
+	for i := int64(0); i < n; i++ {
+		if _, err := t.r.ReadAt(b[:], off+2+i*12); err != nil {
+			return nil, err
+		}
+		e := entry{typ: t.bo.Uint16(b[2:]), count: t.bo.Uint32(b[4:])}
+		copy(e.raw[:], b[8:])
+		out[t.bo.Uint16(b[:])] = e
+	}
+	return out, nil
+}
+
+// sub reads the IFD a pointer tag in d points at; nil if there is none
+func (t *tiff) sub(d ifd, tag uint16) ifd {
+	e, ok := d[tag]
+	if !ok {
+		return nil
+	}
+	sub, err := t.ifd(int64(t.bo.Uint32(e.raw[:])))
+	if err != nil {
+		return nil
+	}
+	return sub
+}
+
+func (t *tiff) value(e entry) []byte {
+	n := typeSize[e.typ] * int64(e.count)
+	if n == 0 || n > maxValue {
+		return nil
+	}
+	if n <= 4 {
+		return e.raw[:n]
+	}
+	off := int64(t.bo.Uint32(e.raw[:]))
+	if off+n > t.size {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := t.r.ReadAt(b, off); err != nil {
+		return nil
+	}
+	return b
+}
+
+func (t *tiff) str(d ifd, tag uint16) string {
+	e, ok := d[tag]
+	if !ok || (e.typ != 2 && e.typ != 7) {
+		return ""
+	}
+	return clean(string(t.value(e)))
+}
+
+// uint reads the first value of a BYTE, SHORT or LONG tag
+func (t *tiff) uint(d ifd, tag uint16) (uint32, bool) {
+	e, ok := d[tag]
+	if !ok {
+		return 0, false
+	}
+	b := t.value(e)
+	switch {
+	case e.typ == 1 && len(b) >= 1:
+		return uint32(b[0]), true
+	case e.typ == 3 && len(b) >= 2:
+		return uint32(t.bo.Uint16(b)), true
+	case e.typ == 4 && len(b) >= 4:
+		return t.bo.Uint32(b), true
+	}
+	return 0, false
+}
+
+// rationals reads a RATIONAL tag
+func (t *tiff) rationals(d ifd, tag uint16) []float64 {
+	e, ok := d[tag]
+	if !ok || e.typ != 5 {
+		return nil
+	}
+	b := t.value(e)
+	var out []float64
+	for ; len(b) >= 8; b = b[8:] {
+		num, den := t.bo.Uint32(b), t.bo.Uint32(b[4:])
+		if den == 0 {
+			return nil
+		}
+		out = append(out, float64(num)/float64(den))
+	}
+	return out
+}
+
+// clean trims the NULs and padding cameras leave in ASCII values
+func clean(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0 {
+			s = s[:i]
+			break
+		}
+	}
+	for len(s) > 0 && s[len(s)-1] == ' ' {
+		s = s[:len(s)-1]
+	}
+	for len(s) > 0 && s[0] == ' ' {
+		s = s[1:]
+	}
+	return s
+}
@@ -0,0 +1,117 @@
+package exif
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XMP is RDF/XML. Properties appear either as attributes of rdf:Description
+// (aux:SerialNumber="123") or as elements (<aux:SerialNumber>123</...>), so
+// both are matched; only fields EXIF didn't give are taken.
+
+// the properties read, each matched as attribute or element
+var xmpRegexps = map[string]*regexp.Regexp{}
+
+func init() {
+	for _, name := range []string{
+		"tiff:Make", "tiff:Model", "tiff:Orientation", "aux:SerialNumber", "exifEX:BodySerialNumber",
+		"exifEX:LensModel", "aux:Lens", "exif:DateTimeOriginal", "photoshop:DateCreated", "xmp:CreateDate",
+		"exif:GPSLatitude", "exif:GPSLongitude", "exif:GPSAltitude", "exif:GPSAltitudeRef",
+	} {
+		q := regexp.QuoteMeta(name)
+		xmpRegexps[name] = regexp.MustCompile(q + `="([^"]*)"|<` + q + `>([^<]*)<`)
+	}
+}
+
+// xmpField returns the value of property name, e.g. "aux:SerialNumber"
+func xmpField(doc, name string) string {
+	m := xmpRegexps[name].FindStringSubmatch(doc)
+	if m == nil {
+		return ""
+	}
+	return strings.TrimSpace(m[1] + m[2])
+}
+
+func readXMP(doc string, info *Info) {
+	set(&info.Make, xmpField(doc, "tiff:Make"))
+	set(&info.Model, xmpField(doc, "tiff:Model"))
+	set(&info.Serial, xmpField(doc, "aux:SerialNumber"))
+	set(&info.Serial, xmpField(doc, "exifEX:BodySerialNumber"))
+	set(&info.Lens, xmpField(doc, "exifEX:LensModel"))
+	set(&info.Lens, xmpField(doc, "aux:Lens"))
+	if info.Orientation == 0 {
+		info.Orientation, _ = strconv.Atoi(xmpField(doc, "tiff:Orientation"))
+	}
+	if info.CapturedAt.IsZero() {
+		for _, name := range []string{"exif:DateTimeOriginal", "photoshop:DateCreated", "xmp:CreateDate"} {
+			if t, zoned, ok := xmpTime(xmpField(doc, name)); ok {
+				info.CapturedAt, info.Zoned = t, zoned
+				break
+			}
+		}
+	}
+	if info.GPS == nil {
+		lat, ok1 := xmpCoord(xmpField(doc, "exif:GPSLatitude"))
+		lon, ok2 := xmpCoord(xmpField(doc, "exif:GPSLongitude"))
+		if ok1 && ok2 {
+			g := &GPS{Lat: lat, Lon: lon}
+			if num, den, ok := strings.Cut(xmpField(doc, "exif:GPSAltitude"), "/"); ok {
+				n, _ := strconv.ParseFloat(num, 64)
+				d, _ := strconv.ParseFloat(den, 64)
+				if d != 0 {
+					g.Alt = n / d
+				}
+				if xmpField(doc, "exif:GPSAltitudeRef") == "1" {
+					g.Alt = -g.Alt
+				}
+			}
+			info.GPS = g
+		}
+	}
+}
+
+// xmpTime parses an XMP date, ISO 8601 with or without an offset
+func xmpTime(s string) (time.Time, bool, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05-07:00", "2006-01-02T15:04Z07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true, true
+		}
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, false, true
+		}
+	}
+	return time.Time{}, false, false
+}
+
+// xmpCoord parses an XMP GPS coordinate, "DDD,MM,SSk" or "DDD,MM.mmk" where
+// k is N, S, E or W
+func xmpCoord(s string) (float64, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+	ref := s[len(s)-1]
+	parts := strings.Split(s[:len(s)-1], ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+	var v [3]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return 0, false
+		}
+		v[i] = f
+	}
+	deg := dms(v[:])
+	switch ref {
+	case 'S', 'W':
+		return -deg, true
+	case 'N', 'E':
+		return deg, true
+	}
+	return 0, false
+}
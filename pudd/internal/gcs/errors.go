@@ -0,0 +1,47 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"pudd/internal/errclass"
+
+	"cloud.google.com/go/auth"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// classify maps GCS and token errors onto errclass so the store knows
+// whether retrying the upload can help
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		switch {
+		case gerr.Code == http.StatusUnauthorized || gerr.Code == http.StatusForbidden:
+			return errclass.Wrap(errclass.Auth, err)
+		case gerr.Code == http.StatusTooManyRequests || gerr.Code == http.StatusRequestTimeout || gerr.Code >= 500:
+			return errclass.Wrap(errclass.Transient, err)
+		case gerr.Code >= 400:
+			return errclass.Wrap(errclass.Permanent, err)
+		}
+	}
+
+	// failing to mint a token is a credentials problem, not this file's
+	var aerr *auth.Error
+	if errors.As(err, &aerr) {
+		return errclass.Wrap(errclass.Auth, err)
+	}
+
+	switch {
+	case errors.Is(err, storage.ErrBucketNotExist):
+		return errclass.Wrap(errclass.Permanent, err)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return errclass.Wrap(errclass.Transient, err)
+	}
+	return errclass.FromOS(err)
+}
@@ -0,0 +1,77 @@
+package gcs
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"pudd/internal/model"
+)
+
+// Object layouts name an uploaded file's object below the prefix, e.g.
+// "{year}/{month}/{day}/{device}/{id}-{name}". Date placeholders are the
+// file's capture time in UTC, or "undated" if it has none.
+
+var placeholder = regexp.MustCompile(`\{[a-z]+\}`)
+
+var layoutFields = map[string]func(f model.FileRow, t time.Time) string{
+	"{device}": func(f model.FileRow, _ time.Time) string { return f.DeviceID },
+	"{id}":     func(f model.FileRow, _ time.Time) string { return strconv.FormatInt(f.ID, 10) },
+	"{name}":   func(f model.FileRow, _ time.Time) string { return path.Base(f.SrcPath) },
+	"{ext}": func(f model.FileRow, _ time.Time) string {
+		return strings.ToLower(strings.TrimPrefix(path.Ext(f.SrcPath), "."))
+	},
+	"{date}":  dateField("2006-01-02"),
+	"{year}":  dateField("2006"),
+	"{month}": dateField("01"),
+	"{day}":   dateField("02"),
+	"{hour}":  dateField("15"),
+}
+
+func dateField(layout string) func(model.FileRow, time.Time) string {
+	return func(_ model.FileRow, t time.Time) string {
+		if t.IsZero() {
+			return "undated"
+		}
+		return t.Format(layout)
+	}
+}
+
+// CheckLayout reports an object layout with unknown placeholders, or one
+// that doesn't give every file its own object
+func CheckLayout(layout string) error {
+	for _, p := range placeholder.FindAllString(layout, -1) {
+		if _, ok := layoutFields[p]; !ok {
+			return fmt.Errorf("object layout %q: unknown placeholder %s", layout, p)
+		}
+	}
+	// a card can hold the same name twice, in different folders, and
+	// cameras start counting again after a format
+	if !strings.Contains(layout, "{id}") {
+		return fmt.Errorf("object layout %q: has no {id}, so objects could overwrite each other", layout)
+	}
+	if strings.HasPrefix(layout, "/") || strings.Contains(layout, "//") {
+		return fmt.Errorf("object layout %q: empty path segment", layout)
+	}
+	return nil
+}
+
+// expand fills in layout, which CheckLayout accepted, for f
+func expand(layout string, f model.FileRow) string {
+	t := captured(f)
+	return placeholder.ReplaceAllStringFunc(layout, func(p string) string {
+		return layoutFields[p](f, t)
+	})
+}
+
+// captured is f's capture time, zero if unknown
+func captured(f model.FileRow) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", f.CapturedAt, time.UTC)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -3,9 +3,15 @@ package gcs
 import (
 	"context"
 	"fmt"
+	"hash/crc32"
+	"log/slog"
 	"os"
+	"path"
+	"strings"
 	"time"
 
+	"pudd/internal/logging"
+	"pudd/internal/metrics"
 	"pudd/internal/model"
 
 	"cloud.google.com/go/storage"
@@ -15,17 +21,39 @@ type Uploader struct {
 	client *storage.Client
 	bucket string
 	prefix string
+	quarantine string // prefix for files that failed validation
+	layout string // object name below the prefix; see CheckLayout
+	readBack bool // read each object back and check its SHA-256 after upload
 }
 
-func NewUploader(client *storage.Client, bucket, prefix string) *Uploader {
-	return &Uploader{client: client, bucket: bucket, prefix: prefix}
+func NewUploader(client *storage.Client, bucket, prefix, quarantine, layout string, readBack bool) (*Uploader, error) {
+	if err := CheckLayout(layout); err != nil {
+		return nil, err
+	}
+	return &Uploader{client: client, bucket: bucket, prefix: prefix, quarantine: quarantine, layout: layout, readBack: readBack}, nil
 }
 
 func (u *Uploader) ObjectName(f model.FileRow) string {
+	prefix := u.prefix
+	if f.Corrupt != "" {
+		prefix = u.quarantine
+	}
+	return prefix + "/" + expand(u.layout, f)
+}
+
+// LegacyObjectName is the name every file was uploaded under before object
+// layouts, and before the name was kept with the file
+func (u *Uploader) LegacyObjectName(f model.FileRow) string {
 	return fmt.Sprintf("%s/%s/%d.bin", u.prefix, f.DeviceID, f.ID)
 }
 
-func (u *Uploader) UploadAndVerify(ctx context.Context, f model.FileRow) error {
+// UploadAndVerify returns errors classified with errclass. meta is added to
+// the object's metadata, next to pudd's own keys.
+func (u *Uploader) UploadAndVerify(ctx context.Context, f model.FileRow, meta map[string]string) error {
+	return classify(u.uploadAndVerify(ctx, f, meta))
+}
+
+func (u *Uploader) uploadAndVerify(ctx context.Context, f model.FileRow, meta map[string]string) error {
 	objName := u.ObjectName(f)
 	bkt := u.client.Bucket(u.bucket)
 	obj := bkt.Object(objName)
@@ -45,19 +73,37 @@ func (u *Uploader) UploadAndVerify(ctx context.Context, f model.FileRow) error {
 		"src_path": f.SrcPath,
 		"sha256": f.SHA256,
 	}
+	if f.Corrupt != "" {
+		// object metadata is capped at 8 KiB all told
+		w.Metadata["corrupt"] = truncate(f.Corrupt, 1024)
+	}
+	for k, v := range meta {
+		if _, ok := w.Metadata[k]; !ok {
+			w.Metadata[k] = v
+		}
+	}
+	// lets lifecycle rules and listings go by when the footage was shot
+	if t := captured(f); !t.IsZero() {
+		w.Metadata["capture_time"] = t.Format(time.RFC3339)
+		w.CustomTime = t
+	}
 
 	// upload
 	if _, err := file.Seek(0, 0); err != nil {
-		_ = w.Close()
+		abort(w, f)
 		return err
 	}
-	if _, err := file.WriteTo(w); err != nil {
-		_ = w.Close()
+	n, err := file.WriteTo(w)
+	if err != nil {
+		metrics.Transferred(metrics.StageUpload, n, false)
+		abort(w, f)
 		return err
 	}
 	if err := w.Close(); err != nil {
+		metrics.Transferred(metrics.StageUpload, n, false)
 		return err
 	}
+	metrics.Transferred(metrics.StageUpload, n, true)
 
 	// fetch attributes and verify
 	var attrs *storage.ObjectAttrs
@@ -78,6 +124,51 @@ func (u *Uploader) UploadAndVerify(ctx context.Context, f model.FileRow) error {
 	if attrs.CRC32C != f.CRC32C {
 		return fmt.Errorf("verify crc32c mismatch: local=%d remote=%d", f.CRC32C, attrs.CRC32C)
 	}
+	if u.readBack {
+		// the generation just written, not one a later upload replaced it with
+		return verifySHA256(ctx, obj.Generation(attrs.Generation), f)
+	}
 	
 	return nil
-}
\ No newline at end of file
+}
+
+// UploadSidecar stores body next to f's object, named like it with ext in
+// place of its extension
+func (u *Uploader) UploadSidecar(ctx context.Context, f model.FileRow, ext, contentType string, body []byte) error {
+	return classify(u.uploadSidecar(ctx, f, ext, contentType, body))
+}
+
+func (u *Uploader) uploadSidecar(ctx context.Context, f model.FileRow, ext, contentType string, body []byte) error {
+	name := u.ObjectName(f)
+	name = strings.TrimSuffix(name, path.Ext(name)) + ext
+	w := u.client.Bucket(u.bucket).Object(name).NewWriter(ctx)
+	w.ContentType = contentType
+	w.Metadata = map[string]string{
+		"device_id": f.DeviceID,
+		"src_path": f.SrcPath,
+	}
+	w.CustomTime = captured(f)
+	// small enough to go in one request, which also checks its CRC32C
+	w.SendCRC32C = true
+	w.CRC32C = crc32.Checksum(body, crc32.MakeTable(crc32.Castagnoli))
+	if _, err := w.Write(body); err != nil {
+		abort(w, f)
+		return err
+	}
+	return w.Close()
+}
+
+// abort closes a writer after a failed upload. The write error is the one
+// returned to the caller, so the close error is only logged.
+func abort(w *storage.Writer, f model.FileRow) {
+	if err := w.Close(); err != nil {
+		slog.Debug("close writer after failed upload", logging.FileID, f.ID, logging.Err, err)
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,56 @@
+package gcs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"pudd/internal/metrics"
+	"pudd/internal/model"
+
+	"cloud.google.com/go/storage"
+)
+
+// Checks of what is in the bucket beyond the attributes an upload returns.
+// CRC32C catches a corrupted transfer but is GCS's own figure; reading the
+// object back and hashing it is the end-to-end check, at the cost of
+// downloading every file once. The auditor only looks at attributes.
+
+// verifySHA256 streams obj back and checks it hashes to f's SHA-256
+func verifySHA256(ctx context.Context, obj *storage.ObjectHandle, f model.FileRow) error {
+	r, err := obj.NewReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	metrics.Transferred(metrics.StageVerify, n, err == nil)
+	if err != nil {
+		return err
+	}
+	if n != f.Size {
+		return fmt.Errorf("verify read-back size mismatch: local=%d remote=%d", f.Size, n)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != f.SHA256 {
+		return fmt.Errorf("verify sha256 mismatch: local=%s remote=%s", f.SHA256, sum)
+	}
+	return nil
+}
+
+// Attrs returns what the bucket says of the object called name; false if
+// there is none. Errors are classified with errclass.
+func (u *Uploader) Attrs(ctx context.Context, name string) (model.ObjectAttrs, bool, error) {
+	attrs, err := u.client.Bucket(u.bucket).Object(name).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return model.ObjectAttrs{}, false, nil
+	}
+	if err != nil {
+		return model.ObjectAttrs{}, false, classify(err)
+	}
+	return model.ObjectAttrs{Size: attrs.Size, CRC32C: attrs.CRC32C, SHA256: attrs.Metadata["sha256"]}, true, nil
+}
@@ -0,0 +1,190 @@
+package gpmf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// A decoder for GoPro Metadata Format, the KLV stream GoPro cameras record
+// in a clip's metadata track and in its udta.
+//
+// Each KLV is a FourCC key, a type, the size of one value and a repeat
+// count, then size*repeat bytes padded to 4. Type 0 nests more KLVs. A
+// stream (STRM) sets SCAL, TYPE and such before the values they describe.
+
+// KLV is one entry of a GPMF stream
+type KLV struct {
+	Key    string
+	Type   byte // 0 for a nest
+	Size   int  // bytes per value
+	Repeat int
+	Data   []byte // Size*Repeat bytes, unpadded
+	Nest   []KLV  // for type 0
+}
+
+// Parse decodes a GPMF payload
+func Parse(b []byte) ([]KLV, error) {
+	var out []KLV
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return out, fmt.Errorf("%d stray bytes", len(b))
+		}
+		k := KLV{
+			Key:    string(b[:4]),
+			Type:   b[4],
+			Size:   int(b[5]),
+			Repeat: int(binary.BigEndian.Uint16(b[6:])),
+		}
+		if k.Key == "\x00\x00\x00\x00" {
+			// padding at the end of a payload
+			break
+		}
+		n := k.Size * k.Repeat
+		padded := (n + 3) &^ 3
+		if 8+padded > len(b) {
+			return out, fmt.Errorf("%s: %d bytes run past the end", k.Key, n)
+		}
+		k.Data = b[8 : 8+n]
+		if k.Type == 0 {
+			nest, err := Parse(k.Data)
+			if err != nil {
+				return out, fmt.Errorf("%s: %w", k.Key, err)
+			}
+			k.Nest = nest
+		}
+		out = append(out, k)
+		b = b[8+padded:]
+	}
+	return out, nil
+}
+
+// bytes per element, by type
+var elemSize = map[byte]int{
+	'b': 1, 'B': 1, 's': 2, 'S': 2, 'l': 4, 'L': 4, 'f': 4, 'q': 4,
+	'd': 8, 'j': 8, 'J': 8, 'Q': 8,
+}
+
+// Numbers decodes a numeric KLV into one row per value, a row holding the
+// value's elements (GPS5 has five). For a complex ('?') KLV, types is the
+// stream's TYPE, one type letter per element; otherwise it is ignored.
+func (k KLV) Numbers(types string) ([][]float64, error) {
+	if k.Type != '?' {
+		n := elemSize[k.Type]
+		if n == 0 {
+			return nil, fmt.Errorf("%s: type %q is not numeric", k.Key, k.Type)
+		}
+		types = ""
+		for i := 0; i < k.Size/n; i++ {
+			types += string(k.Type)
+		}
+	}
+	width := 0
+	for i := 0; i < len(types); i++ {
+		n := elemSize[types[i]]
+		if n == 0 {
+			return nil, fmt.Errorf("%s: type %q is not numeric", k.Key, types[i])
+		}
+		width += n
+	}
+	if width == 0 || width != k.Size {
+		return nil, fmt.Errorf("%s: %d-byte values don't fit types %q", k.Key, k.Size, types)
+	}
+
+	rows := make([][]float64, 0, k.Repeat)
+	for r := 0; r < k.Repeat; r++ {
+		b := k.Data[r*k.Size:]
+		row := make([]float64, len(types))
+		for i := 0; i < len(types); i++ {
+			row[i], b = number(types[i], b)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// number decodes one element and returns what follows it
+func number(t byte, b []byte) (float64, []byte) {
+	be := binary.BigEndian
+	switch t {
+	case 'b':
+		return float64(int8(b[0])), b[1:]
+	case 'B':
+		return float64(b[0]), b[1:]
+	case 's':
+		return float64(int16(be.Uint16(b))), b[2:]
+	case 'S':
+		return float64(be.Uint16(b)), b[2:]
+	case 'l':
+		return float64(int32(be.Uint32(b))), b[4:]
+	case 'L':
+		return float64(be.Uint32(b)), b[4:]
+	case 'f':
+		return float64(math.Float32frombits(be.Uint32(b))), b[4:]
+	case 'q': // Q15.16 fixed point
+		return float64(int32(be.Uint32(b))) / 65536, b[4:]
+	case 'd':
+		return math.Float64frombits(be.Uint64(b)), b[8:]
+	case 'j':
+		return float64(int64(be.Uint64(b))), b[8:]
+	case 'J':
+		return float64(be.Uint64(b)), b[8:]
+	case 'Q': // Q31.32 fixed point
+		return float64(int64(be.Uint64(b))) / (1 << 32), b[8:]
+	}
+	return 0, b
+}
+
+// Scale divides rows by a stream's SCAL: one divisor for every element, or
+// one per element
+func Scale(rows [][]float64, scal []float64) {
+	for _, row := range rows {
+		for i := range row {
+			d := 1.0
+			switch {
+			case len(scal) == 1:
+				d = scal[0]
+			case i < len(scal):
+				d = scal[i]
+			}
+			if d != 0 {
+				row[i] /= d
+			}
+		}
+	}
+}
+
+// Flat decodes a numeric KLV into a single list, e.g. SCAL
+func (k KLV) Flat() []float64 {
+	rows, err := k.Numbers("")
+	if err != nil {
+		return nil
+	}
+	var out []float64
+	for _, r := range rows {
+		out = append(out, r...)
+	}
+	return out
+}
+
+// Text decodes a 'c' KLV
+func (k KLV) Text() string {
+	b := k.Data
+	for i, c := range b {
+		if c == 0 {
+			b = b[:i]
+			break
+		}
+	}
+	return string(b)
+}
+
+// Time decodes a 'U' KLV, UTC as "yymmddhhmmss.sss"
+func (k KLV) Time() (time.Time, bool) {
+	if k.Type != 'U' || len(k.Data) < 16 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("060102150405.000", string(k.Data[:16]))
+	return t, err == nil
+}
@@ -0,0 +1,132 @@
+package gpmf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+	"time"
+)
+
+// klv packs values of size bytes each, padded to 4
+func klv(key string, typ byte, size int, data []byte) []byte {
+	b := append([]byte(key), typ, byte(size))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)/max(size, 1)))
+	b = append(b, data...)
+	return append(b, make([]byte, (4-len(data)%4)%4)...)
+}
+
+func nest(key string, klvs ...[]byte) []byte {
+	return klv(key, 0, 1, bytes.Join(klvs, nil))
+}
+
+func int32s(vs ...int32) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = binary.BigEndian.AppendUint32(b, uint32(v))
+	}
+	return b
+}
+
+// a GPS5 stream of two fixes, lat and lon in 1e-7 degrees, alt and speeds in mm
+func gps5Stream() []byte {
+	return nest("STRM",
+		klv("STNM", 'c', 1, []byte("GPS (Lat., Long., Alt., 2D speed, 3D speed)")),
+		klv("GPSU", 'U', 16, []byte("240501101112.000")),
+		klv("SCAL", 'l', 4, int32s(10000000, 10000000, 1000, 1000, 1000)),
+		klv("GPS5", 'l', 20, int32s(
+			473769000, 85417000, 408123, 1500, 1600,
+			-337000000, -705000000, -12000, 0, 0,
+		)),
+	)
+}
+
+func TestParse(t *testing.T) {
+	b := append(nest("DEVC", klv("DVNM", 'c', 1, []byte("HERO12 Black")), gps5Stream()), 0, 0, 0, 0, 0, 0, 0, 0)
+	klvs, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(klvs) != 1 || klvs[0].Key != "DEVC" || len(klvs[0].Nest) != 2 {
+		t.Fatalf("got %+v", klvs)
+	}
+	devc := klvs[0].Nest
+	if name := devc[0].Text(); name != "HERO12 Black" {
+		t.Errorf("DVNM %q", name)
+	}
+	strm := devc[1].Nest
+	var keys []string
+	for _, k := range strm {
+		keys = append(keys, k.Key)
+	}
+	if want := []string{"STNM", "GPSU", "SCAL", "GPS5"}; !slices.Equal(keys, want) {
+		t.Fatalf("keys %q, want %q", keys, want)
+	}
+
+	if at, ok := strm[1].Time(); !ok || !at.Equal(time.Date(2024, 5, 1, 10, 11, 12, 0, time.UTC)) {
+		t.Errorf("GPSU %v %v", at, ok)
+	}
+	rows, err := strm[3].Numbers("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Scale(rows, strm[2].Flat())
+	want := [][]float64{
+		{47.3769, 8.5417, 408.123, 1.5, 1.6},
+		{-33.7, -70.5, -12, 0, 0},
+	}
+	if !slices.EqualFunc(rows, want, slices.Equal) {
+		t.Errorf("GPS5 %v, want %v", rows, want)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		name  string
+		k     []byte
+		types string
+		want  [][]float64
+	}{
+		{"signed shorts", klv("ACCL", 's', 6, []byte{0xff, 0xfe, 0, 1, 0x80, 0}), "", [][]float64{{-2, 1, -32768}}},
+		{"unsigned bytes", klv("GPSF", 'B', 1, []byte{3, 255}), "", [][]float64{{3}, {255}}},
+		{"Q15.16", klv("XXXX", 'q', 4, int32s(3<<16|1<<15)), "", [][]float64{{3.5}}},
+		// GPS9 style: a long, an unsigned short and a byte per value
+		{"complex", klv("GPS9", '?', 7, []byte{0, 0, 0, 10, 0, 2, 3}), "lSB", [][]float64{{10, 2, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			klvs, err := Parse(tt.k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := klvs[0].Numbers(tt.types)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNumbersMismatch(t *testing.T) {
+	for _, k := range []KLV{
+		{Key: "STNM", Type: 'c', Size: 1, Repeat: 1, Data: []byte("x")},
+		// TYPE has to add up to the size of a complex value
+		{Key: "GPS9", Type: '?', Size: 7, Repeat: 1, Data: make([]byte, 7)},
+	} {
+		if _, err := k.Numbers("ll"); err == nil {
+			t.Errorf("%s: no error", k.Key)
+		}
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	b := nest("DEVC", gps5Stream())
+	for _, n := range []int{len(b) - 4, 5} {
+		if _, err := Parse(b[:n]); err == nil {
+			t.Errorf("%d of %d bytes: no error", n, len(b))
+		}
+	}
+}
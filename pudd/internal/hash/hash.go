@@ -1,11 +1,16 @@
 package hash
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"hash/crc32"
 	"io"
 	"os"
+
+	"pudd/internal/copyutil"
+	"pudd/internal/errclass"
+	"pudd/internal/metrics"
 )
 
 // util package to compute hash from file size
@@ -16,10 +21,11 @@ type Result struct {
 	CRC32C uint32
 }
 
-func Compute(path string) (Result, error) {
+// Compute hashes the file at path; cancelling ctx stops it early
+func Compute(ctx context.Context, path string) (Result, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return Result{}, err
+		return Result{}, errclass.FromOS(err)
 	}
 	defer f.Close()
 
@@ -27,9 +33,10 @@ func Compute(path string) (Result, error) {
 	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
 
 	// Copy once, update both digests
-	n, err := io.Copy(io.MultiWriter(h, crc), f)
+	n, err := io.Copy(io.MultiWriter(h, crc), copyutil.Reader(ctx, f))
+	metrics.Transferred(metrics.StageHash, n, err == nil)
 	if err != nil {
-		return Result{}, err
+		return Result{}, errclass.FromOS(err)
 	}
 
 	return Result{
@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Attribute keys shared by every package so log lines can be filtered the same way
+const (
+	FileID   = "file_id"
+	DeviceID = "device_id"
+	Session  = "session"
+	Worker   = "worker"
+	Stage    = "stage"
+	Bytes    = "bytes"
+	Duration = "duration"
+	Err      = "err"
+)
+
+// New builds the process logger. format is "text" or "json"; level is any
+// slog level name (debug, info, warn, error).
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("log level: %w", err)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case "text", "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want text or json)", format)
+	}
+}
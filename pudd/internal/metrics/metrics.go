@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"pudd/internal/errclass"
+	"pudd/internal/logging"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus instrumentation shared by the pipeline and the packages doing I/O.
+// Everything registers on the default registry and is served by Serve.
+
+// stage label values
+const (
+	StageCopy   = "copy"
+	StageHash   = "hash"
+	StageUpload = "upload"
+	StageVerify = "verify" // reading an uploaded object back
+	StageClean  = "clean"
+)
+
+var (
+	Bytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pudd",
+		Name:      "bytes_total",
+		Help:      "Bytes copied, hashed, uploaded or read back, including partial transfers.",
+	}, []string{"stage"})
+
+	FileBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "pudd",
+		Name:      "file_bytes",
+		Help:      "Size of files that completed a copy, hash or upload.",
+		Buckets:   prometheus.ExponentialBuckets(1<<20, 4, 10), // 1MiB .. 256GiB
+	}, []string{"stage"})
+
+	StageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "pudd",
+		Name:      "stage_duration_seconds",
+		Help:      "Wall time spent per file in each pipeline stage.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 16), // 50ms .. ~27m
+	}, []string{"stage", "result"})
+
+	Errors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pudd",
+		Name:      "errors_total",
+		Help:      "Pipeline errors by stage and error class.",
+	}, []string{"stage", "class"})
+
+	ActiveDevices = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pudd",
+		Name:      "active_devices",
+		Help:      "Devices currently mounted by pudd.",
+	})
+
+	UdevRestarts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "pudd",
+		Name:      "udev_monitor_restarts_total",
+		Help:      "Times the udevadm monitor exited and was restarted.",
+	})
+
+	Audited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pudd",
+		Name:      "audited_total",
+		Help:      "Uploaded objects the auditor checked, by result: ok, missing, altered or error.",
+	}, []string{"result"})
+)
+
+// Transferred records n bytes moved by stage. complete is true when a whole
+// file made it through, which also feeds the size histogram.
+func Transferred(stage string, n int64, complete bool) {
+	Bytes.WithLabelValues(stage).Add(float64(n))
+	if complete {
+		FileBytes.WithLabelValues(stage).Observe(float64(n))
+	}
+}
+
+// ObserveStage records how long a stage took for one file
+func ObserveStage(stage string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	StageDuration.WithLabelValues(stage, result).Observe(time.Since(start).Seconds())
+}
+
+// Error counts a failed stage
+func Error(stage string, err error) {
+	Errors.WithLabelValues(stage, string(errclass.Of(err))).Inc()
+}
+
+// Serve exposes /metrics on addr until ctx is done
+func Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("metrics server shutdown failed", logging.Err, err)
+		}
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"pudd/internal/diskspace"
+	"pudd/internal/model"
+	"pudd/internal/store"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// storeCollector reads gauges straight from the db and the staging disk on
+// every scrape, so they are right even when another process changed the db.
+type storeCollector struct {
+	db        *sql.DB
+	stageRoot string
+
+	files      *prometheus.Desc
+	bytes      *prometheus.Desc
+	backingOff *prometheus.Desc
+	stageFree  *prometheus.Desc
+}
+
+// RegisterStore adds the db and staging gauges to the default registry
+func RegisterStore(db *sql.DB, stageRoot string) error {
+	return prometheus.Register(&storeCollector{
+		db:        db,
+		stageRoot: stageRoot,
+		files: prometheus.NewDesc("pudd_files",
+			"Files per pipeline state.", []string{"state"}, nil),
+		bytes: prometheus.NewDesc("pudd_files_bytes",
+			"Bytes per pipeline state.", []string{"state"}, nil),
+		backingOff: prometheus.NewDesc("pudd_backoff_queue_size",
+			"Files waiting for their retry backoff to expire.", nil, nil),
+		stageFree: prometheus.NewDesc("pudd_stage_free_bytes",
+			"Free space on the staging filesystem.", nil, nil),
+	})
+}
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.files
+	ch <- c.bytes
+	ch <- c.backingOff
+	ch <- c.stageFree
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	counts, err := store.CountStates(c.db)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.files, err)
+	} else {
+		// report every known state so absent ones read as 0 instead of vanishing
+		byState := map[model.FileState]store.StateCount{}
+		for _, sc := range counts {
+			byState[sc.State] = sc
+		}
+		for _, s := range model.AllStates {
+			sc := byState[s]
+			ch <- prometheus.MustNewConstMetric(c.files, prometheus.GaugeValue, float64(sc.Files), string(s))
+			ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(sc.Bytes), string(s))
+		}
+	}
+
+	if n, err := store.CountBackingOff(c.db); err != nil {
+		ch <- prometheus.NewInvalidMetric(c.backingOff, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.backingOff, prometheus.GaugeValue, float64(n))
+	}
+
+	if free, err := diskspace.Free(c.stageRoot); err != nil {
+		ch <- prometheus.NewInvalidMetric(c.stageFree, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.stageFree, prometheus.GaugeValue, float64(free))
+	}
+}
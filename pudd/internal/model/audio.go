@@ -0,0 +1,76 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Audio is what the probe stage read from a field recorder's WAV besides
+// its Media: the sample format, and the bext and iXML fields that line the
+// recording up with picture
+type Audio struct {
+	FileID     int64 `json:"file_id"`
+	SampleRate int   `json:"sample_rate"`
+	Channels   int   `json:"channels"`
+	BitDepth   int   `json:"bit_depth"`
+	// the start, in samples since midnight by the recorder's timecode;
+	// nil if the file gives none
+	TimeReference *int64       `json:"time_reference,omitempty"`
+	TimecodeRate  string       `json:"timecode_rate,omitempty"` // e.g. 24000/1001
+	Originator    string       `json:"originator,omitempty"`
+	Description   string       `json:"description,omitempty"`
+	Project       string       `json:"project,omitempty"`
+	Scene         string       `json:"scene,omitempty"`
+	Take          string       `json:"take,omitempty"`
+	Tape          string       `json:"tape,omitempty"`
+	Note          string       `json:"note,omitempty"`
+	Circled       bool         `json:"circled,omitempty"`
+	Tracks        []AudioTrack `json:"tracks,omitempty"`
+}
+
+// AudioTrack is a channel the recorder named
+type AudioTrack struct {
+	Channel  int    `json:"channel"` // from 1
+	Name     string `json:"name"`
+	Function string `json:"function,omitempty"`
+}
+
+// ObjectMetadata is the subset of a attached to the uploaded object
+func (a Audio) ObjectMetadata() map[string]string {
+	out := map[string]string{}
+	set := func(k, v string) {
+		if v != "" {
+			out[k] = v
+		}
+	}
+	if a.SampleRate > 0 {
+		set("sample_rate", strconv.Itoa(a.SampleRate))
+	}
+	if a.Channels > 0 {
+		set("channels", strconv.Itoa(a.Channels))
+	}
+	if a.BitDepth > 0 {
+		set("bit_depth", strconv.Itoa(a.BitDepth))
+	}
+	if a.TimeReference != nil {
+		set("time_reference", strconv.FormatInt(*a.TimeReference, 10))
+	}
+	set("timecode_rate", a.TimecodeRate)
+	set("project", a.Project)
+	set("scene", a.Scene)
+	set("take", a.Take)
+	set("tape", a.Tape)
+	set("note", a.Note)
+	if a.Circled {
+		set("circled", "true")
+	}
+	// channel=name, in channel order
+	var tracks []string
+	for _, t := range a.Tracks {
+		if t.Name != "" {
+			tracks = append(tracks, strconv.Itoa(t.Channel)+"="+t.Name)
+		}
+	}
+	set("tracks", strings.Join(tracks, ","))
+	return out
+}
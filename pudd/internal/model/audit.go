@@ -0,0 +1,27 @@
+package model
+
+// ObjectAttrs is what the bucket says of an uploaded object
+type ObjectAttrs struct {
+	Size   int64
+	CRC32C uint32
+	SHA256 string // from the object's metadata, as the uploader set it
+}
+
+// what an audit can find wrong with a file's object
+const (
+	AuditMissing = "missing" // no object by the name it was uploaded under
+	AuditAltered = "altered" // size, CRC32C or sha256 metadata differ from the file's
+	// no object under any name a file uploaded before object names were kept
+	// may have; left DONE for someone to look into
+	AuditUnknown = "unknown"
+)
+
+// Audit is a problem the auditor found with a DONE file's object
+type Audit struct {
+	ID      int64  `json:"id"`
+	FileID  int64  `json:"file_id"`
+	Object  string `json:"object"`
+	Problem string `json:"problem"` // AuditMissing, AuditAltered or AuditUnknown
+	Detail  string `json:"detail,omitempty"`
+	FoundAt string `json:"found_at"`
+}
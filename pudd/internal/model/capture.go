@@ -0,0 +1,99 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Capture time. Cameras stamp files by their own clock, which is often set
+// to local time and rarely exact. A file's capture time is read from its
+// metadata when the probe stage finds one there, else from the card's
+// mtime, and corrected with the device's Clock either way.
+
+// what FileRow.CapturedAt came from
+const (
+	CaptureMetadata = "metadata" // the time the file's metadata gives
+	CaptureMTime    = "mtime"    // the source file's mtime
+)
+
+// LocalLayout formats a wall-clock time given without a UTC offset, as
+// Media.CreatedAt holds it
+const LocalLayout = "2006-01-02T15:04:05.999999999"
+
+// Clock is how a device's clock relates to true time: the zone it is set to
+// and how far ahead it runs
+type Clock struct {
+	Zone *time.Location
+	Skew time.Duration
+}
+
+// Clock returns d's clock; an unparseable timezone counts as UTC, as
+// `pudd devices set` doesn't store one
+func (d Device) Clock() Clock {
+	zone, err := ParseZone(d.Timezone)
+	if err != nil {
+		zone = time.UTC
+	}
+	return Clock{Zone: zone, Skew: time.Duration(d.ClockSkew) * time.Second}
+}
+
+// ParseZone parses a device timezone: an IANA name such as Europe/Berlin,
+// a UTC offset such as +02:00, or empty for UTC
+func ParseZone(s string) (*time.Location, error) {
+	switch {
+	case s == "" || s == "UTC" || s == "Z":
+		return time.UTC, nil
+	case strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-"):
+		t, err := time.Parse("-07:00", s)
+		if err != nil {
+			return nil, fmt.Errorf("bad UTC offset %q, want e.g. +02:00", s)
+		}
+		_, secs := t.Zone()
+		return time.FixedZone(s, secs), nil
+	}
+	return time.LoadLocation(s)
+}
+
+// True converts a time read off the device to true time. A zoned time
+// (one with a UTC offset) is an instant already; a wall-clock time, whose
+// fields are taken as they are whatever its location, is read in the
+// device's zone. Either way the skew is taken off.
+func (c Clock) True(t time.Time, zoned bool) time.Time {
+	if !zoned {
+		zone := c.Zone
+		if zone == nil {
+			zone = time.UTC
+		}
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), zone)
+	}
+	return t.Add(-c.Skew).UTC()
+}
+
+// CaptureTime works out when a file was recorded from created, its
+// Media.CreatedAt, falling back to mtime, the wall-clock mtime the card
+// gives. source is empty, and the time zero, if neither is known.
+func (c Clock) CaptureTime(created string, mtime time.Time) (time.Time, string) {
+	if t, zoned, ok := ParseRecorded(created); ok {
+		return c.True(t, zoned), CaptureMetadata
+	}
+	if !mtime.IsZero() {
+		return c.True(mtime, false), CaptureMTime
+	}
+	return time.Time{}, ""
+}
+
+// ParseRecorded parses a Media.CreatedAt, reporting whether it has a UTC
+// offset
+func ParseRecorded(s string) (t time.Time, zoned, ok bool) {
+	if s == "" {
+		return time.Time{}, false, false
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, true, true
+	}
+	if t, err := time.Parse(LocalLayout, s); err == nil {
+		return t, false, true
+	}
+	return time.Time{}, false, false
+}
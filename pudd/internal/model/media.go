@@ -0,0 +1,109 @@
+package model
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MediaClass groups files by what kind of media they are, by extension
+type MediaClass string
+
+const (
+	MediaVideo MediaClass = "video"
+	MediaPhoto MediaClass = "photo"
+	MediaAudio MediaClass = "audio"
+	MediaOther MediaClass = "other"
+)
+
+var MediaClasses = []MediaClass{MediaVideo, MediaPhoto, MediaAudio, MediaOther}
+
+var mediaExtensions = map[MediaClass][]string{
+	MediaVideo: {".mp4", ".mov", ".mxf", ".mts", ".m4v", ".avi", ".insv", ".lrv"},
+	MediaPhoto: {".jpg", ".jpeg", ".heic", ".heif", ".dng", ".arw", ".cr2", ".cr3", ".nef", ".raf", ".png"},
+	MediaAudio: {".wav", ".mp3", ".m4a", ".aac", ".flac"},
+}
+
+// Extensions lists the lowercase extensions of class; none for MediaOther
+func (c MediaClass) Extensions() []string {
+	return mediaExtensions[c]
+}
+
+func MediaClassOf(path string) MediaClass {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, c := range MediaClasses {
+		for _, e := range mediaExtensions[c] {
+			if e == ext {
+				return c
+			}
+		}
+	}
+	return MediaOther
+}
+
+// Media is what the probe stage read out of a file: a clip's container, or
+// a still's EXIF and XMP. Fields the file doesn't record are zero.
+type Media struct {
+	FileID int64  `json:"file_id"`
+	Format string `json:"format"` // container brand (isom, qt, XAVC...), or jpeg, heic, tiff
+	// RFC 3339, in the zone the file gives; in LocalLayout, with no offset,
+	// if it gives none, as EXIF often doesn't
+	CreatedAt  string  `json:"created_at,omitempty"`
+	DurationMS int64   `json:"duration_ms"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	FrameRate  float64 `json:"frame_rate"`
+	Codec      string  `json:"codec"`
+	Make       string  `json:"make"`
+	Model      string  `json:"model"`
+	Timecode   string  `json:"timecode"` // start timecode
+	Serial     string  `json:"serial,omitempty"`
+	Lens       string  `json:"lens,omitempty"`
+	// EXIF orientation, 1 (upright) to 8; 0 if not recorded
+	Orientation int    `json:"orientation,omitempty"`
+	GPS         *GPS   `json:"gps,omitempty"`
+	ProbedAt    string `json:"probed_at"`
+}
+
+// GPS is a position in decimal degrees, altitude in metres above sea level
+type GPS struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+	Alt float64 `json:"alt"`
+}
+
+// ObjectMetadata is the subset of m attached to the uploaded object
+func (m Media) ObjectMetadata() map[string]string {
+	out := map[string]string{}
+	set := func(k, v string) {
+		if v != "" {
+			out[k] = v
+		}
+	}
+	// as the camera's clock had it; the uploader adds the corrected time
+	set("camera_time", m.CreatedAt)
+	if m.DurationMS > 0 {
+		set("duration", strconv.FormatFloat(float64(m.DurationMS)/1000, 'f', 3, 64))
+	}
+	if m.Width > 0 && m.Height > 0 {
+		set("resolution", fmt.Sprintf("%dx%d", m.Width, m.Height))
+	}
+	if m.FrameRate > 0 {
+		set("frame_rate", strconv.FormatFloat(m.FrameRate, 'f', 3, 64))
+	}
+	set("codec", m.Codec)
+	set("camera_make", m.Make)
+	set("camera_model", m.Model)
+	set("timecode", m.Timecode)
+	set("camera_serial", m.Serial)
+	set("lens", m.Lens)
+	if m.Orientation > 0 {
+		set("orientation", strconv.Itoa(m.Orientation))
+	}
+	if m.GPS != nil {
+		set("gps", fmt.Sprintf("%.6f,%.6f", m.GPS.Lat, m.GPS.Lon))
+		set("gps_altitude", strconv.FormatFloat(m.GPS.Alt, 'f', 1, 64))
+	}
+	return out
+}
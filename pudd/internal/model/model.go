@@ -8,6 +8,11 @@ const (
 	StateDiscovered FileState = "DISCOVERED"
 	StateCopying FileState = "COPYING"
 	StateCopied FileState = "COPIED"
+	StateValidating FileState = "VALIDATING"
+	StateValidated FileState = "VALIDATED"
+	StateProbing FileState = "PROBING"
+	StateProbed FileState = "PROBED"
+	StateHashing FileState = "HASHING"
 	StateHashed FileState = "HASHED"
 	StateQueued FileState = "QUEUED"
 
@@ -17,20 +22,85 @@ const (
 
 	StateCleaning FileState = "CLEANING"
 	StateDone FileState = "DONE"
+	// like DONE, but the file failed validation and went to the quarantine prefix
+	StateCorrupt FileState = "CORRUPT"
 	StateError FileState = "ERROR"
+
+	// set by an operator (pudd skip), never picked up by the pipeline
+	StateSkipped FileState = "SKIPPED"
+	// dead letter: a stage ran out of attempts; only `pudd requeue` brings it back
+	StateFailed FileState = "FAILED"
+)
+
+// Stage groups the states a file passes through for one unit of retryable work
+type Stage string
+
+const (
+	StageCopy Stage = "copy"
+	StageValidate Stage = "validate"
+	StageProbe Stage = "probe"
+	StageHash Stage = "hash"
+	StageUpload Stage = "upload"
+	StageClean Stage = "clean"
 )
 
+// Uploaded reports whether a file in this state has been verified in GCS
+func (s FileState) Uploaded() bool {
+	return s == StateVerified || s == StateCleaning || s == StateDone || s == StateCorrupt
+}
+
 type FileRow struct {
-	ID int64
-	DeviceID string
-	SrcPath string
-	StagedPath string
-
-	Size int64
-	SHA256 string
-	CRC32C uint32
-
-	State FileState // to model state machine
-	Attempts int64
-	LastError string
-}
\ No newline at end of file
+	ID int64 `json:"id"`
+	DeviceID string `json:"device_id"`
+	SrcPath string `json:"src_path"`
+	StagedPath string `json:"staged_path"`
+
+	Size int64 `json:"size"`
+	SHA256 string `json:"sha256"`
+	CRC32C uint32 `json:"crc32c"`
+
+	State FileState `json:"state"` // to model state machine
+	Attempts int64 `json:"attempts"`
+	LastError string `json:"last_error"`
+	ErrorClass string `json:"error_class,omitempty"` // errclass.Class of LastError
+	NextRunAt string `json:"next_run_at"` // empty when not backing off
+	UpdatedAt string `json:"updated_at"`
+	FailedFrom FileState `json:"failed_from,omitempty"` // state the file was in when it went FAILED
+	ClaimGen int64 `json:"claim_gen"` // fencing token of the latest claim; see store.Renew
+	CapturedAt string `json:"captured_at,omitempty"` // when the media was recorded, if known; see Clock.CaptureTime
+	CaptureSource string `json:"capture_source,omitempty"` // what CapturedAt came from: CaptureMetadata or CaptureMTime
+	MTime string `json:"mtime,omitempty"` // the source file's mtime as the card gives it, by the device's clock
+	Corrupt string `json:"corrupt,omitempty"` // what validation found wrong with the file; empty if nothing
+	Object string `json:"object,omitempty"` // object name it was uploaded under; empty until uploaded
+}
+
+// FileEvent is one entry in a file's state transition journal
+type FileEvent struct {
+	ID int64 `json:"id"`
+	FileID int64 `json:"file_id"`
+	From FileState `json:"from"` // empty for the discovery entry
+	To FileState `json:"to"`
+	Worker string `json:"worker"`
+	Error string `json:"error,omitempty"`
+	Bytes int64 `json:"bytes"`
+	At string `json:"at"`
+}
+
+// Device is everything pudd remembers about a card or camera, across plug-ins
+type Device struct {
+	DeviceID string `json:"device_id"`
+	IDSource string `json:"id_source"` // deviceid.Source the id was derived from
+	Label string `json:"label"`
+	Owner string `json:"owner"`
+	FirstSeen string `json:"first_seen"`
+	LastSeen string `json:"last_seen"`
+	DevNode string `json:"devnode"` // empty when not present
+	MountPoint string `json:"mountpoint"` // empty when not present
+	Present bool `json:"present"`
+	FilesIngested int64 `json:"files_ingested"` // files copied off the device
+	BytesIngested int64 `json:"bytes_ingested"`
+	QuotaBytes int64 `json:"quota_bytes"` // staging quota; 0 = the configured default
+	Priority int64 `json:"priority"` // higher is scheduled first
+	Timezone string `json:"timezone"` // what the device's clock is set to: an IANA name or an offset like +02:00; empty for UTC
+	ClockSkew int64 `json:"clock_skew"` // seconds the device's clock runs ahead of true time
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Recording is what shoot grouping knows of a file: when it was captured,
+// for how long, and the timecode it started at
+type Recording struct {
+	FileID        int64
+	DeviceID      string
+	CapturedAt    string // UTC, as FileRow.CapturedAt
+	CaptureSource string
+	DurationMS    int64
+	Timecode      string  // start timecode, HH:MM:SS:FF, ; before FF if drop frame
+	TimecodeRate  float64 // frames per second the timecode counts; 0 if unknown
+	Timezone      string  // the device's
+}
+
+// what a ShootFile's span was worked out from
+const (
+	SpanTimecode = "timecode" // the start timecode, as time of day where the device is
+	SpanCapture  = "capture"  // the capture time
+)
+
+// Shoot is a group of files from different devices recorded over the same
+// stretch of time, see package shoot
+type Shoot struct {
+	ID        int64       `json:"id"`
+	StartedAt string      `json:"started_at"` // UTC, to the millisecond
+	EndedAt   string      `json:"ended_at"`
+	GroupedAt string      `json:"grouped_at,omitempty"`
+	Devices   int         `json:"devices"`
+	Files     []ShootFile `json:"files,omitempty"`
+}
+
+// ShootFile is a file's place in a shoot
+type ShootFile struct {
+	FileID   int64  `json:"file_id"`
+	DeviceID string `json:"device_id"`
+	StartAt  string `json:"start_at"` // UTC, to the millisecond
+	EndAt    string `json:"end_at"`
+	Basis    string `json:"basis"` // timecode or capture
+}
+
+// ParseRate parses a timecode rate as 24000/1001, 25 or 29.97; 0 if it
+// isn't one
+func ParseRate(s string) float64 {
+	n, d, found := strings.Cut(strings.TrimSpace(s), "/")
+	num, err := strconv.ParseFloat(n, 64)
+	if err != nil || num <= 0 {
+		return 0
+	}
+	if !found {
+		return num
+	}
+	den, err := strconv.ParseFloat(d, 64)
+	if err != nil || den <= 0 {
+		return 0
+	}
+	return num / den
+}
@@ -0,0 +1,189 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+)
+
+// StageDef declares the states of a pipeline stage. A file waits in Input,
+// is held in Claim by the worker running the stage, passes through Via as
+// the stage commits its work, and ends up in the Input of the next stage, or
+// DONE after the last one (CORRUPT if it failed validation). A retry sends it
+// back to Input.
+type StageDef struct {
+	Name  Stage
+	Input FileState
+	Claim FileState
+	Via   []FileState
+}
+
+// Pool is the set of workers that runs one stage. Stages wait on different
+// things, copies on the card reader, probing and hashing on the staging disk,
+// uploads on the network, so each gets its own pool and a slow upload never
+// holds up a copy.
+type Pool struct {
+	Stage   Stage     `json:"stage"`
+	State   FileState `json:"state"` // files are picked up from
+	Workers int       `json:"workers"`
+	// at most this many files in flight per device (0 = no limit)
+	PerDevice int `json:"per_device,omitempty"`
+	// at most this many files in flight per destination (0 = no limit)
+	PerDest int `json:"per_dest,omitempty"`
+}
+
+// the pipeline, in order
+var stageDefs = []StageDef{
+	{Name: StageCopy, Input: StateDiscovered, Claim: StateCopying},
+	{Name: StageValidate, Input: StateCopied, Claim: StateValidating},
+	{Name: StageProbe, Input: StateValidated, Claim: StateProbing},
+	{Name: StageHash, Input: StateProbed, Claim: StateHashing, Via: []FileState{StateHashed}},
+	{Name: StageUpload, Input: StateQueued, Claim: StateUploading, Via: []FileState{StateUploaded}},
+	{Name: StageClean, Input: StateVerified, Claim: StateCleaning},
+}
+
+// states outside any stage, listed after the pipeline's in AllStates
+var terminalStates = []FileState{StateDone, StateCorrupt, StateError, StateSkipped, StateFailed}
+
+// FinalStates are where a file is left for good: the pipeline is done with
+// it or an operator parked it. FAILED is not among them, `pudd requeue`
+// brings it back.
+var FinalStates = []FileState{StateDone, StateCorrupt, StateSkipped}
+
+// AllStates lists every state, pipeline states in order
+var AllStates []FileState
+
+// StagingStates hold a file (or, for COPYING, part of one) in staging: every
+// pipeline state but the first
+var StagingStates []FileState
+
+func init() {
+	rebuildStates()
+}
+
+func rebuildStates() {
+	AllStates, StagingStates = nil, nil
+	for _, d := range stageDefs {
+		AllStates = append(AllStates, d.states()...)
+	}
+	StagingStates = append(StagingStates, AllStates[1:]...)
+	AllStates = append(AllStates, terminalStates...)
+	rebuildTransitions()
+}
+
+// InsertStage adds a stage to the pipeline right after stage `after`, so
+// files leaving `after` go to d.Input instead. Call it from init, before
+// anything looks at the pipeline; it panics on a clash, like a duplicate
+// flag would.
+func InsertStage(d StageDef, after Stage) {
+	pos := -1
+	for i, def := range stageDefs {
+		if def.Name == after {
+			pos = i + 1
+		}
+		if def.Name == d.Name {
+			panic(fmt.Sprintf("stage %q registered twice", d.Name))
+		}
+	}
+	for _, s := range d.states() {
+		if s.Stage() != "" || slices.Contains(terminalStates, s) {
+			panic(fmt.Sprintf("stage %q: state %s already in use", d.Name, s))
+		}
+	}
+	if pos < 0 {
+		panic(fmt.Sprintf("stage %q: no stage %q to insert after", d.Name, after))
+	}
+	stageDefs = slices.Insert(stageDefs, pos, d)
+	rebuildStates()
+}
+
+// Stages returns the pipeline's stages in order
+func Stages() []StageDef {
+	return slices.Clone(stageDefs)
+}
+
+// Def returns the definition of stage s
+func (s Stage) Def() (StageDef, bool) {
+	for _, d := range stageDefs {
+		if d.Name == s {
+			return d, true
+		}
+	}
+	return StageDef{}, false
+}
+
+// Output is the state a file is in once the stage is done with it: the next
+// stage's Input, or DONE
+func (d StageDef) Output() FileState {
+	for i, def := range stageDefs {
+		if def.Name == d.Name && i+1 < len(stageDefs) {
+			return stageDefs[i+1].Input
+		}
+	}
+	return StateDone
+}
+
+// Path is the states a finished file moves through from Claim: Via, then Output
+func (d StageDef) Path() []FileState {
+	return append(append([]FileState(nil), d.Via...), d.Output())
+}
+
+// Rest is what is left of Path for a file in state s of the stage
+func (d StageDef) Rest(s FileState) []FileState {
+	path := d.Path()
+	for i, st := range path {
+		if st == s {
+			return path[i+1:]
+		}
+	}
+	return path
+}
+
+// ViaStates lists the Via states of every stage
+func ViaStates() []FileState {
+	var out []FileState
+	for _, d := range stageDefs {
+		out = append(out, d.Via...)
+	}
+	return out
+}
+
+// ClaimStates lists the Claim state of every stage
+func ClaimStates() []FileState {
+	var out []FileState
+	for _, d := range stageDefs {
+		out = append(out, d.Claim)
+	}
+	return out
+}
+
+func (d StageDef) states() []FileState {
+	return append([]FileState{d.Input, d.Claim}, d.Via...)
+}
+
+// Stage returns the stage a pipeline state belongs to, or "" for states outside the pipeline
+func (s FileState) Stage() Stage {
+	for _, d := range stageDefs {
+		for _, st := range d.states() {
+			if st == s {
+				return d.Name
+			}
+		}
+	}
+	return ""
+}
+
+// RetryState is where a file goes to run the stage again
+func (s Stage) RetryState() FileState {
+	d, _ := s.Def()
+	return d.Input
+}
+
+// InFlight reports whether a file in this state is held by a worker lease
+func (s FileState) InFlight() bool {
+	for _, d := range stageDefs {
+		if d.Claim == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Telemetry sums up the telemetry the probe stage found in a file: a GPS
+// track and markers, see package telemetry
+type Telemetry struct {
+	FileID    int64    `json:"file_id"`
+	Source    string   `json:"source"` // gopro-gpmf, dji-srt
+	Device    string   `json:"device,omitempty"`
+	Points    int      `json:"points"`               // GPS fixes
+	StartedAt string   `json:"started_at,omitempty"` // UTC times of the first and last fix, if the source gives them
+	EndedAt   string   `json:"ended_at,omitempty"`
+	Markers   []Marker `json:"markers,omitempty"`
+}
+
+// Marker is a moment flagged in a clip, like a GoPro HiLight
+type Marker struct {
+	OffsetMS int64  `json:"offset_ms"` // into the clip
+	Kind     string `json:"kind"`
+	At       string `json:"at,omitempty"` // UTC, if known
+}
+
+// ObjectMetadata is the subset of t attached to the uploaded object
+func (t Telemetry) ObjectMetadata() map[string]string {
+	out := map[string]string{"telemetry": t.Source}
+	if t.Points > 0 {
+		out["gps_points"] = strconv.Itoa(t.Points)
+	}
+	// offsets in seconds, by kind
+	offsets := map[string][]string{}
+	for _, m := range t.Markers {
+		offsets[m.Kind] = append(offsets[m.Kind], strconv.FormatFloat(float64(m.OffsetMS)/1000, 'f', 3, 64))
+	}
+	for kind, list := range offsets {
+		out[kind+"s"] = strings.Join(list, ",")
+	}
+	return out
+}
@@ -0,0 +1,94 @@
+package model
+
+import "slices"
+
+// The legal state transitions, derived from the stage table. The store
+// refuses any change of a file's state that isn't one of these.
+
+// EdgeKind says why a transition exists
+type EdgeKind string
+
+const (
+	EdgeClaim   EdgeKind = "claim"   // a worker takes a waiting file
+	EdgeAdvance EdgeKind = "advance" // a stage commits its work
+	EdgeReset   EdgeKind = "reset"   // same state, lease or backoff cleared
+	EdgeRetry   EdgeKind = "retry"   // back to a stage's Input
+	EdgeReroute EdgeKind = "reroute" // a stage sends the file to an earlier one
+	EdgeError   EdgeKind = "error"   // an attempt failed
+	EdgeFail    EdgeKind = "fail"    // out of attempts, or permanent
+	EdgeRequeue EdgeKind = "requeue" // operator brings a FAILED file back
+	EdgeSkip    EdgeKind = "skip"    // operator parks a file for good
+	EdgeCorrupt EdgeKind = "corrupt" // a file that failed validation finishes
+	EdgeAudit   EdgeKind = "audit"   // its object went missing or changed; upload it again
+)
+
+type Edge struct {
+	From FileState `json:"from"`
+	To   FileState `json:"to"`
+	Kind EdgeKind  `json:"kind"`
+}
+
+// Transitions returns every legal transition. A pair reachable for more than
+// one reason is listed once, under the first kind below that allows it.
+func Transitions() []Edge {
+	var out []Edge
+	seen := map[[2]FileState]bool{}
+	add := func(from, to FileState, kind EdgeKind) {
+		if !seen[[2]FileState{from, to}] {
+			seen[[2]FileState{from, to}] = true
+			out = append(out, Edge{From: from, To: to, Kind: kind})
+		}
+	}
+
+	for i, d := range stageDefs {
+		add(d.Input, d.Claim, EdgeClaim)
+		from := d.Claim
+		for _, to := range d.Path() {
+			add(from, to, EdgeAdvance)
+			if to == StateDone {
+				add(from, StateCorrupt, EdgeCorrupt)
+			}
+			from = to
+		}
+		for _, s := range d.states() {
+			add(s, s, EdgeReset)
+		}
+		// workers stop in Claim, reconcile finds files left in Via
+		for _, s := range append([]FileState{d.Claim}, d.Via...) {
+			add(s, d.Input, EdgeRetry)
+			add(s, StateError, EdgeError)
+			add(s, StateFailed, EdgeFail)
+		}
+		for _, earlier := range stageDefs[:i] {
+			add(d.Claim, earlier.Input, EdgeReroute)
+		}
+	}
+	for _, d := range stageDefs {
+		add(StateError, d.Input, EdgeRetry)
+		add(StateFailed, d.Input, EdgeRequeue)
+	}
+	// uploaded again from the staged copy if it is still there, else from the card
+	add(StateDone, StageUpload.RetryState(), EdgeAudit)
+	add(StateDone, stageDefs[0].Input, EdgeAudit)
+	for _, s := range AllStates {
+		if !slices.Contains(FinalStates, s) {
+			add(s, StateSkipped, EdgeSkip)
+		}
+	}
+	return out
+}
+
+// legal holds Transitions as a set; rebuilt with the stage table
+var legal map[[2]FileState]bool
+
+func rebuildTransitions() {
+	legal = map[[2]FileState]bool{}
+	for _, e := range Transitions() {
+		legal[[2]FileState{e.From, e.To}] = true
+	}
+}
+
+// CanTransition reports whether a file may go from one state to another
+func CanTransition(from, to FileState) bool {
+	return legal[[2]FileState{from, to}]
+}
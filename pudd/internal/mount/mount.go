@@ -1,18 +1,81 @@
 package mount
 
 import (
+	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"pudd/internal/errclass"
+	"pudd/internal/logging"
 )
 
-func MountRO(devNode, mountPoint string) error {
+// FAT timestamps have no zone: cameras write their wall clock. Unless told
+// otherwise the kernel takes them for the dock's local time (sys_tz) and
+// converts them to UTC, so mtimes would be off by the dock's offset on top of
+// the camera's. Mounted with these options an mtime read as UTC is the
+// camera's wall clock, which is what model.Clock corrects. By udev's
+// ID_FS_TYPE.
+var wallClock = map[string]string{
+	"vfat":  "tz=UTC",
+	"msdos": "tz=UTC",
+	"exfat": "time_offset=0",
+}
+
+// MountRO mounts devNode read-only; fsType is udev's ID_FS_TYPE, if known
+func MountRO(devNode, mountPoint, fsType string) error {
 	if err := os.MkdirAll(mountPoint, 0o755); err != nil {
-		return err
+		return errclass.FromOS(err)
+	}
+	opts := "ro"
+	if opt, ok := wallClock[fsType]; ok {
+		opts += "," + opt
 	}
-	return exec.Command("mount", "-o", "ro", devNode, mountPoint).Run()
+	err := run("mount", "-o", opts, devNode, mountPoint)
+	if err != nil && opts != "ro" {
+		// e.g. a FUSE exFAT driver that doesn't know the option
+		slog.Warn("mount with wall clock timestamps failed, mtimes may be off by the dock's UTC offset",
+			"dev", devNode, "fstype", fsType, logging.Err, err)
+		err = run("mount", "-o", "ro", devNode, mountPoint)
+	}
+	if err != nil {
+		// udev add events race with quick unplugs
+		if _, statErr := os.Stat(devNode); os.IsNotExist(statErr) {
+			return errclass.Wrap(errclass.DeviceAbsent, err)
+		}
+	}
+	return err
 }
 
 func Unmount(mountPoint string) error {
 	// umount is fine even if already unmounted; caller can ignore error if desired.
-	return exec.Command("umount", mountPoint).Run()
+	return run("umount", mountPoint)
+}
+
+// IsMounted reports whether path is the root of a mounted filesystem
+func IsMounted(path string) bool {
+	st, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	parent, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+	return st.Sys().(*syscall.Stat_t).Dev != parent.Sys().(*syscall.Stat_t).Dev
+}
+
+// run keeps the tool's stderr in the error; "exit status 32" alone says nothing
+func run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %w: %s", name, err, msg)
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
 }
@@ -0,0 +1,134 @@
+package mp4
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"pudd/internal/errclass"
+)
+
+// CheckFile checks the file at path, see Check
+func CheckFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errclass.FromOS(err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, errclass.FromOS(err)
+	}
+	return Check(f, st.Size())
+}
+
+// Check walks the box layout of an MP4 or MOV file and returns what is wrong
+// with it: boxes running past the end of the file, no moov (what a camera
+// that lost power mid-recording leaves), or chunk offsets pointing outside
+// the media data. No problems means the file looks whole; the error is only
+// for failing to read it.
+func Check(r io.ReaderAt, size int64) ([]string, error) {
+	var problems []string
+	atoms, err := layout(r, size)
+	var le *LayoutError
+	switch {
+	case errors.Is(err, ErrNotMP4):
+		return []string{err.Error()}, nil
+	case errors.As(err, &le):
+		problems = append(problems, le.Error())
+	case err != nil:
+		return nil, err
+	}
+
+	var moov *atom
+	var mdat [][2]int64 // data ranges of the mdat boxes
+	for i, a := range atoms {
+		switch a.typ {
+		case "moov":
+			moov = &atoms[i]
+		case "mdat":
+			mdat = append(mdat, [2]int64{a.off + a.hdr, a.off + a.n})
+		}
+	}
+	if moov == nil {
+		if le == nil || le.Box != "moov" {
+			problems = append(problems, "no moov box: the recording was never finalized")
+		}
+		return problems, nil
+	}
+	b, err := readAtom(r, *moov)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks, err := chunkOffsets(b)
+	if err != nil {
+		problems = append(problems, "moov: "+err.Error())
+	}
+	for i, offsets := range tracks {
+		bad, first := 0, int64(-1)
+		for _, off := range offsets {
+			if !inside(mdat, off) {
+				if bad == 0 {
+					first = off
+				}
+				bad++
+			}
+		}
+		if bad > 0 {
+			problems = append(problems, fmt.Sprintf("track %d: %d of %d chunk offsets outside the media data, first %d", i+1, bad, len(offsets), first))
+		}
+	}
+	return problems, nil
+}
+
+func inside(ranges [][2]int64, off int64) bool {
+	for _, r := range ranges {
+		if off >= r[0] && off < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// chunkOffsets returns the stco or co64 entries of each track in moov
+func chunkOffsets(moov []byte) ([][]int64, error) {
+	var tracks [][]int64
+	err := children(moov, func(typ string, c []byte) error {
+		if typ != "trak" {
+			return nil
+		}
+		var offsets []int64
+		var walk func(b []byte) error
+		walk = func(b []byte) error {
+			return children(b, func(typ string, c []byte) error {
+				switch typ {
+				case "mdia", "minf", "stbl":
+					return walk(c)
+				case "stco":
+					n := int(be32(c, 4))
+					if 8+4*n > len(c) {
+						return fmt.Errorf("stco lists %d chunks but holds %d", n, (len(c)-8)/4)
+					}
+					for i := 0; i < n; i++ {
+						offsets = append(offsets, int64(be32(c, 8+4*i)))
+					}
+				case "co64":
+					n := int(be32(c, 4))
+					if 8+8*n > len(c) {
+						return fmt.Errorf("co64 lists %d chunks but holds %d", n, (len(c)-8)/8)
+					}
+					for i := 0; i < n; i++ {
+						offsets = append(offsets, int64(be64(c, 8+8*i)))
+					}
+				}
+				return nil
+			})
+		}
+		err := walk(c)
+		tracks = append(tracks, offsets)
+		return err
+	})
+	return tracks, err
+}
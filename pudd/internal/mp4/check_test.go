@@ -0,0 +1,76 @@
+package mp4
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	build := func(change func(m *movie)) []byte {
+		m := defaultMovie()
+		change(&m)
+		return m.build()
+	}
+	whole := defaultMovie().build()
+	// ftyp, moov, then an mdat of 8+64 bytes; the timecode chunk starts the
+	// media data and the video chunk follows 4 bytes on
+	ftyp, mdatAt := whole[:32], len(whole)-72
+	moov, mdat := whole[32:mdatAt], whole[mdatAt:]
+	data := mdatAt + 8
+
+	tests := []struct {
+		name string
+		file []byte
+		want []string
+	}{
+		{"whole", whole, nil},
+		{"64-bit mdat", build(func(m *movie) { m.mdat = func(p ...[]byte) []byte { return box64("mdat", p...) } }), nil},
+		{"mdat to end of file", build(func(m *movie) { m.mdat = func(p ...[]byte) []byte { return boxToEOF("mdat", p...) } }), nil},
+		{"co64", build(func(m *movie) { m.chunks = co64 }), nil},
+		// with the mdat gone every chunk is outside it
+		{"truncated mdat", whole[:len(whole)-20], []string{
+			fmt.Sprintf(`at byte %d: box "mdat" needs 72 bytes, file ends after 52: truncated`, mdatAt),
+			fmt.Sprintf("track 1: 1 of 1 chunk offsets outside the media data, first %d", data+4),
+			fmt.Sprintf("track 2: 1 of 1 chunk offsets outside the media data, first %d", data),
+		}},
+		{"no moov", slices.Concat(ftyp, mdat), []string{
+			"no moov box: the recording was never finalized",
+		}},
+		// a truncated moov is reported once, not again as missing
+		{"truncated moov", slices.Concat(ftyp, mdat, moov[:100]), []string{
+			fmt.Sprintf(`at byte %d: box "moov" needs %d bytes, file ends after 100: truncated`, 32+len(mdat), len(moov)),
+		}},
+		{"stco past end of file", build(func(m *movie) {
+			m.chunks = func(at uint64) []byte { return stco(at + 1000) }
+		}), []string{
+			fmt.Sprintf("track 1: 1 of 1 chunk offsets outside the media data, first %d", data+4+1000),
+			fmt.Sprintf("track 2: 1 of 1 chunk offsets outside the media data, first %d", data+1000),
+		}},
+		// co64 entries are 4 bytes longer, which moves the media data on 8
+		{"co64 past end of file", build(func(m *movie) {
+			m.chunks = func(at uint64) []byte { return co64(at + 1<<32) }
+		}), []string{
+			fmt.Sprintf("track 1: 1 of 1 chunk offsets outside the media data, first %d", data+8+4+1<<32),
+			fmt.Sprintf("track 2: 1 of 1 chunk offsets outside the media data, first %d", data+8+1<<32),
+		}},
+		{"short stco", build(func(m *movie) {
+			m.chunks = func(at uint64) []byte { return box("stco", full(0, 0), u32(3), u32(uint32(at))) }
+		}), []string{
+			"moov: stco lists 3 chunks but holds 1",
+		}},
+		{"not mp4", []byte("RIFF\x24\x00\x00\x00WAVEfmt "), []string{"not an MP4/MOV file"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Check(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package mp4
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// HEIF (HEIC) stills are ISO BMFF too: the top-level meta box lists items,
+// the image itself and metadata such as EXIF and XMP, in iinf, and where
+// their bytes are in iloc.
+
+// Item is an item of a HEIF file
+type Item struct {
+	ID          uint32
+	Type        string // Exif, mime, hvc1...
+	ContentType string // for mime items, e.g. application/rdf+xml
+
+	idat    []byte     // set if the item is stored in the meta box
+	extents [][2]int64 // offset, length
+}
+
+// where iloc says an item is
+type location struct {
+	method  int // 0: file offsets, 1: offsets into idat
+	extents [][2]int64
+}
+
+// metadata items are small; this keeps a bad iloc from allocating much
+const maxItem = 16 << 20
+
+// HEIFItems lists the items of a HEIF file
+func HEIFItems(r io.ReaderAt, size int64) ([]Item, error) {
+	atoms, err := layout(r, size)
+	if err != nil {
+		return nil, err
+	}
+	var meta []byte
+	for _, a := range atoms {
+		if a.typ == "meta" {
+			if meta, err = readAtom(r, a); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(meta) < 4 {
+		return nil, errors.New("no meta box")
+	}
+
+	var items []Item
+	var locs map[uint32]location
+	var idat []byte
+	// meta is a full box
+	err = children(meta[4:], func(typ string, c []byte) error {
+		switch typ {
+		case "iinf":
+			items, err = iinf(c)
+		case "iloc":
+			locs, err = iloc(c)
+		case "idat":
+			idat = c
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range items {
+		l := locs[items[i].ID]
+		items[i].extents = l.extents
+		if l.method == 1 {
+			items[i].idat = idat
+		}
+	}
+	return items, nil
+}
+
+// iinf: a full box, an entry count, then an infe box per item
+func iinf(c []byte) ([]Item, error) {
+	if len(c) < 6 {
+		return nil, errors.New("short iinf")
+	}
+	skip := 6
+	if c[0] > 0 {
+		skip = 8
+	}
+	var items []Item
+	err := children(c[min(skip, len(c)):], func(typ string, e []byte) error {
+		// versions 0 and 1 predate item types
+		if typ != "infe" || len(e) < 4 || e[0] < 2 {
+			return nil
+		}
+		var it Item
+		rest := e[4:]
+		if e[0] == 2 {
+			it.ID, rest = uint32(be16(e, 4)), rest[min(2, len(rest)):]
+		} else {
+			it.ID, rest = be32(e, 4), rest[min(4, len(rest)):]
+		}
+		// protection index, type, name, then for mime items the content type
+		if len(rest) < 6 {
+			return nil
+		}
+		it.Type = string(rest[2:6])
+		_, rest = cstring(rest[6:])
+		if it.Type == "mime" {
+			it.ContentType, _ = cstring(rest)
+		}
+		items = append(items, it)
+		return nil
+	})
+	return items, err
+}
+
+// iloc: field widths, then per item its construction method, base offset
+// and extents
+func iloc(c []byte) (map[uint32]location, error) {
+	if len(c) < 8 {
+		return nil, errors.New("short iloc")
+	}
+	version := c[0]
+	offSize, lenSize := int(c[4]>>4), int(c[4]&0xf)
+	baseSize, indexSize := int(c[5]>>4), int(c[5]&0xf)
+	if version == 0 {
+		indexSize = 0
+	}
+	p := c[6:]
+	var short bool
+	next := func(n int) int64 {
+		if n > len(p) {
+			short = true
+			return 0
+		}
+		var v int64
+		for _, b := range p[:n] {
+			v = v<<8 | int64(b)
+		}
+		p = p[n:]
+		return v
+	}
+
+	count := next(2)
+	if version == 2 {
+		count = count<<16 | next(2)
+	}
+	out := map[uint32]location{}
+	for i := int64(0); i < count && !short; i++ {
+		var id uint32
+		if version < 2 {
+			id = uint32(next(2))
+		} else {
+			id = uint32(next(4))
+		}
+		var l location
+		if version > 0 {
+			l.method = int(next(2) & 0xf)
+		}
+		next(2) // data reference index
+		base := next(baseSize)
+		extents := next(2)
+		for j := int64(0); j < extents && !short; j++ {
+			next(indexSize)
+			off := next(offSize)
+			l.extents = append(l.extents, [2]int64{base + off, next(lenSize)})
+		}
+		out[id] = l
+	}
+	if short {
+		return nil, errors.New("iloc runs past its box")
+	}
+	return out, nil
+}
+
+// cstring splits a NUL-terminated string off b
+func cstring(b []byte) (string, []byte) {
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return string(b), nil
+	}
+	return string(b[:i]), b[i+1:]
+}
+
+// Read returns the bytes of an item
+func (it Item) Read(r io.ReaderAt) ([]byte, error) {
+	var out []byte
+	for _, e := range it.extents {
+		if e[0] < 0 || e[1] < 0 || int64(len(out))+e[1] > maxItem {
+			return nil, fmt.Errorf("item %d: bad extent %d+%d", it.ID, e[0], e[1])
+		}
+		if it.idat != nil {
+			if e[0]+e[1] > int64(len(it.idat)) {
+				return nil, fmt.Errorf("item %d: extent past the end of idat", it.ID)
+			}
+			out = append(out, it.idat[e[0]:e[0]+e[1]]...)
+			continue
+		}
+		b, err := readBox(r, e[0], e[1])
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", it.ID, err)
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
@@ -0,0 +1,69 @@
+package mp4
+
+import (
+	"bytes"
+	"testing"
+)
+
+func infe(id uint16, typ, contentType string) []byte {
+	b := [][]byte{full(2, 0), u16(id), u16(0), []byte(typ), []byte("\x00")}
+	if contentType != "" {
+		b = append(b, []byte(contentType+"\x00"))
+	}
+	return box("infe", b...)
+}
+
+// buildHEIF has an Exif item in the mdat and an XMP item in the meta box's
+// idat
+func buildHEIF(exif, xmp []byte) []byte {
+	ftyp := box("ftyp", []byte("heic"), u32(0), []byte("mif1heic"))
+	meta := func(exifAt uint32) []byte {
+		return box("meta", full(0, 0),
+			hdlr("pict"),
+			box("iinf", full(0, 0), u16(2), infe(1, "Exif", ""), infe(2, "mime", "application/rdf+xml")),
+			// version 1: 4-byte offsets and lengths, no base offset
+			box("iloc", full(1, 0), []byte{0x44, 0x00}, u16(2),
+				u16(1), u16(0), u16(0), u16(1), u32(exifAt), u32(uint32(len(exif))),
+				u16(2), u16(1), u16(0), u16(1), u32(0), u32(uint32(len(xmp))),
+			),
+			box("idat", xmp),
+		)
+	}
+	exifAt := uint32(len(ftyp) + len(meta(0)) + 8)
+	return bytes.Join([][]byte{ftyp, meta(exifAt), box("mdat", exif)}, nil)
+}
+
+func TestHEIFItems(t *testing.T) {
+	exif, xmp := []byte("Exif\x00\x00II*\x00"), []byte("<x:xmpmeta/>")
+	b := buildHEIF(exif, xmp)
+	r := bytes.NewReader(b)
+
+	items, err := HEIFItems(r, int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("got %d items, want 2", len(items))
+	}
+	want := []struct {
+		id         uint32
+		typ, ctype string
+		data       []byte
+	}{
+		{1, "Exif", "", exif},
+		{2, "mime", "application/rdf+xml", xmp},
+	}
+	for i, w := range want {
+		it := items[i]
+		if it.ID != w.id || it.Type != w.typ || it.ContentType != w.ctype {
+			t.Errorf("item %d: got %+v", i, it)
+		}
+		data, err := it.Read(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, w.data) {
+			t.Errorf("item %d: read %q, want %q", it.ID, data, w.data)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// seconds from 1904-01-01, the epoch of mvhd times, to the Unix epoch
+const epoch1904 = 2082844800
+
+type track struct {
+	handler   string // vide, soun, tmcd...
+	timescale uint32
+	width     int
+	height    int
+	codec     string
+	samples   uint64 // from stts
+	ticks     uint64 // sum of sample durations, in timescale
+	chunk     int64  // offset of the first chunk, -1 if none
+
+	// tmcd sample entry
+	tcFlags  uint32
+	tcFrames int
+}
+
+type parser struct {
+	r    io.ReaderAt
+	info *Info
+
+	timescale uint32
+	duration  uint64
+	created   time.Time // from mvhd
+
+	tracks []*track
+	// udta, mdta keys and vendor XML, by field; see finish
+	tags map[string]string
+}
+
+func be16(b []byte, off int) int {
+	if len(b) < off+2 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b[off:]))
+}
+
+func be32(b []byte, off int) uint32 {
+	if len(b) < off+4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b[off:])
+}
+
+func be64(b []byte, off int) uint64 {
+	if len(b) < off+8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b[off:])
+}
+
+func (p *parser) tag(key, val string) {
+	val = strings.TrimSpace(strings.TrimRight(val, "\x00"))
+	if val == "" {
+		return
+	}
+	if p.tags == nil {
+		p.tags = map[string]string{}
+	}
+	if _, ok := p.tags[key]; !ok {
+		p.tags[key] = val
+	}
+}
+
+func (p *parser) moov(b []byte) error {
+	return children(b, func(typ string, c []byte) error {
+		switch typ {
+		case "mvhd":
+			var created uint64
+			if len(c) > 0 && c[0] == 1 {
+				created, p.timescale, p.duration = be64(c, 4), be32(c, 20), be64(c, 24)
+			} else {
+				created, p.timescale, p.duration = uint64(be32(c, 4)), be32(c, 12), uint64(be32(c, 16))
+			}
+			if created > epoch1904 {
+				p.created = time.Unix(int64(created-epoch1904), 0).UTC()
+			}
+		case "trak":
+			t := &track{chunk: -1}
+			if err := p.trak(t, c); err != nil {
+				return err
+			}
+			p.tracks = append(p.tracks, t)
+		case "udta":
+			return p.udta(c)
+		case "meta":
+			return p.meta(c)
+		}
+		return nil
+	})
+}
+
+// trak and the boxes under it that matter, down to the sample table
+func (p *parser) trak(t *track, b []byte) error {
+	return children(b, func(typ string, c []byte) error {
+		switch typ {
+		case "mdia", "minf", "stbl":
+			return p.trak(t, c)
+		case "tkhd":
+			at := 76
+			if len(c) > 0 && c[0] == 1 {
+				at = 88
+			}
+			t.width, t.height = int(be32(c, at)>>16), int(be32(c, at+4)>>16)
+		case "mdhd":
+			if len(c) > 0 && c[0] == 1 {
+				t.timescale = be32(c, 20)
+			} else {
+				t.timescale = be32(c, 12)
+			}
+		case "hdlr":
+			if len(c) >= 12 {
+				t.handler = string(c[8:12])
+			}
+		case "stsd":
+			p.stsd(t, c)
+		case "stts":
+			n := int(be32(c, 4))
+			for i := 0; i < n && 8+i*8+8 <= len(c); i++ {
+				count, delta := uint64(be32(c, 8+i*8)), uint64(be32(c, 12+i*8))
+				t.samples += count
+				t.ticks += count * delta
+			}
+		case "stco":
+			if be32(c, 4) > 0 {
+				t.chunk = int64(be32(c, 8))
+			}
+		case "co64":
+			if be32(c, 4) > 0 {
+				t.chunk = int64(be64(c, 8))
+			}
+		}
+		return nil
+	})
+}
+
+// stsd: only the first sample entry is looked at
+func (p *parser) stsd(t *track, c []byte) {
+	if be32(c, 4) == 0 || len(c) < 16 {
+		return
+	}
+	e := c[8:]
+	if n := int(be32(e, 0)); n < len(e) {
+		e = e[:n]
+	}
+	t.codec = trim(string(e[4:8]))
+	switch t.handler {
+	case "vide":
+		if t.width == 0 {
+			t.width, t.height = be16(e, 32), be16(e, 34)
+		}
+	case "tmcd":
+		// reserved, flags, timescale, frame duration, frames per second
+		t.tcFlags, t.tcFrames = be32(e, 20), 0
+		if len(e) > 32 {
+			t.tcFrames = int(e[32])
+		}
+	}
+}
+
+// udta: QuickTime user data, ©xxx text atoms
+func (p *parser) udta(b []byte) error {
+	return children(b, func(typ string, c []byte) error {
+		switch typ {
+		case "\xa9mak":
+			p.tag("make", text(c))
+		case "\xa9mod":
+			p.tag("model", text(c))
+		case "\xa9day":
+			p.tag("date", text(c))
+		case "meta":
+			return p.meta(c)
+		}
+		return nil
+	})
+}
+
+// text decodes a QuickTime international text atom: length, language, string
+func text(c []byte) string {
+	n := be16(c, 0)
+	if n == 0 || 4+n > len(c) {
+		return ""
+	}
+	return string(c[4 : 4+n])
+}
+
+// meta holds either mdta keys with an ilst of values (Apple and most phones)
+// or, from Sony cameras, an XML document
+func (p *parser) meta(b []byte) error {
+	// ISO meta is a full box; QuickTime's starts right at its children
+	if len(b) >= 8 && string(b[4:8]) != "hdlr" {
+		b = b[4:]
+	}
+	var keys []string
+	return children(b, func(typ string, c []byte) error {
+		switch typ {
+		case "keys":
+			n := int(be32(c, 4))
+			for i, off := 0, 8; i < n && off+8 <= len(c); i++ {
+				size := int(be32(c, off))
+				if size < 8 || off+size > len(c) {
+					break
+				}
+				keys = append(keys, string(c[off+8:off+size]))
+				off += size
+			}
+		case "ilst":
+			return children(c, func(item string, d []byte) error {
+				key := item
+				if keys != nil {
+					i := int(binary.BigEndian.Uint32([]byte(item)))
+					if i < 1 || i > len(keys) {
+						return nil
+					}
+					key = keys[i-1]
+				}
+				return children(d, func(typ string, v []byte) error {
+					// data: type, locale, value; type 1 is UTF-8
+					if typ == "data" && be32(v, 0)&0xffffff == 1 && len(v) >= 8 {
+						p.ilst(key, string(v[8:]))
+					}
+					return nil
+				})
+			})
+		case "xml ":
+			if len(c) > 4 {
+				p.xml(string(c[4:]))
+			}
+		}
+		return nil
+	})
+}
+
+func (p *parser) ilst(key, val string) {
+	switch key {
+	case "com.apple.quicktime.make", "\xa9mak":
+		p.tag("make", val)
+	case "com.apple.quicktime.model", "\xa9mod":
+		p.tag("model", val)
+	case "com.apple.quicktime.creationdate", "\xa9day":
+		p.tag("date", val)
+	}
+}
+
+var (
+	xmlDevice  = regexp.MustCompile(`<Device\s[^>]*manufacturer="([^"]*)"[^>]*modelName="([^"]*)"`)
+	xmlCreated = regexp.MustCompile(`<CreationDate\s[^>]*value="([^"]*)"`)
+)
+
+// xml reads Sony's NonRealTimeMeta
+func (p *parser) xml(doc string) {
+	if m := xmlDevice.FindStringSubmatch(doc); m != nil {
+		p.tag("make", m[1])
+		p.tag("model", m[2])
+	}
+	if m := xmlCreated.FindStringSubmatch(doc); m != nil {
+		p.tag("date", m[1])
+	}
+}
+
+// tagged date layouts, the ones with an offset first
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// finish fills in Info from what the boxes said, preferring a tagged date,
+// which usually carries a zone, over mvhd's. mvhd is UTC by the spec, but
+// plenty of action cameras write local time there, so it counts as unzoned.
+func (p *parser) finish() {
+	in := p.info
+	in.Make, in.Model = p.tags["make"], p.tags["model"]
+	in.CreatedAt = p.created
+	for i, layout := range dateLayouts {
+		if t, err := time.Parse(layout, p.tags["date"]); err == nil {
+			in.CreatedAt, in.Zoned = t, i < 2
+			break
+		}
+	}
+	if p.timescale > 0 {
+		in.Duration = time.Duration(float64(p.duration) / float64(p.timescale) * float64(time.Second))
+	}
+
+	for _, t := range p.tracks {
+		switch t.handler {
+		case "vide":
+			if in.Codec != "" {
+				continue
+			}
+			in.Codec, in.Width, in.Height = t.codec, t.width, t.height
+			if t.ticks > 0 {
+				in.FrameRate = float64(t.samples) * float64(t.timescale) / float64(t.ticks)
+			}
+			if in.Duration == 0 && t.timescale > 0 {
+				in.Duration = time.Duration(float64(t.ticks) / float64(t.timescale) * float64(time.Second))
+			}
+		case "tmcd":
+			if in.Timecode == "" && t.chunk >= 0 && t.tcFrames > 0 {
+				var b [4]byte
+				if _, err := p.r.ReadAt(b[:], t.chunk); err == nil {
+					in.Timecode = Timecode(int(binary.BigEndian.Uint32(b[:])), t.tcFrames, t.tcFlags&1 != 0)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"pudd/internal/errclass"
+)
+
+// A reader for the metadata of ISO BMFF (MP4) and QuickTime (MOV) files. It
+// walks the box tree, reads moov whole and one timecode sample, and never
+// touches the media data.
+
+// Info is what a clip's container says about it. Fields the file doesn't
+// record are left zero.
+type Info struct {
+	Brand string `json:"brand"` // ftyp major brand, "qt" for QuickTime
+	// Zoned is false if the file gives no UTC offset, in which case
+	// CreatedAt is the camera's wall-clock time labelled UTC
+	CreatedAt time.Time     `json:"created_at"`
+	Zoned     bool          `json:"zoned"`
+	Duration  time.Duration `json:"duration"`
+	Width     int           `json:"width"`
+	Height    int           `json:"height"`
+	FrameRate float64       `json:"frame_rate"`
+	Codec     string        `json:"codec"` // video sample entry: avc1, hvc1, apch...
+	Make      string        `json:"make"`
+	Model     string        `json:"model"`
+	Timecode  string        `json:"timecode"` // start timecode, HH:MM:SS:FF (;FF for drop frame)
+}
+
+var ErrNotMP4 = errors.New("not an MP4/MOV file")
+
+// moov holds sample tables, so it grows with the clip; a few MB per hour
+const maxMoov = 256 << 20
+
+// boxes that may come first in a file; old QuickTime files have no ftyp
+var firstBoxes = map[string]bool{"ftyp": true, "moov": true, "mdat": true, "wide": true, "free": true, "skip": true}
+
+// ProbeFile reads the metadata of the file at path
+func ProbeFile(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, errclass.FromOS(err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return Info{}, errclass.FromOS(err)
+	}
+	return Probe(f, st.Size())
+}
+
+// Probe reads the metadata of an MP4 or MOV file of the given size
+func Probe(r io.ReaderAt, size int64) (Info, error) {
+	atoms, err := layout(r, size)
+	if err != nil {
+		return Info{}, err
+	}
+
+	var info Info
+	var moov []byte
+	for _, a := range atoms {
+		switch a.typ {
+		case "ftyp":
+			b, err := readBox(r, a.off+a.hdr, min(a.n-a.hdr, 4))
+			if err != nil {
+				return Info{}, err
+			}
+			info.Brand = trim(string(b))
+		case "moov":
+			if moov, err = readAtom(r, a); err != nil {
+				return Info{}, err
+			}
+		}
+	}
+	if moov == nil {
+		return Info{}, errors.New("no moov box")
+	}
+	if info.Brand == "" {
+		info.Brand = "qt"
+	}
+
+	p := parser{r: r, info: &info}
+	if err := p.moov(moov); err != nil {
+		return Info{}, err
+	}
+	p.finish()
+	return info, nil
+}
+
+// atom is a top-level box: its type, offset, header length and length
+type atom struct {
+	typ         string
+	off, hdr, n int64
+}
+
+// LayoutError is returned for a file whose boxes don't add up, usually
+// because it was cut short
+type LayoutError struct {
+	Off int64
+	Box string // type of the bad box, if there was one
+	Msg string
+}
+
+func (e *LayoutError) Error() string {
+	return fmt.Sprintf("at byte %d: %s", e.Off, e.Msg)
+}
+
+// layout walks the top-level boxes. On a *LayoutError it also returns the
+// boxes before the bad one.
+func layout(r io.ReaderAt, size int64) ([]atom, error) {
+	var out []atom
+	for off := int64(0); off < size; {
+		typ, hdr, n, err := readHeader(r, off, size)
+		if len(out) == 0 && (err != nil || !firstBoxes[typ]) {
+			return nil, ErrNotMP4
+		}
+		if err != nil {
+			return out, err
+		}
+		out = append(out, atom{typ: typ, off: off, hdr: hdr, n: n})
+		off += n
+	}
+	return out, nil
+}
+
+// readAtom reads the payload of a box held in memory whole, like moov
+func readAtom(r io.ReaderAt, a atom) ([]byte, error) {
+	if a.n-a.hdr > maxMoov {
+		return nil, fmt.Errorf("%s is %d bytes, more than the %d we read", a.typ, a.n-a.hdr, maxMoov)
+	}
+	return readBox(r, a.off+a.hdr, a.n-a.hdr)
+}
+
+// readHeader reads the box header at off: its type, header length and the
+// length of the whole box
+func readHeader(r io.ReaderAt, off, size int64) (string, int64, int64, error) {
+	var b [16]byte
+	if size-off < 8 {
+		return "", 0, 0, &LayoutError{Off: off, Msg: fmt.Sprintf("%d stray bytes at end of file", size-off)}
+	}
+	if _, err := r.ReadAt(b[:8], off); err != nil {
+		return "", 0, 0, err
+	}
+	typ := string(b[4:8])
+	hdr, n := int64(8), int64(binary.BigEndian.Uint32(b[:4]))
+	switch n {
+	case 0: // runs to the end of the file
+		n = size - off
+	case 1: // 64-bit size follows the type
+		if _, err := r.ReadAt(b[8:16], off+8); err != nil {
+			return "", 0, 0, err
+		}
+		hdr, n = 16, int64(binary.BigEndian.Uint64(b[8:16]))
+	}
+	if n < hdr {
+		return "", 0, 0, &LayoutError{off, typ, fmt.Sprintf("box %q has bad size %d", typ, n)}
+	}
+	if n > size-off {
+		return "", 0, 0, &LayoutError{off, typ, fmt.Sprintf("box %q needs %d bytes, file ends after %d: truncated", typ, n, size-off)}
+	}
+	return typ, hdr, n, nil
+}
+
+func readBox(r io.ReaderAt, off, n int64) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// children calls fn with the type and payload of each box packed in b
+func children(b []byte, fn func(typ string, payload []byte) error) error {
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return fmt.Errorf("%d stray bytes in box", len(b))
+		}
+		n, hdr := uint64(binary.BigEndian.Uint32(b)), uint64(8)
+		typ := string(b[4:8])
+		switch n {
+		case 0:
+			n = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return fmt.Errorf("box %q: short header", typ)
+			}
+			n, hdr = binary.BigEndian.Uint64(b[8:]), 16
+		}
+		if n < hdr || n > uint64(len(b)) {
+			return fmt.Errorf("box %q: bad size %d", typ, n)
+		}
+		if err := fn(typ, b[hdr:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func trim(s string) string {
+	for len(s) > 0 && (s[len(s)-1] == ' ' || s[len(s)-1] == 0) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
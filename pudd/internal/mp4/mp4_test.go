@@ -0,0 +1,220 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+// box packs parts into a box with a 32-bit size
+func box(typ string, parts ...[]byte) []byte {
+	payload := bytes.Join(parts, nil)
+	return append(append(u32(uint32(8+len(payload))), typ...), payload...)
+}
+
+// box64 packs parts into a box with a 64-bit size
+func box64(typ string, parts ...[]byte) []byte {
+	payload := bytes.Join(parts, nil)
+	b := append(u32(1), typ...)
+	b = append(b, u64(uint64(16+len(payload)))...)
+	return append(b, payload...)
+}
+
+// boxToEOF packs parts into a box of size 0, which runs to the end of the file
+func boxToEOF(typ string, parts ...[]byte) []byte {
+	return append(append(u32(0), typ...), bytes.Join(parts, nil)...)
+}
+
+// full is a full box's version and flags
+func full(version byte, flags uint32) []byte {
+	return append([]byte{version}, u32(flags)[1:]...)
+}
+
+func pad(n int) []byte { return make([]byte, n) }
+
+// seconds from 1904 of 2024-05-01 10:11:12 UTC
+var created = uint32(time.Date(2024, 5, 1, 10, 11, 12, 0, time.UTC).Unix() + epoch1904)
+
+func mvhd() []byte {
+	// created, modified, timescale, duration (10.01s)
+	return box("mvhd", full(0, 0), u32(created), u32(created), u32(1000), u32(10010), pad(80))
+}
+
+func mvhd64() []byte {
+	return box("mvhd", full(1, 0), u64(uint64(created)), u64(uint64(created)), u32(1000), u64(10010), pad(80))
+}
+
+func tkhd(w, h uint32) []byte {
+	// widths and heights are 16.16 fixed point
+	return box("tkhd", full(0, 0), pad(72), u32(w<<16), u32(h<<16))
+}
+
+func mdhd(timescale uint32) []byte {
+	return box("mdhd", full(0, 0), u32(0), u32(0), u32(timescale), u32(0), pad(4))
+}
+
+func hdlr(handler string) []byte {
+	return box("hdlr", full(0, 0), u32(0), []byte(handler), pad(12), []byte("name\x00"))
+}
+
+// stbl with a sample entry, the stts and a chunk offset box
+func stbl(entry []byte, stts []byte, chunks []byte) []byte {
+	return box("stbl", box("stsd", full(0, 0), u32(1), entry), stts, chunks)
+}
+
+func stco(chunk uint64) []byte { return box("stco", full(0, 0), u32(1), u32(uint32(chunk))) }
+func co64(chunk uint64) []byte { return box("co64", full(0, 0), u32(1), u64(chunk)) }
+
+func videoTrack(chunks []byte) []byte {
+	avc1 := box("avc1", pad(6), u16(1), pad(16), u16(1920), u16(1080), pad(50))
+	// 300 frames of 1001 ticks at 30000: 29.97 fps
+	stts := box("stts", full(0, 0), u32(1), u32(300), u32(1001))
+	return box("trak", tkhd(3840, 2160), box("mdia", mdhd(30000), hdlr("vide"),
+		box("minf", stbl(avc1, stts, chunks))))
+}
+
+func timecodeTrack(flags uint32, fps byte, chunks []byte) []byte {
+	tmcd := box("tmcd", pad(6), u16(1), u32(0), u32(flags), u32(30000), u32(1001), []byte{fps, 0})
+	stts := box("stts", full(0, 0), u32(1), u32(1), u32(10010))
+	return box("trak", box("mdia", mdhd(30000), hdlr("tmcd"),
+		box("minf", stbl(tmcd, stts, chunks))))
+}
+
+func udta() []byte {
+	text := func(s string) []byte { return append(append(u16(uint16(len(s))), u16(0x55c4)...), s...) }
+	return box("udta", box("\xa9mak", text("GoPro")), box("\xa9mod", text("HERO12 Black")))
+}
+
+// movie is a clip with a video and a timecode track. The timecode track's
+// only sample, frame tcFrame, starts the media data, which mdat wraps in a
+// box; the video's chunk follows it.
+type movie struct {
+	mvhd    []byte
+	udta    []byte
+	tcFlags uint32
+	tcFPS   byte
+	tcFrame uint32
+	mdat    func(parts ...[]byte) []byte
+	// chunk offset box for the offset of the media data; stco if nil
+	chunks func(at uint64) []byte
+}
+
+func (m movie) build() []byte {
+	ftyp := box("ftyp", []byte("isom"), u32(512), []byte("isomiso2avc1mp41"))
+	data := append(u32(m.tcFrame), pad(60)...)
+	mdatHdr := len(m.mdat(data)) - len(data)
+	chunks := m.chunks
+	if chunks == nil {
+		chunks = stco
+	}
+	moov := func(at uint64) []byte {
+		return box("moov", m.mvhd, videoTrack(chunks(at+4)), timecodeTrack(m.tcFlags, m.tcFPS, chunks(at)), m.udta)
+	}
+	at := uint64(len(ftyp) + len(moov(0)) + mdatHdr)
+	return bytes.Join([][]byte{ftyp, moov(at), m.mdat(data)}, nil)
+}
+
+func defaultMovie() movie {
+	return movie{mvhd: mvhd(), udta: udta(), tcFPS: 25, tcFrame: 90000 + 25*61 + 3, mdat: func(p ...[]byte) []byte { return box("mdat", p...) }}
+}
+
+func probe(t *testing.T, b []byte) Info {
+	t.Helper()
+	info, err := Probe(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestProbe(t *testing.T) {
+	info := probe(t, defaultMovie().build())
+
+	want := Info{
+		Brand:     "isom",
+		CreatedAt: time.Date(2024, 5, 1, 10, 11, 12, 0, time.UTC),
+		Duration:  10010 * time.Millisecond,
+		// tkhd's display size wins over the sample entry's
+		Width:     3840,
+		Height:    2160,
+		FrameRate: 30000.0 / 1001,
+		Codec:     "avc1",
+		Make:      "GoPro",
+		Model:     "HERO12 Black",
+		Timecode:  "01:01:01:03",
+	}
+	if info != want {
+		t.Errorf("got  %+v\nwant %+v", info, want)
+	}
+}
+
+func TestProbeBoxSizes(t *testing.T) {
+	tests := []struct {
+		name string
+		m    func(m *movie)
+	}{
+		{"version 1 mvhd", func(m *movie) { m.mvhd = mvhd64() }},
+		{"64-bit mdat", func(m *movie) { m.mdat = func(p ...[]byte) []byte { return box64("mdat", p...) } }},
+		{"mdat to end of file", func(m *movie) { m.mdat = func(p ...[]byte) []byte { return boxToEOF("mdat", p...) } }},
+		{"64-bit box in moov", func(m *movie) { m.udta = box64("udta", udta()[8:]) }},
+		{"box to end of moov", func(m *movie) { m.udta = boxToEOF("udta", udta()[8:]) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := defaultMovie()
+			tt.m(&m)
+			info := probe(t, m.build())
+			if info.Duration != 10010*time.Millisecond || info.Model != "HERO12 Black" || info.Timecode != "01:01:01:03" {
+				t.Errorf("got %+v", info)
+			}
+		})
+	}
+}
+
+func TestProbeDropFrame(t *testing.T) {
+	m := defaultMovie()
+	m.tcFlags, m.tcFPS, m.tcFrame = 1, 30, 1800
+	if tc := probe(t, m.build()).Timecode; tc != "00:01:00;02" {
+		t.Errorf("timecode %s", tc)
+	}
+}
+
+func TestProbeNotMP4(t *testing.T) {
+	b := []byte("RIFF\x24\x00\x00\x00WAVEfmt ")
+	if _, err := Probe(bytes.NewReader(b), int64(len(b))); !errors.Is(err, ErrNotMP4) {
+		t.Errorf("got %v, want ErrNotMP4", err)
+	}
+}
+
+func TestTimecode(t *testing.T) {
+	tests := []struct {
+		n, fps int
+		drop   bool
+		want   string
+	}{
+		{0, 25, false, "00:00:00:00"},
+		{90000, 25, false, "01:00:00:00"},
+		// 23.976 counts 24 frames a second
+		{24*3600 + 23, 24, false, "01:00:00:23"},
+		// past midnight it starts again
+		{24*3600*24 + 5, 24, false, "00:00:00:05"},
+		// 29.97 DF skips ;00 and ;01 at each minute but the tenth
+		{1799, 30, true, "00:00:59;29"},
+		{1800, 30, true, "00:01:00;02"},
+		{17982, 30, true, "00:10:00;00"},
+		{17982 + 1800, 30, true, "00:11:00;02"},
+		// 59.94 DF skips four
+		{3600, 60, true, "00:01:00;04"},
+	}
+	for _, tt := range tests {
+		if got := Timecode(tt.n, tt.fps, tt.drop); got != tt.want {
+			t.Errorf("Timecode(%d, %d, %v) = %s, want %s", tt.n, tt.fps, tt.drop, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package mp4
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Timed metadata, like GoPro's GPMF, is a track of its own; these read its
+// samples and the vendor boxes in moov's udta.
+
+// Sample is one sample of a track: where its bytes are and when it plays
+type Sample struct {
+	Off      int64
+	Size     int64
+	Time     time.Duration // from the start of the clip
+	Duration time.Duration
+}
+
+// sample table of a track, as read from its stbl
+type sampleTable struct {
+	format    string // first sample entry: avc1, gpmd...
+	timescale uint32
+	stts      [][2]uint32 // sample count, delta
+	stsc      [][2]uint32 // first chunk, samples per chunk
+	sizes     []uint32    // one per sample, or just the common size
+	fixed     uint32      // the common size, if all samples have one
+	chunks    []int64
+}
+
+// TrackSamples lists the samples of the first track whose sample entry is
+// format, e.g. "gpmd" for GoPro metadata; nil if there is none
+func TrackSamples(r io.ReaderAt, size int64, format string) ([]Sample, error) {
+	moov, err := readMoov(r, size)
+	if err != nil {
+		return nil, err
+	}
+	var found *sampleTable
+	err = children(moov, func(typ string, c []byte) error {
+		if typ != "trak" || found != nil {
+			return nil
+		}
+		t := &sampleTable{}
+		if err := t.read(c); err != nil {
+			return err
+		}
+		if t.format == format {
+			found = t
+		}
+		return nil
+	})
+	if err != nil || found == nil {
+		return nil, err
+	}
+	return found.samples()
+}
+
+// UserData returns the payload of moov/udta/typ, nil if there is none.
+// Cameras keep their own records there, GoPro its HiLight tags.
+func UserData(r io.ReaderAt, size int64, typ string) ([]byte, error) {
+	moov, err := readMoov(r, size)
+	if err != nil {
+		return nil, err
+	}
+	var out []byte
+	err = children(moov, func(t string, c []byte) error {
+		if t != "udta" {
+			return nil
+		}
+		return children(c, func(t string, d []byte) error {
+			if t == typ && out == nil {
+				out = d
+			}
+			return nil
+		})
+	})
+	return out, err
+}
+
+func readMoov(r io.ReaderAt, size int64) ([]byte, error) {
+	atoms, err := layout(r, size)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range atoms {
+		if a.typ == "moov" {
+			return readAtom(r, a)
+		}
+	}
+	return nil, errors.New("no moov box")
+}
+
+func (t *sampleTable) read(b []byte) error {
+	return children(b, func(typ string, c []byte) error {
+		switch typ {
+		case "mdia", "minf", "stbl":
+			return t.read(c)
+		case "mdhd":
+			if len(c) > 0 && c[0] == 1 {
+				t.timescale = be32(c, 20)
+			} else {
+				t.timescale = be32(c, 12)
+			}
+		case "stsd":
+			if be32(c, 4) > 0 && len(c) >= 16 {
+				t.format = string(c[12:16])
+			}
+		case "stts", "stsc":
+			// full box, count, then pairs; stsc has a third field
+			n, step := int(be32(c, 4)), 8
+			if typ == "stsc" {
+				step = 12
+			}
+			if 8+n*step > len(c) {
+				return fmt.Errorf("%s lists %d entries but holds %d", typ, n, (len(c)-8)/step)
+			}
+			for i := 0; i < n; i++ {
+				e := [2]uint32{be32(c, 8+i*step), be32(c, 12+i*step)}
+				if typ == "stts" {
+					t.stts = append(t.stts, e)
+				} else {
+					t.stsc = append(t.stsc, e)
+				}
+			}
+		case "stsz":
+			t.fixed = be32(c, 4)
+			n := int(be32(c, 8))
+			if t.fixed != 0 {
+				t.sizes = make([]uint32, n)
+				break
+			}
+			if 12+4*n > len(c) {
+				return fmt.Errorf("stsz lists %d samples but holds %d", n, (len(c)-12)/4)
+			}
+			for i := 0; i < n; i++ {
+				t.sizes = append(t.sizes, be32(c, 12+4*i))
+			}
+		case "stco", "co64":
+			n, w := int(be32(c, 4)), 4
+			if typ == "co64" {
+				w = 8
+			}
+			if 8+w*n > len(c) {
+				return fmt.Errorf("%s lists %d chunks but holds %d", typ, n, (len(c)-8)/w)
+			}
+			for i := 0; i < n; i++ {
+				if w == 4 {
+					t.chunks = append(t.chunks, int64(be32(c, 8+4*i)))
+				} else {
+					t.chunks = append(t.chunks, int64(be64(c, 8+8*i)))
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// samples lays the sample sizes out over the chunks, and the durations
+// over the samples
+func (t *sampleTable) samples() ([]Sample, error) {
+	if t.timescale == 0 {
+		return nil, errors.New("track has no timescale")
+	}
+	out := make([]Sample, 0, len(t.sizes))
+	s := 0
+	for i, e := range t.stsc {
+		first, perChunk := int(e[0]), int(e[1])
+		last := len(t.chunks)
+		if i+1 < len(t.stsc) {
+			last = int(t.stsc[i+1][0]) - 1
+		}
+		if first < 1 || last > len(t.chunks) {
+			return nil, fmt.Errorf("stsc run from chunk %d is outside the %d chunks", first, len(t.chunks))
+		}
+		for c := first; c <= last; c++ {
+			off := t.chunks[c-1]
+			for j := 0; j < perChunk && s < len(t.sizes); j++ {
+				size := int64(t.sizes[s])
+				if t.fixed != 0 {
+					size = int64(t.fixed)
+				}
+				out = append(out, Sample{Off: off, Size: size})
+				off += size
+				s++
+			}
+		}
+	}
+
+	var ticks uint64
+	s = 0
+	for _, e := range t.stts {
+		for j := uint32(0); j < e[0] && s < len(out); j++ {
+			out[s].Time = t.duration(ticks)
+			out[s].Duration = t.duration(uint64(e[1]))
+			ticks += uint64(e[1])
+			s++
+		}
+	}
+	return out, nil
+}
+
+func (t *sampleTable) duration(ticks uint64) time.Duration {
+	return time.Duration(float64(ticks) / float64(t.timescale) * float64(time.Second))
+}
@@ -0,0 +1,24 @@
+package mp4
+
+import "fmt"
+
+// Timecode formats frame n counted at fps frames per second. Drop frame
+// timecode (29.97, 59.94) skips frame numbers at the start of every minute
+// but each tenth to stay in step with the clock, so n is converted back to
+// the label it was given.
+func Timecode(n, fps int, drop bool) string {
+	sep := ':'
+	if drop {
+		sep = ';'
+		skip := fps / 15 // 2 at 30, 4 at 60
+		perMin := fps*60 - skip
+		per10 := perMin*10 + skip
+		tens, rem := n/per10, n%per10
+		n += skip * 9 * tens
+		if rem > skip {
+			n += skip * ((rem - skip) / perMin)
+		}
+	}
+	ff, s := n%fps, n/fps
+	return fmt.Sprintf("%02d:%02d:%02d%c%02d", s/3600%24, s/60%60, s%60, sep, ff)
+}
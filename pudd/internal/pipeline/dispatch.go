@@ -0,0 +1,177 @@
+package pipeline
+
+import (
+	"database/sql"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+
+	"pudd/internal/config"
+	"pudd/internal/logging"
+	"pudd/internal/model"
+	"pudd/internal/scheduler"
+	"pudd/internal/store"
+)
+
+// dispatcher hands runnable files to the pools. It tracks every file it
+// dispatched until a worker is done with it, so a file is never dispatched
+// twice and no pool's jobs channel, sized to its in-flight limit, fills up.
+type dispatcher struct {
+	log   *slog.Logger
+	db    *sql.DB
+	cfg   config.Config
+	sched scheduler.Scheduler
+	ps    *pauses
+	wake  *Notifier
+
+	lanes []*lane
+
+	mu       sync.Mutex
+	inFlight map[int64]*lane
+}
+
+// lane is a pool's queue and what it has in flight
+type lane struct {
+	model.Pool
+	stage Stage
+	jobs  chan model.FileRow
+	limit int
+
+	// guarded by dispatcher.mu
+	n      int
+	device map[string]int
+	dest   map[string]int
+}
+
+func newDispatcher(log *slog.Logger, db *sql.DB, cfg config.Config, sched scheduler.Scheduler, ps *pauses, wake *Notifier, stages []Stage) *dispatcher {
+	d := &dispatcher{
+		log:      log,
+		db:       db,
+		cfg:      cfg,
+		sched:    sched,
+		ps:       ps,
+		wake:     wake,
+		inFlight: map[int64]*lane{},
+	}
+	for _, s := range stages {
+		p := pool(cfg, s.Def())
+		// enough for every worker to have one more file lined up
+		limit := p.Workers * 2
+		d.lanes = append(d.lanes, &lane{
+			Pool:   p,
+			stage:  s,
+			jobs:   make(chan model.FileRow, limit),
+			limit:  limit,
+			device: map[string]int{},
+			dest:   map[string]int{},
+		})
+	}
+	return d
+}
+
+// dispatch fills every pool that isn't paused and returns when the earliest
+// backoff or stage pause runs out, zero if none does
+func (d *dispatcher) dispatch() time.Time {
+	var states []model.FileState
+	for _, l := range d.lanes {
+		if !d.runnable(l) {
+			continue
+		}
+		states = append(states, l.State)
+		d.fill(l)
+	}
+
+	next, err := store.NextRunAt(d.db, states)
+	if err != nil {
+		d.log.Error("next backoff lookup failed", logging.Err, err)
+	}
+	if p := d.ps.nextProbe(); !p.IsZero() && (next.IsZero() || p.Before(next)) {
+		next = p
+	}
+	return next
+}
+
+// runnable reports whether l's stage may take files: it is ready and isn't
+// paused
+func (d *dispatcher) runnable(l *lane) bool {
+	if r, ok := l.stage.(Readier); ok && !r.Ready() {
+		return false
+	}
+	return !d.ps.paused(d.log, l.Stage, d.cfg.StageRoot)
+}
+
+// fill dispatches as many files to l as it has room for
+func (d *dispatcher) fill(l *lane) {
+	d.mu.Lock()
+	q := store.RunnableQuery{
+		States:    []model.FileState{l.State},
+		Skip:      d.skip(),
+		PerDevice: l.PerDevice,
+		Busy:      maps.Clone(l.device),
+	}
+	room := l.limit - l.n
+	if l.PerDest > 0 {
+		room = min(room, l.PerDest-l.dest[Destination(d.cfg)])
+	}
+	d.mu.Unlock()
+	if room <= 0 {
+		return
+	}
+
+	rows, err := d.sched.Next(d.db, q, room)
+	if err != nil {
+		d.log.Error("pipeline fetch failed", logging.Stage, l.Stage, logging.Err, err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, f := range rows {
+		d.inFlight[f.ID] = l
+		l.n++
+		l.device[f.DeviceID]++
+		if l.PerDest > 0 {
+			l.dest[Destination(d.cfg)]++
+		}
+		// can't block: the channel holds limit files and fewer than that
+		// are in flight
+		l.jobs <- f
+	}
+}
+
+// done is called by a worker once it is finished with f, however that went.
+// f may have moved on to a state another pool picks up, so look again.
+func (d *dispatcher) done(f model.FileRow) {
+	d.mu.Lock()
+	if l := d.inFlight[f.ID]; l != nil {
+		delete(d.inFlight, f.ID)
+		l.n--
+		if l.device[f.DeviceID]--; l.device[f.DeviceID] == 0 {
+			delete(l.device, f.DeviceID)
+		}
+		if l.PerDest > 0 {
+			l.dest[Destination(d.cfg)]--
+		}
+	}
+	d.mu.Unlock()
+	d.wake.Notify()
+}
+
+// close stops every pool once its queue is empty
+func (d *dispatcher) close() {
+	for _, l := range d.lanes {
+		close(l.jobs)
+	}
+}
+
+// skip lists every file in flight in any pool; a file one pool just moved
+// on is already runnable for the next before its worker is done. Callers
+// hold d.mu.
+func (d *dispatcher) skip() []int64 {
+	ids := make([]int64, 0, len(d.inFlight))
+	for id := range d.inFlight {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"pudd/internal/config"
+	"pudd/internal/logging"
+	"pudd/internal/model"
+	"pudd/internal/store"
+)
+
+// A claim's lease is much shorter than a large copy or upload, so it is kept
+// alive by a heartbeat for as long as the worker is on the file. If a renewal
+// finds the row re-claimed, the worker's context is cancelled with
+// store.ErrLeaseLost and the claim generation in f stops any further
+// transitions (see store.Transition).
+
+// keepLease renews f's lease every third of cfg.Lease until the returned
+// release func is called
+func keepLease(ctx context.Context, log *slog.Logger, db *sql.DB, cfg config.Config, f model.FileRow) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	every := cfg.Lease / 3
+	if every <= 0 {
+		every = time.Second
+	}
+
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				ok, err := store.Renew(db, f.ID, f.ClaimGen, cfg.Lease)
+				if err != nil {
+					// the lease still has two thirds left; try again next tick
+					log.Warn("lease renewal failed", "claim_gen", f.ClaimGen, logging.Err, err)
+					continue
+				}
+				if !ok {
+					log.Error("lease lost, stopping", "claim_gen", f.ClaimGen)
+					cancel(store.ErrLeaseLost)
+					return
+				}
+			}
+		}
+	}()
+
+	return ctx, func() {
+		close(done)
+		cancel(nil)
+	}
+}
+
+// holdsLease renews f's lease right before something that can't be undone,
+// like deleting a file, and reports whether the worker still owns f
+func holdsLease(log *slog.Logger, db *sql.DB, cfg config.Config, f model.FileRow) bool {
+	ok, err := store.Renew(db, f.ID, f.ClaimGen, cfg.Lease)
+	if err != nil {
+		log.Error("lease check failed", "claim_gen", f.ClaimGen, logging.Err, err)
+		return false
+	}
+	if !ok {
+		log.Warn("lease lost, leaving file to its new owner", "claim_gen", f.ClaimGen)
+	}
+	return ok
+}
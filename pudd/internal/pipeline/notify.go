@@ -0,0 +1,20 @@
+package pipeline
+
+// Notifier wakes the dispatcher when something in this process may have made
+// files runnable: a card was plugged in and discovered, or a worker is done
+// with a file. Notify never blocks; notifications that arrive while one is
+// pending are merged into it.
+type Notifier struct {
+	c chan struct{}
+}
+
+func NewNotifier() *Notifier {
+	return &Notifier{c: make(chan struct{}, 1)}
+}
+
+func (n *Notifier) Notify() {
+	select {
+	case n.c <- struct{}{}:
+	default:
+	}
+}
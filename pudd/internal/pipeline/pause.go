@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"pudd/internal/diskspace"
+	"pudd/internal/errclass"
+	"pudd/internal/logging"
+	"pudd/internal/model"
+)
+
+// free space on top of the failed file's size before a disk-full copy stage resumes
+const resumeHeadroom = 256 << 20
+
+// pauses tracks stages stopped by a stage-wide error. Every file in the stage
+// would fail the same way, so instead of burning through them the pipeline
+// stops dispatching the stage until the cause clears or `until` passes.
+type pauses struct {
+	mu     sync.Mutex
+	stages map[model.Stage]pause
+}
+
+type pause struct {
+	class errclass.Class
+	until time.Time // probe again after this even if nothing changed
+	// ResourceExhausted: free bytes on StageRoot that let the stage resume
+	needBytes int64
+}
+
+func newPauses() *pauses {
+	return &pauses{stages: map[model.Stage]pause{}}
+}
+
+func (p *pauses) pause(log *slog.Logger, stage model.Stage, pp pause) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.stages[stage]; !ok {
+		log.Warn("stage paused", logging.Stage, stage, "class", pp.class, "until", pp.until, "need_bytes", pp.needBytes)
+	}
+	p.stages[stage] = pp
+}
+
+// paused reports whether stage is paused, resuming it first if its cause cleared
+func (p *pauses) paused(log *slog.Logger, stage model.Stage, stageRoot string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pp, ok := p.stages[stage]
+	if !ok {
+		return false
+	}
+
+	resume := time.Now().After(pp.until)
+	if !resume && pp.class == errclass.ResourceExhausted {
+		free, err := diskspace.Free(stageRoot)
+		if err != nil {
+			log.Warn("statfs stage root failed", "path", stageRoot, logging.Err, err)
+		}
+		resume = err == nil && free >= uint64(pp.needBytes)+resumeHeadroom
+	}
+	if resume {
+		delete(p.stages, stage)
+		log.Info("stage resumed", logging.Stage, stage, "class", pp.class)
+	}
+	return !resume
+}
+
+// nextProbe returns the earliest time a paused stage is due to be probed
+// again, zero if no stage is paused
+func (p *pauses) nextProbe() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var next time.Time
+	for _, pp := range p.stages {
+		if next.IsZero() || pp.until.Before(next) {
+			next = pp.until
+		}
+	}
+	return next
+}
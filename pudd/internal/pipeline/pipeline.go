@@ -3,174 +3,318 @@ package pipeline
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"strings"
+	"sync"
 	"time"
 
-	"pudd/internal/camerautil"
+	"pudd/internal/admission"
 	"pudd/internal/config"
-	"pudd/internal/copyutil"
-	"pudd/internal/hash"
+	"pudd/internal/errclass"
+	"pudd/internal/logging"
+	"pudd/internal/metrics"
 	"pudd/internal/model"
+	"pudd/internal/mount"
+	"pudd/internal/scheduler"
 	"pudd/internal/store"
 )
 
+// Uploader stores a staged file as the object ObjectName names; meta is
+// attached to the stored object. UploadSidecar stores something derived from f, like its GPS track, next
+// to f's object: under the same name with ext in place of its extension.
 type Uploader interface {
-	UploadAndVerify(ctx context.Context, f model.FileRow) error
+	ObjectName(f model.FileRow) string
+	UploadAndVerify(ctx context.Context, f model.FileRow, meta map[string]string) error
+	UploadSidecar(ctx context.Context, f model.FileRow, ext, contentType string, body []byte) error
 }
 
-func Run(ctx context.Context, logger *log.Logger, db *sql.DB, cfg config.Config, uploader Uploader) {
-	jobs := make(chan model.FileRow, cfg.Workers*2)
+// how long workers get to notice the grace period is over before their
+// rows are released anyway
+const stopWait = 10 * time.Second
 
-	for i := 0; i < cfg.Workers; i++ {
-		i := i
-		go workerLoop(ctx, logger, db, cfg, uploader, i, jobs)
+// Summary is what the pipeline's workers did between Run and shutdown
+type Summary struct {
+	Copied   int64 `json:"copied"`
+	Uploaded int64 `json:"uploaded"`
+	Done     int64 `json:"done"`
+	Errors   int64 `json:"errors"`
+	Failed   int64 `json:"failed"`
+	Released int64 `json:"released"` // in flight at shutdown, handed back for the next run
+	// files the workers found corrupt; they go to the quarantine prefix
+	Corrupt []model.FileRow `json:"corrupt,omitempty"`
+}
+
+// Run dispatches runnable files to the workers until ctx is done. It then
+// stops claiming, gives in-flight work cfg.ShutdownGrace to finish, interrupts
+// what is left and hands those rows back to the store.
+//
+// Dispatching is driven by wake, by workers finishing files and by a timer
+// set to the earliest backoff or pause expiry. cfg.PollInterval only has to
+// catch changes made by other processes, like `pudd retry`.
+func Run(ctx context.Context, logger *slog.Logger, db *sql.DB, cfg config.Config, sched scheduler.Scheduler, uploader Uploader, wake *Notifier) Summary {
+	started := time.Now()
+	ps := newPauses()
+	adm := admission.New(cfg)
+	d := newDispatcher(logger, db, cfg, sched, ps, wake, stages(logger, ps, adm, uploader))
+
+	// in-flight copies and uploads outlive ctx by up to the grace period
+	workCtx, interrupt := context.WithCancel(context.WithoutCancel(ctx))
+	defer interrupt()
+
+	var wg sync.WaitGroup
+	var workers []string
+	for _, l := range d.lanes {
+		for i := 0; i < l.Workers; i++ {
+			workerID := "pipe-" + string(l.Stage) + "-" + strconvI(i) + "-" + strconvI(os.Getpid())
+			workers = append(workers, workerID)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				workerLoop(ctx, workCtx, logger, db, cfg, ps, workerID, d, l)
+			}()
+		}
+		logger.Info("pool started", logging.Stage, l.Stage, "workers", l.Workers, "per_device", l.PerDevice, "per_dest", l.PerDest)
 	}
 
-	ticker := time.NewTicker(cfg.PollInterval)
-	defer ticker.Stop()
+	poll := time.NewTicker(cfg.PollInterval)
+	defer poll.Stop()
+	// fires right away for the first dispatch
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			close(jobs)
-			return
-		case <-ticker.C:
-			rows, err := store.FetchRunnable(db, 100)
-			if err != nil {
-				logger.Printf("pipeline fetch error: %v", err)
-				continue
-			}
-			for _, f := range rows {
-				select {
-				case jobs <- f:
-				default:
-					break
-				}
-			}
+			d.close()
+			return drain(logger, db, cfg, &wg, interrupt, workers, started)
+		case <-wake.c:
+		case <-timer.C:
+		case <-poll.C:
+		}
+		if next := d.dispatch(); next.IsZero() {
+			timer.Stop()
+		} else {
+			timer.Reset(time.Until(next))
 		}
 	}
 }
 
-func workerLoop(ctx context.Context, logger *log.Logger, db *sql.DB, cfg config.Config, uploader Uploader, idx int, jobs <-chan model.FileRow) {
-	workerID := "pipe-" + strconvI(idx) + "-" + strconvI(os.Getpid())
+// stages returns the built-in and registered stages in pipeline order
+func stages(log *slog.Logger, ps *pauses, adm *admission.Controller, uploader Uploader) []Stage {
+	byName := map[model.Stage]Stage{}
+	for _, s := range builtinStages(ps, adm, uploader) {
+		byName[s.Def().Name] = s
+	}
+	for name, s := range registered {
+		byName[name] = s
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case f, ok := <-jobs:
-			if !ok { return }
-
-			switch f.State {
-			case model.StateDiscovered:
-				handleDiscovered(ctx, logger, db, cfg, workerID, f)
-			case model.StateQueued:
-				if uploader == nil {
-					// Upload not configured
-					continue
-				}
-				handleQueued(ctx, logger, db, cfg, workerID, f, uploader)
-			case model.StateVerified:
-				handleVerified(ctx, logger, db, cfg, workerID, f)
-			default:
-				// ignore
-			}
+	var out []Stage
+	for _, def := range model.Stages() {
+		s, ok := byName[def.Name]
+		if !ok {
+			log.Error("no handler for stage, its files will wait", logging.Stage, def.Name)
+			continue
 		}
+		out = append(out, s)
 	}
+	return out
 }
 
-func handleDiscovered(ctx context.Context, logger *log.Logger, db *sql.DB, cfg config.Config, workerID string, f model.FileRow) {
-	claimed, err := store.ClaimDiscovered(db, f.ID, workerID, cfg.Lease)
-	if err != nil || !claimed {
-		return
+// drain waits for the workers to finish what they hold, then releases
+// whatever they didn't get to finish
+func drain(logger *slog.Logger, db *sql.DB, cfg config.Config, wg *sync.WaitGroup, interrupt context.CancelFunc, workers []string, started time.Time) Summary {
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	logger.Info("pipeline draining", "grace", cfg.ShutdownGrace)
+	select {
+	case <-stopped:
+	case <-time.After(cfg.ShutdownGrace):
+		logger.Warn("grace period over, interrupting in-flight work")
+		interrupt()
+		select {
+		case <-stopped:
+		case <-time.After(stopWait):
+			logger.Warn("workers still busy, releasing their rows anyway")
+		}
 	}
 
-	// Compute absolute source file path from mount root + device_id + src_path
-	srcAbs := filepath.Join(cfg.MountRoot, f.DeviceID, strings.TrimPrefix(f.SrcPath, "/"))
+	var sum Summary
+	released, err := store.ReleaseClaims(db, workers)
+	if err != nil {
+		logger.Error("release claims failed", logging.Err, err)
+	}
+	sum.Released = released
 
-	// Copy with atomic tmp + fsync + rename
-	if err := copyutil.CopyAtomic(srcAbs, f.StagedPath); err != nil {
-		store.MarkErrorWithBackoff(db, f.ID, err)
-		return
+	counts, err := store.CountEvents(db, started, workers)
+	if err != nil {
+		logger.Error("count events failed", logging.Err, err)
+		return sum
 	}
+	sum.Copied = counts[model.StateCopied]
+	sum.Uploaded = counts[model.StateUploaded]
+	sum.Done = counts[model.StateDone]
+	sum.Errors = counts[model.StateError]
+	sum.Failed = counts[model.StateFailed]
 
-	// Optional: delete from camera right after copy (DANGEROUS)
-	if cfg.DeleteCameraAfterCopy {
-		mountPoint := filepath.Join(cfg.MountRoot, f.DeviceID)
-		if err := camerautil.DeleteFromCamera(mountPoint, srcAbs); err != nil {
-			// If deletion fails, do NOT fail the pipeline; just log + continue.
-			// (You may want a separate "camera_deleted" flag later.)
-			logger.Printf("[%s] camera delete failed file=%d: %v", workerID, f.ID, err)
+	sum.Corrupt, err = store.CorruptSince(db, started, workers)
+	if err != nil {
+		logger.Error("list corrupt files failed", logging.Err, err)
+	}
+	return sum
+}
+
+// workerLoop takes jobs from its pool until they run out or ctx is done.
+// Stages run on workCtx, which is only cancelled once the shutdown grace
+// period is over.
+func workerLoop(ctx, workCtx context.Context, logger *slog.Logger, db *sql.DB, cfg config.Config, ps *pauses, workerID string, d *dispatcher, l *lane) {
+	for f := range l.jobs {
+		// shutting down: leave the rest of the queue unclaimed
+		if ctx.Err() != nil {
+			return
 		}
+
+		log := logger.With(logging.Worker, workerID, logging.FileID, f.ID, logging.DeviceID, f.DeviceID)
+		runStage(workCtx, log, db, cfg, ps, l.stage, workerID, f)
+		d.done(f)
 	}
+}
 
-	// Update state to COPIED
-	_ = store.Transition(db, f.ID, model.StateCopying, model.StateCopied)
+// runStage claims f for st, runs it and moves f on according to the outcome
+func runStage(ctx context.Context, log *slog.Logger, db *sql.DB, cfg config.Config, ps *pauses, st Stage, workerID string, f model.FileRow) {
+	def := st.Def()
+	log = log.With(logging.Stage, def.Name)
+	job := &Job{File: f, Log: log, DB: db, Cfg: cfg, Worker: workerID}
 
-	// Hash the staged file once
-	h, err := hash.Compute(f.StagedPath)
+	if g, ok := st.(Gate); ok {
+		admitted, release := g.Admit(job)
+		if !admitted {
+			return
+		}
+		defer release()
+	}
+
+	gen, err := store.Claim(db, f.ID, workerID, def.Name, cfg.Lease)
 	if err != nil {
-		store.MarkErrorWithBackoff(db, f.ID, err)
+		log.Error("claim failed", logging.Err, err)
 		return
 	}
-	if err := store.UpdateHashes(db, f.ID, h.Size, h.SHA256, h.CRC32C); err != nil {
-		store.MarkErrorWithBackoff(db, f.ID, err)
+	if gen == 0 {
 		return
 	}
+	job.File.ClaimGen = gen
+	ctx, release := keepLease(ctx, log, db, cfg, job.File)
+	defer release()
 
-	_ = store.Transition(db, f.ID, model.StateCopied, model.StateHashed)
-	_ = store.Transition(db, f.ID, model.StateHashed, model.StateQueued)
-}
-
-func handleQueued(ctx context.Context, logger *log.Logger, db *sql.DB, cfg config.Config, workerID string, f model.FileRow, uploader Uploader) {
-	claimed, err := store.ClaimQueued(db, f.ID, workerID, cfg.Lease)
-	if err != nil || !claimed {
-		return
-	}
+	start := time.Now()
+	err = st.Handle(ctx, job)
+	metrics.ObserveStage(string(def.Name), start, err)
 
-	// Ensure we have hashes (in case you inserted QUEUED elsewhere)
-	if f.Size == 0 || f.SHA256 == "" || f.CRC32C == 0 {
-		h, err := hash.Compute(f.StagedPath)
-		if err != nil {
-			store.MarkErrorWithBackoff(db, f.ID, err)
+	var re *Reroute
+	switch {
+	case errors.Is(err, store.ErrLeaseLost):
+		log.Warn("lease lost, leaving file to its new owner", logging.Err, err)
+	case errors.As(err, &re) && ctx.Err() == nil:
+		log.Warn("stage rerouted file", "to", re.To, "reason", re.Reason)
+		transition(log, db, job.File, def.Claim, re.To.RetryState())
+	case err != nil:
+		fail(ctx, log, db, cfg, ps, string(def.Name), job.File, err)
+	default:
+		if !complete(log, db, job, def) {
 			return
 		}
-		if err := store.UpdateHashes(db, f.ID, h.Size, h.SHA256, h.CRC32C); err != nil {
-			store.MarkErrorWithBackoff(db, f.ID, err)
-			return
+		if def.Output() == model.StateDone {
+			log.Info("done", logging.Bytes, job.File.Size)
 		}
-		f.Size, f.SHA256, f.CRC32C = h.Size, h.SHA256, h.CRC32C
 	}
+}
 
-	if err := uploader.UploadAndVerify(ctx, f); err != nil {
-		store.MarkErrorWithBackoff(db, f.ID, err)
+// fail counts the error against stage and hands the file back to the store,
+// which retries or fails it depending on the error class. Stage-wide errors
+// also pause the stage here.
+//
+// Errors caused by interrupting the work don't count: after a shutdown drain
+// hands those rows back, after a lost lease the row belongs to someone else.
+func fail(ctx context.Context, log *slog.Logger, db *sql.DB, cfg config.Config, ps *pauses, stage string, f model.FileRow, err error) {
+	if ctx.Err() != nil {
+		log.Warn("stage interrupted", "cause", context.Cause(ctx), logging.Err, err)
 		return
 	}
+	class := errclass.Of(err)
+	metrics.Error(stage, err)
+	log.Warn("stage failed", logging.Err, err, "class", class, "attempts", f.Attempts+1)
 
-	_ = store.Transition(db, f.ID, model.StateUploading, model.StateUploaded)
-	_ = store.Transition(db, f.ID, model.StateUploaded, model.StateVerified)
-}
+	switch class {
+	case errclass.ResourceExhausted:
+		ps.pause(log, f.State.Stage(), pause{class: class, until: time.Now().Add(cfg.DiskFullPause), needBytes: f.Size})
+	case errclass.Auth:
+		ps.pause(log, f.State.Stage(), pause{class: class, until: time.Now().Add(cfg.AuthPause)})
+	}
 
-func handleVerified(ctx context.Context, logger *log.Logger, db *sql.DB, cfg config.Config, workerID string, f model.FileRow) {
-	claimed, err := store.ClaimVerified(db, f.ID, workerID, cfg.Lease)
-	if err != nil || !claimed {
+	failed, err := store.MarkErrorWithBackoff(db, f.ID, f.ClaimGen, err, cfg.MaxAttempts(f.State.Stage()))
+	if errors.Is(err, store.ErrLeaseLost) {
+		log.Warn("lease lost, leaving file to its new owner", logging.Err, err)
 		return
 	}
+	if err != nil {
+		log.Error("mark error failed", logging.Err, err)
+		return
+	}
+	if failed {
+		log.Error("file is FAILED", "class", class, "attempts", f.Attempts+1)
+	}
+}
 
-	if cfg.DeleteLocalAfterVerify {
-		if err := os.Remove(f.StagedPath); err != nil {
-			// keep it retriable
-			store.MarkErrorWithBackoff(db, f.ID, err)
-			_ = store.Transition(db, f.ID, model.StateCleaning, model.StateVerified)
-			return
+// refineMissingSource turns "source missing" into a permanent error when the
+// card is still mounted and readable, i.e. the file itself is gone. A yanked
+// card leaves its mount behind until the remove event, so the directory has
+// to be readable too, not just mounted.
+func refineMissingSource(cfg config.Config, f model.FileRow, srcAbs string, err error) error {
+	if errclass.Of(err) != errclass.DeviceAbsent || !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if !mount.IsMounted(filepath.Join(cfg.MountRoot, f.DeviceID)) {
+		return err
+	}
+	if _, dirErr := os.ReadDir(filepath.Dir(srcAbs)); dirErr != nil {
+		return err
+	}
+	return errclass.Wrapf(errclass.Permanent, "source file no longer on device: %w", err)
+}
+
+// complete moves the job's file along def's Path with the job's updates,
+// logging a failure
+func complete(log *slog.Logger, db *sql.DB, job *Job, def model.StageDef) bool {
+	if err := store.Complete(db, job.File.ID, job.File.ClaimGen, def.Name, job.updates...); err != nil {
+		if errors.Is(err, store.ErrLeaseLost) {
+			log.Warn("lease lost, leaving file to its new owner", "from", def.Claim, logging.Err, err)
+			return false
 		}
+		log.Error("transition failed", "from", def.Claim, "to", def.Output(), logging.Err, err)
+		return false
 	}
+	return true
+}
 
-	_ = store.Transition(db, f.ID, model.StateCleaning, model.StateDone)
+// transition logs a failed state change; callers stop when it returns false
+func transition(log *slog.Logger, db *sql.DB, f model.FileRow, from, to model.FileState) bool {
+	if err := store.Transition(db, f.ID, f.ClaimGen, from, to); err != nil {
+		if errors.Is(err, store.ErrLeaseLost) {
+			log.Warn("lease lost, leaving file to its new owner", "from", from, "to", to, logging.Err, err)
+			return false
+		}
+		log.Error("transition failed", "from", from, "to", to, logging.Err, err)
+		return false
+	}
+	return true
 }
 
 func strconvI(v int) string {
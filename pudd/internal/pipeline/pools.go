@@ -0,0 +1,46 @@
+package pipeline
+
+import (
+	"pudd/internal/config"
+	"pudd/internal/model"
+)
+
+// Pools returns the pools cfg asks for, in pipeline order
+func Pools(cfg config.Config) []model.Pool {
+	var out []model.Pool
+	for _, def := range model.Stages() {
+		out = append(out, pool(cfg, def))
+	}
+	return out
+}
+
+func pool(cfg config.Config, def model.StageDef) model.Pool {
+	p := model.Pool{Stage: def.Name, State: def.Input, Workers: 1}
+	switch def.Name {
+	case model.StageCopy:
+		p.Workers, p.PerDevice = cfg.CopyWorkers, cfg.CopyPerDevice
+	case model.StageValidate:
+		p.Workers = cfg.ValidateWorkers
+	case model.StageProbe:
+		p.Workers = cfg.ProbeWorkers
+	case model.StageHash:
+		p.Workers = cfg.HashWorkers
+	case model.StageUpload:
+		p.Workers, p.PerDest = cfg.UploadWorkers, cfg.UploadPerDest
+	case model.StageClean:
+		p.Workers = cfg.CleanWorkers
+	default:
+		p.Workers = cfg.Workers
+		if s, ok := registered[def.Name].(Sizer); ok {
+			p.Workers = s.Workers(cfg)
+		}
+	}
+	p.Workers = max(p.Workers, 1)
+	return p
+}
+
+// Destination names where uploads go. Every file goes to the one bucket for
+// now, so all uploads count against the same per-destination limit.
+func Destination(cfg config.Config) string {
+	return "gs://" + cfg.Bucket + "/" + cfg.ObjectPrefix
+}
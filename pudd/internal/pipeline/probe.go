@@ -0,0 +1,202 @@
+package pipeline
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pudd/internal/exif"
+	"pudd/internal/model"
+	"pudd/internal/mp4"
+	"pudd/internal/store"
+	"pudd/internal/telemetry"
+	"pudd/internal/wav"
+)
+
+// validators check a staged file's structure, by lowercase extension. They
+// return what they found wrong; an error means the file couldn't be read.
+var validators = map[string]func(path string) ([]string, error){
+	".mp4":  mp4.CheckFile,
+	".mov":  mp4.CheckFile,
+	".m4v":  mp4.CheckFile,
+	".insv": mp4.CheckFile,
+	".lrv":  mp4.CheckFile,
+}
+
+// probers read a staged file's metadata, by lowercase extension
+var probers = map[string]func(path string) (model.Media, error){
+	".mp4":  probeMP4,
+	".mov":  probeMP4,
+	".m4v":  probeMP4,
+	".insv": probeMP4,
+	".lrv":  probeMP4,
+	".jpg":  probeStill,
+	".jpeg": probeStill,
+	".heic": probeStill,
+	".heif": probeStill,
+	".dng":  probeStill,
+	".arw":  probeStill,
+	".wav":  probeWAV,
+}
+
+// audioReaders read what a staged recording says beyond its Media, by
+// lowercase extension
+var audioReaders = map[string]func(path string) (model.Audio, error){
+	".wav": readWAV,
+}
+
+// telemetryReaders read the telemetry recorded with a staged file, by
+// lowercase extension. clock corrects times the device stamped by its own
+// clock.
+var telemetryReaders = map[string]func(path string, clock model.Clock) (telemetry.Track, error){
+	".mp4": func(path string, _ model.Clock) (telemetry.Track, error) { return telemetry.ReadGoProFile(path) },
+	".srt": func(path string, clock model.Clock) (telemetry.Track, error) {
+		return telemetry.ReadSRTFile(path, func(t time.Time) time.Time { return clock.True(t, false) })
+	},
+}
+
+// sidecar extensions: files that describe the clip of the same name
+var sidecars = map[string]bool{".srt": true}
+
+// clip extensions a sidecar's clip may have, in the case cameras write them
+var clipExtensions = []string{".MP4", ".mp4", ".MOV", ".mov"}
+
+func sidecar(srcPath string) bool {
+	return sidecars[strings.ToLower(filepath.Ext(srcPath))]
+}
+
+// clipPaths lists where the clip a sidecar describes may be
+func clipPaths(srcPath string) []string {
+	stem := strings.TrimSuffix(srcPath, filepath.Ext(srcPath))
+	var out []string
+	for _, ext := range clipExtensions {
+		out = append(out, stem+ext)
+	}
+	return out
+}
+
+// deviceClock is the clock of the job's device; UTC with no skew if the
+// device isn't known
+func deviceClock(job *Job) model.Clock {
+	d, err := store.GetDevice(job.DB, job.File.DeviceID)
+	if err != nil {
+		return model.Clock{Zone: time.UTC}
+	}
+	return d.Clock()
+}
+
+// summarize is what the store keeps of a track
+func summarize(t telemetry.Track) model.Telemetry {
+	sum := model.Telemetry{Source: t.Source, Device: t.Device, Points: len(t.Points)}
+	if start, end := t.Span(); !start.IsZero() {
+		sum.StartedAt, sum.EndedAt = start.Format(time.RFC3339Nano), end.Format(time.RFC3339Nano)
+	}
+	for _, m := range t.Markers {
+		mk := model.Marker{OffsetMS: m.Offset.Milliseconds(), Kind: m.Kind}
+		if !m.Time.IsZero() {
+			mk.At = m.Time.Format(time.RFC3339Nano)
+		}
+		sum.Markers = append(sum.Markers, mk)
+	}
+	return sum
+}
+
+func probeMP4(path string) (model.Media, error) {
+	info, err := mp4.ProbeFile(path)
+	if err != nil {
+		return model.Media{}, err
+	}
+	m := model.Media{
+		Format:     info.Brand,
+		DurationMS: info.Duration.Milliseconds(),
+		Width:      info.Width,
+		Height:     info.Height,
+		FrameRate:  info.FrameRate,
+		Codec:      info.Codec,
+		Make:       info.Make,
+		Model:      info.Model,
+		Timecode:   info.Timecode,
+	}
+	m.CreatedAt = recorded(info.CreatedAt, info.Zoned)
+	return m, nil
+}
+
+func probeStill(path string) (model.Media, error) {
+	info, err := exif.ReadFile(path)
+	if err != nil {
+		return model.Media{}, err
+	}
+	m := model.Media{
+		Format:      info.Format,
+		Width:       info.Width,
+		Height:      info.Height,
+		Make:        info.Make,
+		Model:       info.Model,
+		Serial:      info.Serial,
+		Lens:        info.Lens,
+		Orientation: info.Orientation,
+	}
+	m.CreatedAt = recorded(info.CapturedAt, info.Zoned)
+	if g := info.GPS; g != nil {
+		m.GPS = &model.GPS{Lat: g.Lat, Lon: g.Lon, Alt: g.Alt}
+	}
+	return m, nil
+}
+
+// recorded formats a time a file gives for Media.CreatedAt: with its offset
+// if zoned, without one if not
+func recorded(t time.Time, zoned bool) string {
+	switch {
+	case t.IsZero():
+		return ""
+	case zoned:
+		return t.Format(time.RFC3339Nano)
+	}
+	return t.Format(model.LocalLayout)
+}
+
+func probeWAV(path string) (model.Media, error) {
+	info, err := wav.ReadFile(path)
+	if err != nil {
+		return model.Media{}, err
+	}
+	m := model.Media{
+		Format:     info.Format,
+		DurationMS: info.Duration.Milliseconds(),
+		Codec:      info.Codec,
+		Model:      info.Originator,
+		Timecode:   info.Timecode,
+	}
+	// BWF dates are by the recorder's clock, with no zone
+	m.CreatedAt = recorded(info.RecordedAt, false)
+	return m, nil
+}
+
+func readWAV(path string) (model.Audio, error) {
+	info, err := wav.ReadFile(path)
+	if err != nil {
+		return model.Audio{}, err
+	}
+	a := model.Audio{
+		SampleRate:   info.SampleRate,
+		Channels:     info.Channels,
+		BitDepth:     info.BitDepth,
+		TimecodeRate: info.TimecodeRate,
+		Originator:   info.Originator,
+		Description:  info.Description,
+		Project:      info.Project,
+		Scene:        info.Scene,
+		Take:         info.Take,
+		Tape:         info.Tape,
+		Note:         info.Note,
+		Circled:      info.Circled,
+	}
+	if info.TimeReference != nil {
+		ref := int64(*info.TimeReference)
+		a.TimeReference = &ref
+	}
+	for _, t := range info.Tracks {
+		a.Tracks = append(a.Tracks, model.AudioTrack{Channel: t.Channel, Name: t.Name, Function: t.Function})
+	}
+	return a, nil
+}
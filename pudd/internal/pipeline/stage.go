@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"pudd/internal/config"
+	"pudd/internal/model"
+	"pudd/internal/store"
+)
+
+// Stage is the work behind one model.StageDef. The pipeline claims files
+// waiting in the stage's Input, runs Handle under a lease it keeps renewing
+// and, when Handle returns nil, moves the file along the stage's Path. Any
+// other error goes through the store's retry rules for the stage, except a
+// *Reroute.
+type Stage interface {
+	Def() model.StageDef
+	Handle(ctx context.Context, job *Job) error
+}
+
+// Gate is implemented by stages that decide, before a file is claimed,
+// whether it may start now. A file turned away stays where it is; release is
+// called once the stage is over with an admitted one.
+type Gate interface {
+	Admit(job *Job) (ok bool, release func())
+}
+
+// Readier is implemented by stages that can't run at all without something
+// set up, like uploads without an uploader. Their files are left waiting.
+type Readier interface {
+	Ready() bool
+}
+
+// Sizer lets a registered stage size its worker pool; without it the pool
+// has cfg.Workers workers, or one
+type Sizer interface {
+	Workers(cfg config.Config) int
+}
+
+// Job is a file handed to a stage
+type Job struct {
+	File   model.FileRow // ClaimGen is the stage's claim once Handle runs
+	Log    *slog.Logger
+	DB     *sql.DB
+	Cfg    config.Config
+	Worker string
+
+	updates []store.Update
+}
+
+// Save has u committed with the stage's transitions once Handle returns nil,
+// so the file never ends up past the stage without it
+func (j *Job) Save(u store.Update) {
+	j.updates = append(j.updates, u)
+}
+
+// HoldsLease checks with the store that the job's claim is still current.
+// Do it right before anything that can't be undone, like deleting a file.
+func (j *Job) HoldsLease() bool {
+	return holdsLease(j.Log, j.DB, j.Cfg, j.File)
+}
+
+// Reroute is returned by a stage that found the file has to go back to
+// another stage, e.g. to copy when the staged file is gone. The file moves
+// to that stage's Input without using an attempt.
+type Reroute struct {
+	To     model.Stage
+	Reason string
+}
+
+func (r *Reroute) Error() string {
+	return fmt.Sprintf("back to %s: %s", r.To, r.Reason)
+}
+
+// stages added with Register, by name
+var registered = map[model.Stage]Stage{}
+
+// Register inserts s into the pipeline after stage `after`: files `after` is
+// done with go to s's Input, and s hands them on to where `after` used to.
+// Call it from an init function; see model.InsertStage.
+func Register(s Stage, after model.Stage) {
+	def := s.Def()
+	model.InsertStage(def, after)
+	registered[def.Name] = s
+}
+
+// stageDef looks up the definition of a built-in stage
+func stageDef(name model.Stage) model.StageDef {
+	def, _ := name.Def()
+	return def
+}
@@ -0,0 +1,345 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pudd/internal/admission"
+	"pudd/internal/camerautil"
+	"pudd/internal/copyutil"
+	"pudd/internal/errclass"
+	"pudd/internal/hash"
+	"pudd/internal/logging"
+	"pudd/internal/metrics"
+	"pudd/internal/model"
+	"pudd/internal/store"
+	"pudd/internal/telemetry"
+)
+
+// The built-in stages: copy off the card, check the copy isn't a broken
+// recording, read the media metadata, hash it, upload it and clean up
+// staging.
+
+func builtinStages(ps *pauses, adm *admission.Controller, uploader Uploader) []Stage {
+	return []Stage{
+		copyStage{ps: ps, adm: adm},
+		validateStage{},
+		probeStage{},
+		hashStage{},
+		uploadStage{uploader: uploader},
+		cleanStage{},
+	}
+}
+
+type copyStage struct {
+	ps  *pauses
+	adm *admission.Controller
+}
+
+func (copyStage) Def() model.StageDef { return stageDef(model.StageCopy) }
+
+// Admit only lets a copy start if staging has room for it
+func (s copyStage) Admit(job *Job) (bool, func()) {
+	f := job.File
+	decision, err := s.adm.Admit(job.DB, f)
+	if err != nil {
+		job.Log.Error("admission check failed", logging.Err, err)
+		return false, nil
+	}
+	switch decision {
+	case admission.NoSpace:
+		s.ps.pause(job.Log, model.StageCopy, pause{
+			class:     errclass.ResourceExhausted,
+			until:     time.Now().Add(job.Cfg.DiskFullPause),
+			needBytes: f.Size + int64(job.Cfg.StageReserve),
+		})
+		return false, nil
+	case admission.OverQuota:
+		job.Log.Debug("device over staging quota, copy waits", logging.Bytes, f.Size)
+		return false, nil
+	case admission.TooBig:
+		job.Log.Debug("file too big for staging's free space, smaller copies go first", logging.Bytes, f.Size)
+		return false, nil
+	}
+	return true, func() { s.adm.Release(f) }
+}
+
+func (copyStage) Handle(ctx context.Context, job *Job) error {
+	f, cfg := job.File, job.Cfg
+
+	// Compute absolute source file path from mount root + device_id + src_path
+	srcAbs := filepath.Join(cfg.MountRoot, f.DeviceID, strings.TrimPrefix(f.SrcPath, "/"))
+
+	// Copy with atomic tmp + fsync + rename
+	start := time.Now()
+	if err := copyutil.CopyAtomic(ctx, srcAbs, f.StagedPath); err != nil {
+		return refineMissingSource(cfg, f, srcAbs, err)
+	}
+	job.Log.Info("copied", "src", srcAbs, logging.Bytes, f.Size, logging.Duration, time.Since(start))
+
+	// Optional: delete from camera right after copy (DANGEROUS)
+	if cfg.DeleteCameraAfterCopy && job.HoldsLease() {
+		mountPoint := filepath.Join(cfg.MountRoot, f.DeviceID)
+		if err := camerautil.DeleteFromCamera(mountPoint, srcAbs); err != nil {
+			// If deletion fails, do NOT fail the pipeline; just log + continue.
+			// (You may want a separate "camera_deleted" flag later.)
+			job.Log.Warn("camera delete failed", "src", srcAbs, logging.Err, err)
+		}
+	}
+	return nil
+}
+
+type validateStage struct{}
+
+func (validateStage) Def() model.StageDef { return stageDef(model.StageValidate) }
+
+// Handle records what is structurally wrong with the file, if anything. A
+// corrupt file isn't held back: it is uploaded to the quarantine prefix and
+// ends up CORRUPT instead of DONE.
+func (validateStage) Handle(ctx context.Context, job *Job) error {
+	f := job.File
+	check := validators[strings.ToLower(filepath.Ext(f.SrcPath))]
+	if check == nil {
+		return nil
+	}
+
+	problems, err := check(f.StagedPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Reroute{To: model.StageCopy, Reason: "staged file missing"}
+	}
+	if err != nil {
+		return err
+	}
+	detail := strings.Join(problems, "; ")
+	job.Save(store.SetCorrupt(detail))
+	if detail != "" {
+		job.Log.Warn("file failed validation, quarantining it", "problems", detail)
+	}
+	return nil
+}
+
+type probeStage struct{}
+
+func (probeStage) Def() model.StageDef { return stageDef(model.StageProbe) }
+
+// Handle records what the file says about itself: a clip's container, a
+// still's EXIF and XMP, a recording's BWF and iXML, and telemetry such as a
+// GoPro's GPS track. Files of a kind with no prober pass straight through,
+// and so do those a prober can't make sense of: metadata is nice to have, the upload isn't.
+func (probeStage) Handle(ctx context.Context, job *Job) error {
+	f := job.File
+	ext := strings.ToLower(filepath.Ext(f.SrcPath))
+	if probe := probers[ext]; probe != nil {
+		start := time.Now()
+		m, err := probe(f.StagedPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			return &Reroute{To: model.StageCopy, Reason: "staged file missing"}
+		}
+		if err != nil {
+			// nothing new if validation already said the file is broken
+			level := slog.LevelWarn
+			if f.Corrupt != "" {
+				level = slog.LevelDebug
+			}
+			job.Log.Log(ctx, level, "probe failed, uploading without metadata", logging.Err, err)
+		} else {
+			job.Save(store.SetMedia(m))
+			job.Log.Info("probed", logging.Duration, time.Since(start), "format", m.Format, "codec", m.Codec, "created", m.CreatedAt)
+		}
+	}
+
+	if read := audioReaders[ext]; read != nil {
+		// a file the prober couldn't read fails here too, and it has
+		// already said why
+		if a, err := read(f.StagedPath); err == nil {
+			job.Save(store.SetAudio(a))
+			job.Log.Info("read recording", "scene", a.Scene, "take", a.Take, "tracks", len(a.Tracks))
+		}
+	}
+
+	if read := telemetryReaders[ext]; read != nil {
+		t, err := read(f.StagedPath, deviceClock(job))
+		if errors.Is(err, fs.ErrNotExist) {
+			return &Reroute{To: model.StageCopy, Reason: "staged file missing"}
+		}
+		if err != nil {
+			job.Log.Warn("reading telemetry failed, uploading without it", logging.Err, err)
+			return nil
+		}
+		if !t.Empty() {
+			sum := summarize(t)
+			job.Save(store.SetTelemetry(sum))
+			job.Log.Info("read telemetry", "source", sum.Source, "points", sum.Points, "markers", len(sum.Markers))
+		}
+	}
+	return nil
+}
+
+type hashStage struct{}
+
+func (hashStage) Def() model.StageDef { return stageDef(model.StageHash) }
+
+func (hashStage) Handle(ctx context.Context, job *Job) error {
+	f := job.File
+
+	// Hash the staged file once
+	start := time.Now()
+	h, err := hash.Compute(ctx, f.StagedPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		// nothing to hash: the staged copy is gone, so copy it again
+		return &Reroute{To: model.StageCopy, Reason: "staged file missing"}
+	}
+	if err != nil {
+		return err
+	}
+	job.Save(store.SetHashes(h.Size, h.SHA256, h.CRC32C))
+	job.Log.Info("hashed", logging.Bytes, h.Size, logging.Duration, time.Since(start), "sha256", h.SHA256)
+	return nil
+}
+
+type uploadStage struct {
+	uploader Uploader
+}
+
+func (uploadStage) Def() model.StageDef { return stageDef(model.StageUpload) }
+
+func (s uploadStage) Ready() bool { return s.uploader != nil }
+
+func (s uploadStage) Handle(ctx context.Context, job *Job) error {
+	f := &job.File
+
+	// Ensure we have hashes (in case you inserted QUEUED elsewhere)
+	if f.Size == 0 || f.SHA256 == "" || f.CRC32C == 0 {
+		start := time.Now()
+		h, err := hash.Compute(ctx, f.StagedPath)
+		metrics.ObserveStage(metrics.StageHash, start, err)
+		if errors.Is(err, fs.ErrNotExist) {
+			return &Reroute{To: model.StageCopy, Reason: "staged file missing"}
+		}
+		if err != nil {
+			return err
+		}
+		job.Save(store.SetHashes(h.Size, h.SHA256, h.CRC32C))
+		f.Size, f.SHA256, f.CRC32C = h.Size, h.SHA256, h.CRC32C
+	}
+
+	m, _, err := store.GetMedia(job.DB, f.ID)
+	if err != nil {
+		return err
+	}
+	meta := m.ObjectMetadata()
+
+	a, ok, err := store.GetAudio(job.DB, f.ID)
+	if err != nil {
+		return err
+	}
+	if ok {
+		for k, v := range a.ObjectMetadata() {
+			meta[k] = v
+		}
+	}
+
+	t, ok, err := store.GetTelemetry(job.DB, f.ID)
+	if err != nil {
+		return err
+	}
+	if ok {
+		// sidecars first: once the file is uploaded the stage is done
+		err := s.uploadTelemetry(ctx, job)
+		if errors.Is(err, fs.ErrNotExist) {
+			return &Reroute{To: model.StageCopy, Reason: "staged file missing"}
+		}
+		if err != nil {
+			return err
+		}
+		for k, v := range t.ObjectMetadata() {
+			meta[k] = v
+		}
+	}
+
+	start := time.Now()
+	err = s.uploader.UploadAndVerify(ctx, *f, meta)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Reroute{To: model.StageCopy, Reason: "staged file missing"}
+	}
+	if err != nil {
+		return err
+	}
+	// what the auditor checks later, whatever the layout is by then
+	job.Save(store.SetObject(s.uploader.ObjectName(*f)))
+	job.Log.Info("uploaded", logging.Bytes, f.Size, logging.Duration, time.Since(start))
+	return nil
+}
+
+// uploadTelemetry uploads the file's telemetry as GPX and GeoJSON, next to
+// the clip it belongs to: the file itself, or for a sidecar like a DJI
+// .SRT the clip of the same name. A sidecar that beats its clip through
+// probing can land apart from it with a dated object layout, as the clip's
+// capture time may still change.
+func (s uploadStage) uploadTelemetry(ctx context.Context, job *Job) error {
+	f := job.File
+	read := telemetryReaders[strings.ToLower(filepath.Ext(f.SrcPath))]
+	if read == nil {
+		return nil
+	}
+	t, err := read(f.StagedPath, deviceClock(job))
+	if err != nil {
+		return err
+	}
+
+	clip := f
+	if sidecar(f.SrcPath) {
+		c, ok, err := store.FindSource(job.DB, f.DeviceID, clipPaths(f.SrcPath))
+		if err != nil {
+			return err
+		}
+		if ok {
+			clip = c
+		}
+	}
+	name := path.Base(clip.SrcPath)
+
+	var gpx, geo bytes.Buffer
+	if err := telemetry.WriteGPX(&gpx, t, name); err != nil {
+		return err
+	}
+	if err := telemetry.WriteGeoJSON(&geo, t, name); err != nil {
+		return err
+	}
+	if err := s.uploader.UploadSidecar(ctx, clip, ".gpx", "application/gpx+xml", gpx.Bytes()); err != nil {
+		return err
+	}
+	if err := s.uploader.UploadSidecar(ctx, clip, ".geojson", "application/geo+json", geo.Bytes()); err != nil {
+		return err
+	}
+	job.Log.Info("uploaded telemetry", "clip", clip.ID, "points", len(t.Points), "markers", len(t.Markers))
+	return nil
+}
+
+type cleanStage struct{}
+
+func (cleanStage) Def() model.StageDef { return stageDef(model.StageClean) }
+
+func (cleanStage) Handle(ctx context.Context, job *Job) error {
+	if !job.Cfg.DeleteLocalAfterVerify {
+		return nil
+	}
+	// another worker may own the file by now; only its owner deletes
+	if !job.HoldsLease() {
+		return store.ErrLeaseLost
+	}
+	// keep it retriable; already gone is as good as removed
+	err := os.Remove(job.File.StagedPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
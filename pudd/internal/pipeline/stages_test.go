@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pudd/internal/gcs"
+	"pudd/internal/model"
+	"pudd/internal/store"
+)
+
+func box(typ string, size uint32, payload string) string {
+	return string(binary.BigEndian.AppendUint32(nil, size)) + typ + payload
+}
+
+func TestValidateQuarantines(t *testing.T) {
+	ftyp := box("ftyp", 16, "isom\x00\x00\x02\x00")
+	tests := []struct {
+		name    string
+		file    string
+		corrupt string
+		prefix  string
+	}{
+		{"whole", ftyp + box("moov", 8, "") + box("mdat", 12, "data"), "", "media/"},
+		// the card was pulled while the camera was still writing
+		{"truncated", ftyp + box("mdat", 1000, "data"), `at byte 16: box "mdat" needs 1000 bytes, file ends after 12: truncated; no moov box: the recording was never finalized`, "quarantine/"},
+	}
+	uploader, err := gcs.NewUploader(nil, "bucket", "media", "quarantine", "{device}/{id}.bin", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := store.OpenMemory()
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { db.Close() })
+
+			staged := filepath.Join(t.TempDir(), "1.bin")
+			if err := os.WriteFile(staged, []byte(tt.file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err = store.InsertDiscovered(db, store.DiscoveredRow{
+				DeviceID:   "cam",
+				SrcPath:    "/DCIM/100GOPRO/GX010001.MP4",
+				StagedPath: staged,
+				Size:       int64(len(tt.file)),
+				State:      model.StageValidate.RetryState(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			def := stageDef(model.StageValidate)
+			gen, err := store.Claim(db, 1, "test", def.Name, time.Minute)
+			if err != nil || gen == 0 {
+				t.Fatalf("claim: gen %d, %v", gen, err)
+			}
+			f, err := store.GetFile(db, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			log := slog.New(slog.DiscardHandler)
+			job := &Job{File: f, Log: log, DB: db, Worker: "test"}
+			if err := (validateStage{}).Handle(context.Background(), job); err != nil {
+				t.Fatal(err)
+			}
+			if !complete(log, db, job, def) {
+				t.Fatal("complete failed")
+			}
+
+			f, err = store.GetFile(db, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Corrupt != tt.corrupt {
+				t.Errorf("corrupt %q, want %q", f.Corrupt, tt.corrupt)
+			}
+			if f.State != def.Output() {
+				t.Errorf("state %s, want %s", f.State, def.Output())
+			}
+			if name := uploader.ObjectName(f); !strings.HasPrefix(name, tt.prefix) {
+				t.Errorf("object %s, want it under %s", name, tt.prefix)
+			}
+		})
+	}
+}
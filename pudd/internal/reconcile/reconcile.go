@@ -0,0 +1,289 @@
+package reconcile
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pudd/internal/config"
+	"pudd/internal/model"
+	"pudd/internal/mount"
+	"pudd/internal/store"
+)
+
+// Reconciliation repairs what a crash leaves behind: rows stuck in or
+// between stages, stray files in staging and mounts nobody owns. The daemon runs it
+// before starting the pipeline and `pudd reconcile` runs it on demand. Rows
+// and files a live worker holds a lease on are never touched. Mounts are only
+// swept by the daemon at startup: a running daemon mounts a card before it
+// records the device as present, so an on-demand sweep could unmount a card
+// that is being plugged in.
+
+// worker name in the journal for changes made here
+const worker = "reconcile"
+
+// Action kinds
+const (
+	Repair    = "repair"     // stuck row moved on to where its worker would have taken it
+	Requeue   = "requeue"    // stuck row sent back to redo its stage
+	RemoveTmp = "remove_tmp" // partial copy left by a dead worker
+	Adopt     = "adopt"      // staged file without a row, given one
+	Orphan    = "orphan"     // staged file without a row that couldn't be adopted; left alone
+	Unmount   = "unmount"    // pudd mountpoint no present device is using
+)
+
+type Action struct {
+	Kind   string          `json:"kind"`
+	FileID int64           `json:"file_id,omitempty"`
+	Path   string          `json:"path,omitempty"`
+	From   model.FileState `json:"from,omitempty"`
+	To     model.FileState `json:"to,omitempty"`
+	Detail string          `json:"detail,omitempty"`
+	Err    string          `json:"error,omitempty"` // the action was attempted and failed
+}
+
+type Report struct {
+	DryRun  bool     `json:"dry_run"`
+	Actions []Action `json:"actions"`
+}
+
+// Changes counts the actions that changed something (or would, in a dry run)
+func (r Report) Changes() int {
+	n := 0
+	for _, a := range r.Actions {
+		if a.Kind != Orphan && a.Err == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed counts the actions that were attempted and failed
+func (r Report) Failed() int {
+	n := 0
+	for _, a := range r.Actions {
+		if a.Err != "" {
+			n++
+		}
+	}
+	return n
+}
+
+type reconciler struct {
+	db     *sql.DB
+	cfg    config.Config
+	dryRun bool
+	report Report
+}
+
+// Run reconciles the db, StageRoot and, with mounts, the mount roots. With
+// dryRun it only reports what it would do. Problems with single items end up
+// in the report; the error is for failures that stopped the pass.
+func Run(ctx context.Context, db *sql.DB, cfg config.Config, dryRun, mounts bool) (Report, error) {
+	r := &reconciler{db: db, cfg: cfg, dryRun: dryRun}
+	r.report.DryRun = dryRun
+	r.report.Actions = []Action{}
+
+	if mounts {
+		if err := r.mounts(); err != nil {
+			return r.report, fmt.Errorf("mounts: %w", err)
+		}
+	}
+	if err := r.staging(ctx); err != nil {
+		return r.report, fmt.Errorf("staging: %w", err)
+	}
+	if err := r.stuck(ctx); err != nil {
+		return r.report, fmt.Errorf("stuck rows: %w", err)
+	}
+	return r.report, nil
+}
+
+func (r *reconciler) add(a Action, err error) {
+	if err != nil {
+		a.Err = err.Error()
+	}
+	r.report.Actions = append(r.report.Actions, a)
+}
+
+// mounts unmounts anything mounted under MountRoot or ProbeRoot that isn't
+// the mountpoint of a present device
+func (r *reconciler) mounts() error {
+	devices, err := store.ListDevices(r.db)
+	if err != nil {
+		return err
+	}
+	inUse := map[string]bool{}
+	for _, d := range devices {
+		if d.Present {
+			inUse[d.MountPoint] = true
+		}
+	}
+
+	for _, root := range []string{r.cfg.ProbeRoot, r.cfg.MountRoot} {
+		entries, err := os.ReadDir(root)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			mp := filepath.Join(root, e.Name())
+			if !e.IsDir() || mp == r.cfg.ProbeRoot || inUse[mp] || !mount.IsMounted(mp) {
+				continue
+			}
+			var err error
+			if !r.dryRun {
+				err = mount.Unmount(mp)
+			}
+			r.add(Action{Kind: Unmount, Path: mp}, err)
+		}
+	}
+	return nil
+}
+
+// staging removes partial copies and adopts staged files that have no row
+func (r *reconciler) staging(ctx context.Context) error {
+	known, err := store.StagedPaths(r.db)
+	if err != nil {
+		return err
+	}
+
+	err = filepath.WalkDir(r.cfg.StageRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == r.cfg.StageRoot && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		if final, ok := strings.CutSuffix(path, ".tmp"); ok {
+			// a live copy is writing it
+			if known[final] {
+				return nil
+			}
+			var err error
+			if !r.dryRun {
+				err = os.Remove(path)
+			}
+			r.add(Action{Kind: RemoveTmp, Path: path}, err)
+			return nil
+		}
+
+		if _, ok := known[path]; !ok {
+			r.adopt(path)
+		}
+		return nil
+	})
+	return err
+}
+
+// adopt gives a staged file with no row a COPIED one, which the probe workers
+// then pick up like any other. Staged files live at StageRoot/<device>/<src
+// path>, which is enough to rebuild the row.
+func (r *reconciler) adopt(path string) {
+	rel, err := filepath.Rel(r.cfg.StageRoot, path)
+	if err != nil {
+		r.add(Action{Kind: Orphan, Path: path}, err)
+		return
+	}
+	deviceID, src, ok := strings.Cut(filepath.ToSlash(rel), "/")
+	if !ok {
+		r.add(Action{Kind: Orphan, Path: path, Detail: "not under a device directory"}, nil)
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		r.add(Action{Kind: Orphan, Path: path}, err)
+		return
+	}
+
+	if r.dryRun {
+		r.add(Action{Kind: Adopt, Path: path, To: model.StateCopied, Detail: "device " + deviceID}, nil)
+		return
+	}
+	ok, err = store.InsertDiscovered(r.db, store.DiscoveredRow{
+		DeviceID:   deviceID,
+		SrcPath:    "/" + src,
+		StagedPath: path,
+		Size:       info.Size(),
+		State:      model.StateCopied,
+		By:         worker,
+	})
+	if err == nil && !ok {
+		// same device, path and size as a row that stages elsewhere
+		r.add(Action{Kind: Orphan, Path: path, Detail: "conflicts with an existing row"}, nil)
+		return
+	}
+	r.add(Action{Kind: Adopt, Path: path, To: model.StateCopied, Detail: "device " + deviceID}, err)
+}
+
+// stuck sends rows whose worker died mid-stage back to the start of their
+// stage. Rows whose worker died between steps move on to the next step when
+// the staged file allows it, and back to the start of their stage otherwise.
+func (r *reconciler) stuck(ctx context.Context) error {
+	files, err := store.FetchStuck(r.db)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		def, _ := f.State.Stage().Def()
+		var kind string
+		var path []model.FileState
+		switch {
+		case f.State.InFlight():
+			// whatever the worker did is unaccounted for
+			kind, path = Requeue, []model.FileState{def.Input}
+		case f.State == model.StateUploaded:
+			// UploadAndVerify checked the object before the row got here
+			kind, path = Repair, def.Rest(f.State)
+		case stagedComplete(f):
+			kind, path = Repair, def.Rest(f.State)
+		default:
+			// run the stage again; hashing sends the file back to be
+			// copied if the staged file is gone
+			kind, path = Requeue, []model.FileState{def.Input}
+		}
+		a := Action{Kind: kind, FileID: f.ID, Path: f.StagedPath, From: f.State, To: path[len(path)-1]}
+		if r.dryRun {
+			r.add(a, nil)
+			continue
+		}
+		r.add(a, r.advance(f, path))
+	}
+	return nil
+}
+
+func (r *reconciler) advance(f model.FileRow, path []model.FileState) error {
+	gen, err := store.TakeOver(r.db, f.ID, f.State, worker)
+	if err != nil {
+		return err
+	}
+	if gen == 0 {
+		return errors.New("row changed while reconciling")
+	}
+
+	return store.Advance(r.db, f.ID, gen, f.State, path)
+}
+
+// stagedComplete reports whether the staged file is there in full
+func stagedComplete(f model.FileRow) bool {
+	info, err := os.Stat(f.StagedPath)
+	return err == nil && info.Size() == f.Size
+}
@@ -0,0 +1,170 @@
+package scheduler
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"pudd/internal/config"
+	"pudd/internal/model"
+	"pudd/internal/store"
+)
+
+// A Scheduler decides which runnable files the pipeline hands to its workers
+// next. The pipeline asks for as many as it has room for, so everything
+// returned is dispatched.
+type Scheduler interface {
+	// Next returns up to n of the runnable files q asks for, most urgent first
+	Next(db *sql.DB, q store.RunnableQuery, n int) ([]model.FileRow, error)
+}
+
+// New builds the scheduler selected by cfg.Schedule
+func New(cfg config.Config) (Scheduler, error) {
+	switch cfg.Schedule {
+	case "fifo":
+		return FIFO{}, nil
+	case "fair", "":
+	default:
+		return nil, fmt.Errorf("unknown schedule %q (want fair or fifo)", cfg.Schedule)
+	}
+
+	order := store.Order{Key: store.SortKey(cfg.ScheduleOrder)}
+	if order.Key == store.SortClass {
+		for _, name := range strings.Split(cfg.ClassOrder, ",") {
+			c := model.MediaClass(strings.TrimSpace(name))
+			if c.Extensions() == nil && c != model.MediaOther {
+				return nil, fmt.Errorf("unknown media class %q in class order", name)
+			}
+			order.Classes = append(order.Classes, c)
+		}
+	}
+	return NewFair(order)
+}
+
+// FIFO serves files in discovery order across all devices
+type FIFO struct{}
+
+func (FIFO) Next(db *sql.DB, q store.RunnableQuery, n int) ([]model.FileRow, error) {
+	return store.FetchRunnable(db, q, n)
+}
+
+// Fair serves devices round-robin, higher priority devices first, and each
+// device's files in Order. Every device keeps a keyset cursor, so each call
+// carries on after the files it served last time instead of starting over;
+// when a device runs out past its cursor it wraps around to pick up files
+// that became runnable behind it.
+type Fair struct {
+	order store.Order
+
+	mu sync.Mutex
+	// by the states asked for: each pool walks its own queue
+	walks map[string]*walk
+}
+
+type walk struct {
+	cursors map[string]store.Cursor
+	// rotates which device of a tier goes first
+	turn int
+}
+
+func NewFair(order store.Order) (*Fair, error) {
+	if err := order.Validate(); err != nil {
+		return nil, err
+	}
+	return &Fair{order: order, walks: map[string]*walk{}}, nil
+}
+
+func (s *Fair) Next(db *sql.DB, q store.RunnableQuery, n int) ([]model.FileRow, error) {
+	if n <= 0 || len(q.States) == 0 {
+		return nil, nil
+	}
+	devices, err := store.RunnableDevices(db, q)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := s.walk(q.States)
+
+	var out []model.FileRow
+	for _, tier := range tiers(devices) {
+		if len(out) == n {
+			break
+		}
+		queues := make([][]store.RunnableFile, len(tier))
+		for i, d := range tier {
+			room := q.DeviceRoom(d.DeviceID, n-len(out))
+			if room <= 0 {
+				continue
+			}
+			if queues[i], err = s.fetch(db, w, d.DeviceID, q, room); err != nil {
+				return nil, err
+			}
+		}
+		out = w.interleave(out, tier, queues, n)
+	}
+	w.turn++
+	return out, nil
+}
+
+func (s *Fair) walk(states []model.FileState) *walk {
+	key := fmt.Sprint(states)
+	w := s.walks[key]
+	if w == nil {
+		w = &walk{cursors: map[string]store.Cursor{}}
+		s.walks[key] = w
+	}
+	return w
+}
+
+// fetch reads up to n of a device's files after its cursor, wrapping around
+// to the start once the cursor has passed them all
+func (s *Fair) fetch(db *sql.DB, w *walk, deviceID string, q store.RunnableQuery, n int) ([]store.RunnableFile, error) {
+	cur := w.cursors[deviceID]
+	files, err := store.FetchRunnableAfter(db, deviceID, q, s.order, cur, n)
+	if err != nil || len(files) > 0 || cur.ID == 0 {
+		return files, err
+	}
+	delete(w.cursors, deviceID)
+	return store.FetchRunnableAfter(db, deviceID, q, s.order, store.Cursor{}, n)
+}
+
+// interleave takes one file per device per round, starting at a rotating
+// device, until n files are out or the queues are empty. Cursors only move
+// past files that were taken.
+func (w *walk) interleave(out []model.FileRow, tier []store.DeviceQueue, queues [][]store.RunnableFile, n int) []model.FileRow {
+	for round := 0; len(out) < n; round++ {
+		took := false
+		for i := range tier {
+			if len(out) == n {
+				break
+			}
+			d := (i + w.turn) % len(tier)
+			if round >= len(queues[d]) {
+				continue
+			}
+			rf := queues[d][round]
+			out = append(out, rf.File)
+			w.cursors[tier[d].DeviceID] = rf.Cursor
+			took = true
+		}
+		if !took {
+			break
+		}
+	}
+	return out
+}
+
+// tiers splits devices, which come sorted by priority, into runs of equal priority
+func tiers(devices []store.DeviceQueue) [][]store.DeviceQueue {
+	var out [][]store.DeviceQueue
+	for i, d := range devices {
+		if i == 0 || d.Priority != devices[i-1].Priority {
+			out = append(out, nil)
+		}
+		out[len(out)-1] = append(out[len(out)-1], d)
+	}
+	return out
+}
@@ -0,0 +1,240 @@
+package scheduler
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"testing"
+
+	"pudd/internal/model"
+	"pudd/internal/store"
+)
+
+var queued = store.RunnableQuery{States: []model.FileState{model.StateQueued}}
+
+// testFile is a QUEUED row to seed
+type testFile struct {
+	device   string
+	path     string
+	size     int64
+	captured string // empty for unknown
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := store.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// seed inserts files in order and returns their ids by path
+func seed(t *testing.T, db *sql.DB, files ...testFile) map[string]int64 {
+	t.Helper()
+	ids := map[string]int64{}
+	for _, f := range files {
+		if _, err := store.InsertDiscovered(db, store.DiscoveredRow{DeviceID: f.device, SrcPath: f.path, Size: f.size, State: model.StateQueued}); err != nil {
+			t.Fatal(err)
+		}
+		var id int64
+		if err := db.QueryRow(`SELECT id FROM files WHERE device_id = ? AND src_path = ?`, f.device, f.path).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		if f.captured != "" {
+			if _, err := db.Exec(`UPDATE files SET captured_at = ? WHERE id = ?`, f.captured, id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids[f.path] = id
+	}
+	return ids
+}
+
+func setPriority(t *testing.T, db *sql.DB, device string, priority int64) {
+	t.Helper()
+	if err := store.DeviceAdded(db, model.Device{DeviceID: device}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SetDeviceInfo(db, model.Device{DeviceID: device, Priority: priority}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newFair(t *testing.T, order store.Order) *Fair {
+	t.Helper()
+	s, err := NewFair(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// next returns the src paths of what s serves next
+func next(t *testing.T, s Scheduler, db *sql.DB, q store.RunnableQuery, n int) []string {
+	t.Helper()
+	files, err := s.Next(db, q, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, f := range files {
+		out = append(out, f.SrcPath)
+	}
+	return out
+}
+
+func expect(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestFairRoundRobin(t *testing.T) {
+	db := openTestDB(t)
+	var files []testFile
+	for _, d := range []string{"a", "b", "c"} {
+		for i := 1; i <= 3; i++ {
+			files = append(files, testFile{device: d, path: fmt.Sprintf("%s%d", d, i), size: 1})
+		}
+	}
+	seed(t, db, files...)
+	s := newFair(t, store.Order{})
+
+	expect(t, next(t, s, db, queued, 6), "a1", "b1", "c1", "a2", "b2", "c2")
+	// the next call carries on from each device's cursor, another device first
+	expect(t, next(t, s, db, queued, 3), "b3", "c3", "a3")
+}
+
+func TestFairPerDevice(t *testing.T) {
+	db := openTestDB(t)
+	seed(t, db,
+		testFile{device: "a", path: "a1", size: 1},
+		testFile{device: "a", path: "a2", size: 1},
+		testFile{device: "a", path: "a3", size: 1},
+		testFile{device: "b", path: "b1", size: 1},
+	)
+	s := newFair(t, store.Order{})
+
+	q := queued
+	q.PerDevice = 2
+	q.Busy = map[string]int{"b": 2}
+	expect(t, next(t, s, db, q, 4), "a1", "a2")
+}
+
+func TestFairDevicePriority(t *testing.T) {
+	db := openTestDB(t)
+	seed(t, db,
+		testFile{device: "low", path: "l1", size: 1},
+		testFile{device: "low", path: "l2", size: 1},
+		testFile{device: "high", path: "h1", size: 1},
+		testFile{device: "high", path: "h2", size: 1},
+		testFile{device: "mid", path: "m1", size: 1},
+	)
+	setPriority(t, db, "high", 10)
+	setPriority(t, db, "mid", 5)
+	s := newFair(t, store.Order{})
+
+	// a tier is drained before the next gets a turn
+	expect(t, next(t, s, db, queued, 4), "h1", "h2", "m1", "l1")
+}
+
+func TestFairClassOrder(t *testing.T) {
+	db := openTestDB(t)
+	seed(t, db,
+		testFile{device: "a", path: "notes.txt", size: 1},
+		testFile{device: "a", path: "still.JPG", size: 1},
+		testFile{device: "a", path: "take.wav", size: 1},
+		testFile{device: "a", path: "clip.mp4", size: 1},
+		testFile{device: "a", path: "clip2.mov", size: 1},
+	)
+	s := newFair(t, store.Order{Key: store.SortClass, Classes: []model.MediaClass{model.MediaAudio, model.MediaVideo, model.MediaPhoto}})
+
+	expect(t, next(t, s, db, queued, 5), "take.wav", "clip.mp4", "clip2.mov", "still.JPG", "notes.txt")
+}
+
+func TestFairSmallestFirst(t *testing.T) {
+	db := openTestDB(t)
+	seed(t, db,
+		testFile{device: "a", path: "big", size: 300},
+		testFile{device: "a", path: "small", size: 100},
+		testFile{device: "a", path: "medium", size: 200},
+		testFile{device: "a", path: "small2", size: 100},
+	)
+	s := newFair(t, store.Order{Key: store.SortSmallest})
+
+	// ties in id order
+	expect(t, next(t, s, db, queued, 4), "small", "small2", "medium", "big")
+}
+
+func TestFairOldestCaptureFirst(t *testing.T) {
+	db := openTestDB(t)
+	seed(t, db,
+		testFile{device: "a", path: "unknown", size: 1},
+		testFile{device: "a", path: "newer", size: 1, captured: "2024-05-02 10:00:00"},
+		testFile{device: "a", path: "older", size: 1, captured: "2024-05-01 10:00:00"},
+	)
+	s := newFair(t, store.Order{Key: store.SortCapture})
+
+	expect(t, next(t, s, db, queued, 3), "older", "newer", "unknown")
+}
+
+func TestFairCursorResumesAndWraps(t *testing.T) {
+	db := openTestDB(t)
+	seed(t, db,
+		testFile{device: "a", path: "s1", size: 10},
+		testFile{device: "a", path: "s2", size: 20},
+		testFile{device: "a", path: "s3", size: 30},
+	)
+	s := newFair(t, store.Order{Key: store.SortSmallest})
+
+	expect(t, next(t, s, db, queued, 2), "s1", "s2")
+	// a file that becomes runnable behind the cursor waits for the wrap
+	seed(t, db, testFile{device: "a", path: "s0", size: 5})
+	// resumes after the last key served, even if earlier files are still runnable
+	expect(t, next(t, s, db, queued, 2), "s3")
+	// past the last key it wraps around to the start
+	expect(t, next(t, s, db, queued, 2), "s0", "s1")
+	expect(t, next(t, s, db, queued, 3), "s2", "s3")
+}
+
+func TestFairSkipsBackoff(t *testing.T) {
+	db := openTestDB(t)
+	ids := seed(t, db,
+		testFile{device: "a", path: "a1", size: 1},
+		testFile{device: "a", path: "a2", size: 1},
+	)
+	if _, err := db.Exec(`UPDATE files SET next_run_at = datetime('now', '+1 hour') WHERE id = ?`, ids["a1"]); err != nil {
+		t.Fatal(err)
+	}
+	s := newFair(t, store.Order{})
+
+	expect(t, next(t, s, db, queued, 2), "a2")
+}
+
+func TestFairSkipsInFlight(t *testing.T) {
+	db := openTestDB(t)
+	ids := seed(t, db,
+		testFile{device: "a", path: "a1", size: 1},
+		testFile{device: "a", path: "a2", size: 1},
+		testFile{device: "b", path: "b1", size: 1},
+	)
+	s := newFair(t, store.Order{})
+
+	q := queued
+	q.Skip = []int64{ids["a1"]}
+	expect(t, next(t, s, db, q, 3), "a2", "b1")
+}
+
+func TestFIFO(t *testing.T) {
+	db := openTestDB(t)
+	seed(t, db,
+		testFile{device: "a", path: "a1", size: 1},
+		testFile{device: "a", path: "a2", size: 1},
+		testFile{device: "b", path: "b1", size: 1},
+	)
+
+	expect(t, next(t, FIFO{}, db, queued, 3), "a1", "a2", "b1")
+}
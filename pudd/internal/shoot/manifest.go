@@ -0,0 +1,113 @@
+package shoot
+
+import (
+	"database/sql"
+	"path"
+	"time"
+
+	"pudd/internal/model"
+	"pudd/internal/store"
+)
+
+// A manifest is what an editor needs to sync a shoot: for each device its
+// clips, where they are in the bucket and where each sits on the shoot's
+// timeline, as an offset from the shoot's start.
+
+type Manifest struct {
+	Shoot    int64            `json:"shoot"`
+	Start    string           `json:"start"` // RFC 3339, UTC
+	End      string           `json:"end"`
+	Duration float64          `json:"duration"` // seconds
+	Devices  []ManifestDevice `json:"devices"`
+}
+
+type ManifestDevice struct {
+	Device string         `json:"device"`
+	Label  string         `json:"label,omitempty"`
+	Clips  []ManifestClip `json:"clips"`
+}
+
+type ManifestClip struct {
+	FileID   int64           `json:"file_id"`
+	Name     string          `json:"name"`
+	Object   string          `json:"object,omitempty"` // gs:// URL, once uploaded
+	State    model.FileState `json:"state"`
+	Offset   float64         `json:"offset"`   // seconds after the shoot's start
+	Duration float64         `json:"duration"` // seconds; 0 for stills and files not probed
+	Start    string          `json:"start"`
+	Timecode string          `json:"timecode,omitempty"`
+	Basis    string          `json:"basis"` // what start was worked out from
+}
+
+// BuildManifest builds the manifest of a stored shoot. object names where
+// a file is in the bucket; nil leaves objects out.
+func BuildManifest(db *sql.DB, id int64, object func(model.FileRow) string) (Manifest, error) {
+	s, err := store.GetShoot(db, id)
+	if err != nil {
+		return Manifest{}, err
+	}
+	start, err := parse(s.StartedAt)
+	if err != nil {
+		return Manifest{}, err
+	}
+	end, err := parse(s.EndedAt)
+	if err != nil {
+		return Manifest{}, err
+	}
+	m := Manifest{Shoot: s.ID, Start: rfc3339(start), End: rfc3339(end), Duration: end.Sub(start).Seconds()}
+
+	// devices in the order their first clip starts
+	index := map[string]int{}
+	for _, sf := range s.Files {
+		f, err := store.GetFile(db, sf.FileID)
+		if err != nil {
+			return Manifest{}, err
+		}
+		media, _, err := store.GetMedia(db, sf.FileID)
+		if err != nil {
+			return Manifest{}, err
+		}
+		at, err := parse(sf.StartAt)
+		if err != nil {
+			return Manifest{}, err
+		}
+		until, err := parse(sf.EndAt)
+		if err != nil {
+			return Manifest{}, err
+		}
+
+		i, ok := index[f.DeviceID]
+		if !ok {
+			d := ManifestDevice{Device: f.DeviceID}
+			if dev, err := store.GetDevice(db, f.DeviceID); err == nil {
+				d.Label = dev.Label
+			}
+			i = len(m.Devices)
+			index[f.DeviceID] = i
+			m.Devices = append(m.Devices, d)
+		}
+		clip := ManifestClip{
+			FileID:   f.ID,
+			Name:     path.Base(f.SrcPath),
+			State:    f.State,
+			Offset:   at.Sub(start).Seconds(),
+			Duration: until.Sub(at).Seconds(),
+			Start:    rfc3339(at),
+			Timecode: media.Timecode,
+			Basis:    sf.Basis,
+		}
+		if object != nil {
+			clip.Object = object(f)
+		}
+		m.Devices[i].Clips = append(m.Devices[i].Clips, clip)
+	}
+	return m, nil
+}
+
+func parse(s string) (time.Time, error) {
+	return time.ParseInLocation(store.SpanLayout, s, time.UTC)
+}
+
+func rfc3339(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+}
@@ -0,0 +1,209 @@
+package shoot
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"pudd/internal/model"
+	"pudd/internal/store"
+)
+
+// Shoot grouping. Files from different devices recorded over the same
+// stretch of time are one shoot: five cameras and two recorders rolling on
+// the same takes. Where a file sits in time is worked out from its start
+// timecode when that can be trusted, as on set timecode is jammed across
+// devices, else from its corrected capture time (see model.Clock). Files
+// whose stretches overlap or follow each other within a gap run together,
+// and a run with files of two devices or more is a shoot.
+
+// timecode is only taken as time of day when it lands this close to the
+// capture time; further off it is record run, or was never set
+const tcTrust = time.Hour
+
+// Span is the stretch of time a file was recorded over
+type Span struct {
+	Recording model.Recording
+	Start     time.Time
+	End       time.Time
+	Basis     string // model.SpanTimecode or model.SpanCapture
+}
+
+// SpanOf works out the stretch r was recorded over; false if r has no
+// capture time
+func SpanOf(r model.Recording) (Span, bool) {
+	captured, err := time.ParseInLocation("2006-01-02 15:04:05", r.CapturedAt, time.UTC)
+	if err != nil {
+		return Span{}, false
+	}
+	dur := time.Duration(r.DurationMS) * time.Millisecond
+	start := captured
+	// a camera writes the file, and so sets its mtime, when it stops
+	if r.CaptureSource == model.CaptureMTime {
+		start = captured.Add(-dur)
+	}
+	s := Span{Recording: r, Start: start, End: start.Add(dur), Basis: model.SpanCapture}
+	if tc, ok := timecodeStart(r, start); ok {
+		s.Start, s.End, s.Basis = tc, tc.Add(dur), model.SpanTimecode
+	}
+	return s, true
+}
+
+// timecodeStart reads r's start timecode as the time of day where its
+// device is, on the day nearest near
+func timecodeStart(r model.Recording, near time.Time) (time.Time, bool) {
+	secs, ok := timecodeSeconds(r.Timecode, r.TimecodeRate)
+	if !ok {
+		return time.Time{}, false
+	}
+	zone, err := model.ParseZone(r.Timezone)
+	if err != nil {
+		zone = time.UTC
+	}
+	local := near.In(zone)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, zone)
+	var best time.Time
+	for day := -1; day <= 1; day++ {
+		t := midnight.AddDate(0, 0, day).Add(time.Duration(secs * float64(time.Second)))
+		if best.IsZero() || abs(t.Sub(near)) < abs(best.Sub(near)) {
+			best = t
+		}
+	}
+	if abs(best.Sub(near)) > tcTrust {
+		return time.Time{}, false
+	}
+	return best.UTC(), true
+}
+
+// timecodeSeconds is the time of day a timecode labels, counted at rate.
+// Non-drop timecode at a fractional rate (23.976, 29.97) numbers frames as
+// if there were a whole number a second, so its labels fall behind the
+// clock; drop frame timecode skips labels to keep up with it.
+func timecodeSeconds(tc string, rate float64) (float64, bool) {
+	if rate <= 0 {
+		return 0, false
+	}
+	fields := strings.FieldsFunc(tc, func(r rune) bool { return r == ':' || r == ';' || r == '.' })
+	if len(fields) != 4 {
+		return 0, false
+	}
+	var v [4]int
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		v[i] = n
+	}
+	nominal := math.Round(rate)
+	if v[0] > 23 || v[1] > 59 || v[2] > 59 || float64(v[3]) >= nominal {
+		return 0, false
+	}
+	label := float64(v[0]*3600 + v[1]*60 + v[2])
+	if strings.Contains(tc, ";") || rate == nominal {
+		return label + float64(v[3])/nominal, true
+	}
+	return (label*nominal + float64(v[3])) / rate, true
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// Group sorts spans into shoots: runs of spans that overlap or are at most
+// gap apart, kept if they hold files of two devices or more. Shoots are in
+// time order, their spans in the order they start.
+func Group(spans []Span, gap time.Duration) [][]Span {
+	sorted := append([]Span(nil), spans...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if !sorted[i].Start.Equal(sorted[j].Start) {
+			return sorted[i].Start.Before(sorted[j].Start)
+		}
+		return sorted[i].Recording.FileID < sorted[j].Recording.FileID
+	})
+
+	var out [][]Span
+	var run []Span
+	var end time.Time
+	flush := func() {
+		devices := map[string]bool{}
+		for _, s := range run {
+			devices[s.Recording.DeviceID] = true
+		}
+		if len(devices) >= 2 {
+			out = append(out, run)
+		}
+		run = nil
+	}
+	for _, s := range sorted {
+		if len(run) > 0 && s.Start.After(end.Add(gap)) {
+			flush()
+		}
+		if len(run) == 0 || s.End.After(end) {
+			end = s.End
+		}
+		run = append(run, s)
+	}
+	if len(run) > 0 {
+		flush()
+	}
+	return out
+}
+
+// Result is what regrouping found
+type Result struct {
+	Files  int           `json:"files"` // files with a capture time
+	Shoots []model.Shoot `json:"shoots"`
+}
+
+// Regroup groups every file with a capture time into shoots and stores
+// them in place of the last grouping
+func Regroup(db *sql.DB, gap time.Duration) (Result, error) {
+	recs, err := store.ListRecordings(db)
+	if err != nil {
+		return Result{}, err
+	}
+	var spans []Span
+	for _, r := range recs {
+		if s, ok := SpanOf(r); ok {
+			spans = append(spans, s)
+		}
+	}
+
+	shoots := []model.Shoot{}
+	for _, run := range Group(spans, gap) {
+		s := model.Shoot{StartedAt: format(run[0].Start)}
+		end := run[0].End
+		devices := map[string]bool{}
+		for _, sp := range run {
+			if sp.End.After(end) {
+				end = sp.End
+			}
+			devices[sp.Recording.DeviceID] = true
+			s.Files = append(s.Files, model.ShootFile{
+				FileID:   sp.Recording.FileID,
+				DeviceID: sp.Recording.DeviceID,
+				StartAt:  format(sp.Start),
+				EndAt:    format(sp.End),
+				Basis:    sp.Basis,
+			})
+		}
+		s.EndedAt = format(end)
+		s.Devices = len(devices)
+		shoots = append(shoots, s)
+	}
+	if shoots, err = store.SaveShoots(db, shoots); err != nil {
+		return Result{}, err
+	}
+	return Result{Files: len(spans), Shoots: shoots}, nil
+}
+
+func format(t time.Time) string {
+	return t.UTC().Format(store.SpanLayout)
+}
@@ -0,0 +1,206 @@
+package shoot
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"pudd/internal/model"
+)
+
+func TestTimecodeSeconds(t *testing.T) {
+	const (
+		ntsc24 = 24000.0 / 1001
+		ntsc30 = 30000.0 / 1001
+	)
+	tests := []struct {
+		tc   string
+		rate float64
+		want float64
+		ok   bool
+	}{
+		{"01:00:00:00", 25, 3600, true},
+		{"01:00:00:12", 25, 3600.48, true},
+		{"10:11:12.05", 50, 36672.1, true},
+		// 23.976 non-drop labels 24 frames a second, so an hour of labels
+		// takes 3.6s longer than an hour
+		{"01:00:00:00", ntsc24, 3603.6, true},
+		{"01:00:00:12", ntsc24, 3603.6 + 12/ntsc24, true},
+		{"01:00:00:00", ntsc30, 3603.6, true},
+		// drop frame labels keep up with the clock
+		{"01:00:00;00", ntsc30, 3600, true},
+		{"00:10:00;00", ntsc30, 600, true},
+		{"23:59:59;29", ntsc30, 86399 + 29.0/30, true},
+		{"00:00:00:25", 25, 0, false},
+		{"24:00:00:00", 25, 0, false},
+		{"00:60:00:00", 25, 0, false},
+		{"01:00:00", 25, 0, false},
+		{"01:00:00:-1", 25, 0, false},
+		{"aa:00:00:00", 25, 0, false},
+		{"01:00:00:00", 0, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := timecodeSeconds(tt.tc, tt.rate)
+		if ok != tt.ok || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("timecodeSeconds(%q, %.3f) = %v, %v, want %v, %v", tt.tc, tt.rate, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTimecodeStart(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse(time.DateTime, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		name string
+		tc   string
+		zone string
+		near string
+		want string // empty if the timecode isn't trusted
+	}{
+		{"same day", "10:00:00:00", "", "2024-05-01 10:00:05", "2024-05-01 10:00:00"},
+		{"device zone", "12:00:00:00", "+02:00", "2024-05-01 10:00:05", "2024-05-01 10:00:00"},
+		{"iana zone", "12:00:00:00", "Europe/Berlin", "2024-05-01 10:00:05", "2024-05-01 10:00:00"},
+		{"bad zone is utc", "10:00:00:00", "Mars/Olympus", "2024-05-01 10:00:05", "2024-05-01 10:00:00"},
+		// timecode wraps at midnight, the capture time doesn't
+		{"after midnight", "00:00:10:00", "", "2024-05-01 23:59:30", "2024-05-02 00:00:10"},
+		{"before midnight", "23:59:50:00", "", "2024-05-02 00:00:30", "2024-05-01 23:59:50"},
+		{"local midnight", "22:00:01:00", "-05:00", "2024-05-02 03:00:00", "2024-05-02 03:00:01"},
+		// an hour out is a clock on the wrong DST, further is no time of day
+		{"an hour off", "11:00:00:00", "", "2024-05-01 10:00:00", "2024-05-01 11:00:00"},
+		{"over an hour off", "11:00:01:00", "", "2024-05-01 10:00:00", ""},
+		{"record run", "00:03:12:00", "", "2024-05-01 10:00:00", ""},
+		{"no timecode", "", "", "2024-05-01 10:00:00", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := model.Recording{Timecode: tt.tc, TimecodeRate: 25, Timezone: tt.zone}
+			got, ok := timecodeStart(r, at(tt.near))
+			if tt.want == "" {
+				if ok {
+					t.Errorf("trusted %s", got)
+				}
+				return
+			}
+			if !ok || !got.Equal(at(tt.want)) || got.Location() != time.UTC {
+				t.Errorf("got %s %v, want %s", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpanOf(t *testing.T) {
+	tests := []struct {
+		name       string
+		r          model.Recording
+		start, end string
+		basis      string
+	}{
+		{"metadata", model.Recording{CapturedAt: "2024-05-01 10:00:00", CaptureSource: model.CaptureMetadata, DurationMS: 60000},
+			"10:00:00", "10:01:00", model.SpanCapture},
+		// the mtime is when the camera stopped
+		{"mtime", model.Recording{CapturedAt: "2024-05-01 10:01:00", CaptureSource: model.CaptureMTime, DurationMS: 60000},
+			"10:00:00", "10:01:00", model.SpanCapture},
+		{"timecode", model.Recording{CapturedAt: "2024-05-01 10:00:07", CaptureSource: model.CaptureMetadata, DurationMS: 60000, Timecode: "10:00:02:00", TimecodeRate: 25},
+			"10:00:02", "10:01:02", model.SpanTimecode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ok := SpanOf(tt.r)
+			if !ok {
+				t.Fatal("no span")
+			}
+			if got := s.Start.Format(time.TimeOnly); got != tt.start {
+				t.Errorf("start %s, want %s", got, tt.start)
+			}
+			if got := s.End.Format(time.TimeOnly); got != tt.end {
+				t.Errorf("end %s, want %s", got, tt.end)
+			}
+			if s.Basis != tt.basis {
+				t.Errorf("basis %s, want %s", s.Basis, tt.basis)
+			}
+		})
+	}
+	if _, ok := SpanOf(model.Recording{}); ok {
+		t.Error("span without a capture time")
+	}
+}
+
+func TestGroup(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	span := func(id int64, device, from, to string) Span {
+		clock := func(s string) time.Time {
+			v, err := time.Parse(time.TimeOnly, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return day.Add(v.Sub(time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)))
+		}
+		return Span{Recording: model.Recording{FileID: id, DeviceID: device}, Start: clock(from), End: clock(to)}
+	}
+	const gap = 2 * time.Minute
+
+	tests := []struct {
+		name  string
+		spans []Span
+		want  [][]int64
+	}{
+		{"one device", []Span{
+			span(1, "a", "10:00:00", "10:05:00"),
+			span(2, "a", "10:04:00", "10:08:00"),
+		}, nil},
+		{"two devices", []Span{
+			span(2, "b", "10:01:00", "10:04:00"),
+			span(1, "a", "10:00:00", "10:05:00"),
+		}, [][]int64{{1, 2}}},
+		// each start is within gap of the run so far, not of the first
+		{"chained by gaps", []Span{
+			span(1, "a", "10:00:00", "10:05:00"),
+			span(2, "b", "10:06:30", "10:10:00"),
+			span(3, "a", "10:12:00", "10:15:00"),
+			span(4, "c", "10:16:59", "10:20:00"),
+		}, [][]int64{{1, 2, 3, 4}}},
+		// a short clip inside a long one doesn't end the run early
+		{"contained", []Span{
+			span(1, "a", "10:00:00", "11:00:00"),
+			span(2, "b", "10:10:00", "10:11:00"),
+			span(3, "b", "11:01:00", "11:02:00"),
+		}, [][]int64{{1, 2, 3}}},
+		// a break longer than gap ends a shoot; the lone device between
+		// the two shoots is none
+		{"breaks", []Span{
+			span(1, "a", "09:00:00", "09:10:00"),
+			span(2, "b", "09:05:00", "09:12:00"),
+			span(3, "a", "09:30:00", "09:40:00"),
+			span(4, "a", "09:41:00", "09:50:00"),
+			span(5, "b", "10:00:00", "10:10:00"),
+			span(6, "c", "10:12:00", "10:20:00"),
+		}, [][]int64{{1, 2}, {5, 6}}},
+		{"same start", []Span{
+			span(7, "b", "10:00:00", "10:05:00"),
+			span(3, "a", "10:00:00", "10:05:00"),
+		}, [][]int64{{3, 7}}},
+		{"none", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]int64
+			for _, run := range Group(tt.spans, gap) {
+				var ids []int64
+				for _, s := range run {
+					ids = append(ids, s.Recording.FileID)
+				}
+				got = append(got, ids)
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,385 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"pudd/internal/model"
+)
+
+// Queries and updates used by the operator CLI. Reads are single statements
+// and updates are transactions guarded on state and lease, so everything here
+// is safe to run against the db while the daemon is running.
+
+type StateCount struct {
+	State model.FileState `json:"state"`
+	Files int64           `json:"files"`
+	Bytes int64           `json:"bytes"`
+}
+
+type DeviceCount struct {
+	DeviceID string `json:"device_id"`
+	Files    int64  `json:"files"`
+	Bytes    int64  `json:"bytes"`
+	Done     int64  `json:"done"`
+	Errors   int64  `json:"errors"`
+	Failed   int64  `json:"failed"`
+	Copying  int64  `json:"copying"` // copies running now
+}
+
+type Status struct {
+	States     []StateCount  `json:"states"`
+	Devices    []DeviceCount `json:"devices"`
+	BackingOff int64         `json:"backing_off"` // rows waiting on next_run_at
+	Failed     int64         `json:"failed"`      // dead letters
+	// rows a worker holds a live lease on, by stage
+	Busy map[model.Stage]int64 `json:"busy"`
+}
+
+func FetchStatus(db *sql.DB) (Status, error) {
+	var st Status
+	var err error
+
+	st.States, err = CountStates(db)
+	if err != nil {
+		return st, err
+	}
+
+	rows, err := db.Query(`
+SELECT device_id, COUNT(*), COALESCE(SUM(size), 0),
+       SUM(CASE WHEN state = ? THEN 1 ELSE 0 END),
+       SUM(CASE WHEN last_error <> '' THEN 1 ELSE 0 END),
+       SUM(CASE WHEN state = ? THEN 1 ELSE 0 END),
+       SUM(CASE WHEN state = ? AND claim_until >= CURRENT_TIMESTAMP THEN 1 ELSE 0 END)
+FROM files
+GROUP BY device_id
+ORDER BY device_id
+`, string(model.StateDone), string(model.StateFailed), string(model.StateCopying))
+	if err != nil {
+		return st, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c DeviceCount
+		if err := rows.Scan(&c.DeviceID, &c.Files, &c.Bytes, &c.Done, &c.Errors, &c.Failed, &c.Copying); err != nil {
+			return st, err
+		}
+		st.Devices = append(st.Devices, c)
+	}
+	if err := rows.Err(); err != nil {
+		return st, err
+	}
+
+	for _, sc := range st.States {
+		if sc.State == model.StateFailed {
+			st.Failed = sc.Files
+		}
+	}
+
+	if st.BackingOff, err = CountBackingOff(db); err != nil {
+		return st, err
+	}
+	st.Busy, err = CountBusy(db)
+	return st, err
+}
+
+// CountBusy returns the number of rows held under a live lease, by stage
+func CountBusy(db *sql.DB) (map[model.Stage]int64, error) {
+	rows, err := db.Query(`
+SELECT state, COUNT(*)
+FROM files
+WHERE claim_until >= CURRENT_TIMESTAMP
+GROUP BY state
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[model.Stage]int64{}
+	for rows.Next() {
+		var state model.FileState
+		var n int64
+		if err := rows.Scan(&state, &n); err != nil {
+			return nil, err
+		}
+		if state.InFlight() {
+			out[state.Stage()] += n
+		}
+	}
+	return out, rows.Err()
+}
+
+// CountStates returns file and byte counts for every state that has rows
+func CountStates(db *sql.DB) ([]StateCount, error) {
+	rows, err := db.Query(`
+SELECT state, COUNT(*), COALESCE(SUM(size), 0)
+FROM files
+GROUP BY state
+ORDER BY state
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []StateCount
+	for rows.Next() {
+		var c StateCount
+		var stateStr string
+		if err := rows.Scan(&stateStr, &c.Files, &c.Bytes); err != nil {
+			return nil, err
+		}
+		c.State = model.FileState(stateStr)
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// CountBackingOff returns the number of rows waiting on next_run_at
+func CountBackingOff(db *sql.DB) (int64, error) {
+	var n int64
+	err := db.QueryRow(`
+SELECT COUNT(*) FROM files WHERE next_run_at IS NOT NULL AND next_run_at > CURRENT_TIMESTAMP
+`).Scan(&n)
+	return n, err
+}
+
+type ListFilter struct {
+	DeviceID string
+	State    model.FileState
+	// files captured in [CapturedFrom, CapturedTo); zero leaves that end
+	// open. Either set orders by capture time and leaves out files with none.
+	CapturedFrom time.Time
+	CapturedTo   time.Time
+	Limit        int
+}
+
+func ListFiles(db *sql.DB, filter ListFilter) ([]model.FileRow, error) {
+	var where []string
+	var args []any
+	if filter.DeviceID != "" {
+		where = append(where, "device_id = ?")
+		args = append(args, filter.DeviceID)
+	}
+	if filter.State != "" {
+		where = append(where, "state = ?")
+		args = append(args, string(filter.State))
+	}
+	if !filter.CapturedFrom.IsZero() {
+		where = append(where, "captured_at >= ?")
+		args = append(args, formatTime(filter.CapturedFrom))
+	}
+	if !filter.CapturedTo.IsZero() {
+		where = append(where, "captured_at < ?")
+		args = append(args, formatTime(filter.CapturedTo))
+	}
+
+	q := `SELECT ` + fileColumns + ` FROM files`
+	if len(where) > 0 {
+		q += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	if filter.CapturedFrom.IsZero() && filter.CapturedTo.IsZero() {
+		q += ` ORDER BY id`
+	} else {
+		q += ` ORDER BY captured_at, id`
+	}
+	if filter.Limit > 0 {
+		q += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanFiles(rows)
+}
+
+func GetFile(db *sql.DB, fileID int64) (model.FileRow, error) {
+	f, err := scanFile(db.QueryRow(`SELECT `+fileColumns+` FROM files WHERE id = ?`, fileID))
+	if err == sql.ErrNoRows {
+		return f, fmt.Errorf("file %d not found", fileID)
+	}
+	return f, err
+}
+
+func FindBySHA256(db *sql.DB, sha256 string) ([]model.FileRow, error) {
+	rows, err := db.Query(`SELECT `+fileColumns+` FROM files WHERE sha256 = ? ORDER BY id`, sha256)
+	if err != nil {
+		return nil, err
+	}
+	return scanFiles(rows)
+}
+
+// notLeased matches rows no worker holds a live lease on; rows that hold
+// one belong to a worker and are left alone
+func notLeased() string {
+	return `(state NOT IN (` + stateList(model.ClaimStates()) + `) OR claim_until IS NULL OR claim_until < CURRENT_TIMESTAMP)`
+}
+
+// stateList is states as SQL string literals; state names are plain words
+func stateList(states []model.FileState) string {
+	quoted := make([]string, len(states))
+	for i, s := range states {
+		quoted[i] = "'" + string(s) + "'"
+	}
+	return strings.Join(quoted, ",")
+}
+
+// journaled as the worker for changes made through the CLI
+const operator = "operator"
+
+// Retry clears the backoff on a file so the pipeline picks it up on the next tick.
+// A file parked in ERROR goes back to QUEUED. FAILED files need Requeue.
+func Retry(db *sql.DB, fileID int64) (bool, error) {
+	var ok bool
+	err := withTx(db, func(tx *sql.Tx) error {
+		var err error
+		ok, err = retry(tx, fileID)
+		return err
+	})
+	return ok, err
+}
+
+// RetryAllErrors does what Retry does for every file that has a last_error
+func RetryAllErrors(db *sql.DB) (int64, error) {
+	var n int64
+	err := withTx(db, func(tx *sql.Tx) error {
+		ids, err := queryIDs(tx, `SELECT id FROM files WHERE last_error <> '' ORDER BY id`)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			ok, err := retry(tx, id)
+			if err != nil {
+				return err
+			}
+			if ok {
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+func retry(tx *sql.Tx, fileID int64) (bool, error) {
+	var stateStr string
+	err := tx.QueryRow(`SELECT state FROM files WHERE id = ?`, fileID).Scan(&stateStr)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	from := model.FileState(stateStr)
+	to := from
+	if from == model.StateError {
+		to = model.StateQueued
+	}
+	return change{
+		fileID: fileID,
+		to:     to,
+		// FAILED files need Requeue
+		where:  `state = ? AND state NOT IN (` + stateList(slices.Concat(model.FinalStates, []model.FileState{model.StateFailed})) + `) AND ` + notLeased(),
+		args:   []any{string(from)},
+		set:    `attempts = 0, last_error = '', error_class = '', next_run_at = NULL, `,
+		worker: operator,
+	}.apply(tx)
+}
+
+// Skip parks a file in SKIPPED so the pipeline never touches it again
+func Skip(db *sql.DB, fileID int64) (bool, error) {
+	return applyChange(db, change{
+		fileID: fileID,
+		to:     model.StateSkipped,
+		where:  `state NOT IN (` + stateList(model.FinalStates) + `) AND ` + notLeased(),
+		set:    `claimed_by = '', claim_until = NULL, next_run_at = NULL, `,
+		worker: operator,
+	})
+}
+
+// Requeue sends a FAILED file back to the start of the stage it failed in,
+// with a fresh attempt budget
+func Requeue(db *sql.DB, fileID int64) (bool, error) {
+	var ok bool
+	err := withTx(db, func(tx *sql.Tx) error {
+		var err error
+		ok, err = requeue(tx, fileID)
+		return err
+	})
+	return ok, err
+}
+
+// RequeueFailed requeues every FAILED file, or only those from deviceID if set
+func RequeueFailed(db *sql.DB, deviceID string) (int64, error) {
+	var n int64
+	err := withTx(db, func(tx *sql.Tx) error {
+		ids, err := queryIDs(tx, `
+SELECT id FROM files WHERE state = ? AND (? = '' OR device_id = ?) ORDER BY id
+`, string(model.StateFailed), deviceID, deviceID)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			ok, err := requeue(tx, id)
+			if err != nil {
+				return err
+			}
+			if ok {
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+func requeue(tx *sql.Tx, fileID int64) (bool, error) {
+	var failedFrom string
+	err := tx.QueryRow(`SELECT failed_from FROM files WHERE id = ? AND state = ?`, fileID, string(model.StateFailed)).Scan(&failedFrom)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	to := model.FileState(failedFrom).Stage().RetryState()
+	if to == "" {
+		// shouldn't happen, but copying again is always safe
+		to = model.StateDiscovered
+	}
+	return change{
+		fileID: fileID,
+		to:     to,
+		where:  `state = ?`,
+		args:   []any{string(model.StateFailed)},
+		set:    `attempts = 0, last_error = '', error_class = '', failed_from = '', next_run_at = NULL, `,
+		worker: operator,
+	}.apply(tx)
+}
+
+func queryIDs(tx *sql.Tx, q string, args ...any) ([]int64, error) {
+	rows, err := tx.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"database/sql"
+
+	"pudd/internal/model"
+)
+
+// SetAudio records what the probe stage read from a recorder's WAV,
+// replacing what an earlier probe found
+func SetAudio(a model.Audio) Update {
+	return func(tx *sql.Tx, fileID int64) error {
+		_, err := tx.Exec(`
+INSERT INTO audio (file_id, sample_rate, channels, bit_depth, time_reference, timecode_rate, originator, description,
+                   project, scene, take, tape, note, circled)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(file_id) DO UPDATE SET
+  sample_rate    = excluded.sample_rate,
+  channels       = excluded.channels,
+  bit_depth      = excluded.bit_depth,
+  time_reference = excluded.time_reference,
+  timecode_rate  = excluded.timecode_rate,
+  originator     = excluded.originator,
+  description    = excluded.description,
+  project        = excluded.project,
+  scene          = excluded.scene,
+  take           = excluded.take,
+  tape           = excluded.tape,
+  note           = excluded.note,
+  circled        = excluded.circled
+`, fileID, a.SampleRate, a.Channels, a.BitDepth, a.TimeReference, a.TimecodeRate, a.Originator, a.Description,
+			a.Project, a.Scene, a.Take, a.Tape, a.Note, a.Circled)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM audio_tracks WHERE file_id = ?`, fileID); err != nil {
+			return err
+		}
+		for _, t := range a.Tracks {
+			_, err := tx.Exec(`
+INSERT OR IGNORE INTO audio_tracks (file_id, channel, name, function) VALUES (?, ?, ?, ?)
+`, fileID, t.Channel, t.Name, t.Function)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// GetAudio returns what was read from a recorder's WAV; false if nothing was
+func GetAudio(db *sql.DB, fileID int64) (model.Audio, bool, error) {
+	a := model.Audio{FileID: fileID}
+	var ref sql.NullInt64
+	err := db.QueryRow(`
+SELECT sample_rate, channels, bit_depth, time_reference, timecode_rate, originator, description,
+       project, scene, take, tape, note, circled
+FROM audio WHERE file_id = ?
+`, fileID).Scan(&a.SampleRate, &a.Channels, &a.BitDepth, &ref, &a.TimecodeRate, &a.Originator, &a.Description,
+		&a.Project, &a.Scene, &a.Take, &a.Tape, &a.Note, &a.Circled)
+	if err == sql.ErrNoRows {
+		return model.Audio{}, false, nil
+	}
+	if err != nil {
+		return model.Audio{}, false, err
+	}
+	if ref.Valid {
+		a.TimeReference = &ref.Int64
+	}
+
+	rows, err := db.Query(`
+SELECT channel, name, function FROM audio_tracks WHERE file_id = ? ORDER BY channel
+`, fileID)
+	if err != nil {
+		return model.Audio{}, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t model.AudioTrack
+		if err := rows.Scan(&t.Channel, &t.Name, &t.Function); err != nil {
+			return model.Audio{}, false, err
+		}
+		a.Tracks = append(a.Tracks, t)
+	}
+	return a, true, rows.Err()
+}
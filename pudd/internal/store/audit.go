@@ -0,0 +1,102 @@
+package store
+
+import (
+	"database/sql"
+
+	"pudd/internal/model"
+)
+
+// journaled as the worker for changes the auditor makes
+const auditor = "audit"
+
+// SetObject records the object name a file was uploaded under
+func SetObject(name string) Update {
+	return func(tx *sql.Tx, fileID int64) error {
+		_, err := tx.Exec(`UPDATE files SET object = ? WHERE id = ?`, name, fileID)
+		return err
+	}
+}
+
+// AuditSample picks up to n DONE files to audit: those never audited first,
+// then those audited longest ago, at random among equals
+func AuditSample(db *sql.DB, n int) ([]model.FileRow, error) {
+	rows, err := db.Query(`
+SELECT `+fileColumns+`
+FROM files
+WHERE state = ?
+ORDER BY audited_at IS NOT NULL, audited_at, RANDOM()
+LIMIT ?
+`, string(model.StateDone), n)
+	if err != nil {
+		return nil, err
+	}
+	return scanFiles(rows)
+}
+
+// AuditPassed records that a file's object checked out, and the name it was
+// found under for files uploaded before object names were kept
+func AuditPassed(db *sql.DB, fileID int64, object string) error {
+	_, err := db.Exec(`UPDATE files SET audited_at = CURRENT_TIMESTAMP, object = ? WHERE id = ?`, object, fileID)
+	return err
+}
+
+// AuditUnresolved records a problem with a DONE file's object that
+// uploading it again wouldn't settle, and leaves the file DONE
+func AuditUnresolved(db *sql.DB, a model.Audit) error {
+	return withTx(db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`UPDATE files SET audited_at = CURRENT_TIMESTAMP WHERE id = ?`, a.FileID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO audits (file_id, object, problem, detail) VALUES (?, ?, ?, ?)`,
+			a.FileID, a.Object, a.Problem, a.Detail)
+		return err
+	})
+}
+
+// AuditFailed records what was wrong with a DONE file's object and sends
+// the file to `to` to be uploaded again, with a fresh attempt budget: the
+// upload stage's Input if its staged copy is intact, else the copy stage's.
+// It reports false if the file was no longer DONE.
+func AuditFailed(db *sql.DB, a model.Audit, to model.FileState) (bool, error) {
+	var ok bool
+	err := withTx(db, func(tx *sql.Tx) error {
+		var err error
+		ok, err = change{
+			fileID:  a.FileID,
+			to:      to,
+			where:   `state = ?`,
+			args:    []any{string(model.StateDone)},
+			set:     `audited_at = CURRENT_TIMESTAMP, attempts = 0, last_error = ?, error_class = '', next_run_at = NULL, `,
+			setArgs: []any{"audit: object " + a.Problem},
+			worker:  auditor,
+			errMsg:  a.Problem + ": " + a.Detail,
+		}.apply(tx)
+		if err != nil || !ok {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO audits (file_id, object, problem, detail) VALUES (?, ?, ?, ?)`,
+			a.FileID, a.Object, a.Problem, a.Detail)
+		return err
+	})
+	return ok, err
+}
+
+// ListAudits returns the problems audits found, newest first
+func ListAudits(db *sql.DB, limit int) ([]model.Audit, error) {
+	rows, err := db.Query(`
+SELECT id, file_id, object, problem, detail, found_at FROM audits ORDER BY id DESC LIMIT ?
+`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []model.Audit
+	for rows.Next() {
+		var a model.Audit
+		if err := rows.Scan(&a.ID, &a.FileID, &a.Object, &a.Problem, &a.Detail, &a.FoundAt); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"pudd/internal/model"
+)
+
+// files.captured_at is derived: from the file's probed metadata or its mtime,
+// corrected with its device's clock (see model.Clock.CaptureTime). It is
+// worked out again whenever one of those changes.
+
+// recapture works out a file's capture time from what the db holds
+func recapture(tx *sql.Tx, fileID int64) error {
+	created, mtime, clock, err := captureInputs(tx, fileID)
+	if err != nil {
+		return err
+	}
+	t, source := clock.CaptureTime(created, mtime)
+	var captured any
+	if !t.IsZero() {
+		captured = formatTime(t)
+	}
+	_, err = tx.Exec(`UPDATE files SET captured_at = ?, capture_source = ? WHERE id = ?`, captured, source, fileID)
+	return err
+}
+
+// captureInputs reads what a file's capture time is worked out from
+func captureInputs(q querier, fileID int64) (string, time.Time, model.Clock, error) {
+	var created, mtime string
+	var d model.Device
+	err := q.QueryRow(`
+SELECT COALESCE(m.created_at, ''), COALESCE(f.mtime, ''), COALESCE(d.timezone, ''), COALESCE(d.clock_skew, 0)
+FROM files f
+LEFT JOIN media m ON m.file_id = f.id
+LEFT JOIN devices d ON d.device_id = f.device_id
+WHERE f.id = ?
+`, fileID).Scan(&created, &mtime, &d.Timezone, &d.ClockSkew)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, model.Clock{}, fmt.Errorf("file %d not found", fileID)
+	}
+	if err != nil {
+		return "", time.Time{}, model.Clock{}, err
+	}
+	var mt time.Time
+	if mtime != "" {
+		if mt, err = time.ParseInLocation(timeLayout, mtime, time.UTC); err != nil {
+			return "", time.Time{}, model.Clock{}, fmt.Errorf("file %d: mtime: %w", fileID, err)
+		}
+	}
+	return created, mt, d.Clock(), nil
+}
+
+// recaptureDevice works out the capture time of all of a device's files
+func recaptureDevice(tx *sql.Tx, deviceID string) error {
+	rows, err := tx.Query(`SELECT id FROM files WHERE device_id = ?`, deviceID)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := recapture(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordedAt is when a file was recorded by its device's clock: its capture
+// time with the device's timezone applied but not its skew. Set against the
+// true time of the same moment it gives the skew, see `pudd devices
+// calibrate`.
+func RecordedAt(db *sql.DB, fileID int64) (time.Time, string, error) {
+	created, mtime, clock, err := captureInputs(db, fileID)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	clock.Skew = 0
+	t, source := clock.CaptureTime(created, mtime)
+	if t.IsZero() {
+		return t, "", fmt.Errorf("file %d: no capture time, neither probed nor from an mtime", fileID)
+	}
+	return t, source, nil
+}
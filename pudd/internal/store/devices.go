@@ -0,0 +1,167 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"pudd/internal/model"
+)
+
+// The devices table outlives the daemon: add/remove handling keeps present,
+// devnode and mountpoint current, and Transition counts files into
+// files/bytes_ingested as they are copied off.
+
+const deviceColumns = `device_id, id_source, label, owner, first_seen, last_seen, devnode, mountpoint, present,
+       files_ingested, bytes_ingested, quota_bytes, priority, timezone, clock_skew`
+
+// DeviceAdded records a plug-in of d. Label and owner only overwrite the
+// stored values when set, so a nickname given with `pudd devices set` sticks
+// unless the card's .pudd file names one.
+func DeviceAdded(db *sql.DB, d model.Device) error {
+	_, err := db.Exec(`
+INSERT INTO devices (device_id, id_source, label, owner, devnode, mountpoint, present)
+VALUES (?, ?, ?, ?, ?, ?, 1)
+ON CONFLICT(device_id) DO UPDATE SET
+  id_source  = excluded.id_source,
+  label      = CASE WHEN excluded.label <> '' THEN excluded.label ELSE label END,
+  owner      = CASE WHEN excluded.owner <> '' THEN excluded.owner ELSE owner END,
+  devnode    = excluded.devnode,
+  mountpoint = excluded.mountpoint,
+  present    = 1,
+  last_seen  = CURRENT_TIMESTAMP
+`, d.DeviceID, d.IDSource, d.Label, d.Owner, d.DevNode, d.MountPoint)
+	return err
+}
+
+// DeviceRemoved marks a device absent
+func DeviceRemoved(db *sql.DB, deviceID string) error {
+	_, err := db.Exec(`
+UPDATE devices
+SET present = 0, devnode = '', mountpoint = '', last_seen = CURRENT_TIMESTAMP
+WHERE device_id = ?
+`, deviceID)
+	return err
+}
+
+// SetDeviceInfo sets the operator-managed fields of a known device: label,
+// owner, staging quota, scheduling priority, and its clock's timezone and
+// skew. A changed clock corrects the capture time of the device's files.
+func SetDeviceInfo(db *sql.DB, d model.Device) (bool, error) {
+	if _, err := model.ParseZone(d.Timezone); err != nil {
+		return false, fmt.Errorf("device %s: timezone: %w", d.DeviceID, err)
+	}
+	found := false
+	err := withTx(db, func(tx *sql.Tx) error {
+		var tz string
+		var skew int64
+		err := tx.QueryRow(`SELECT timezone, clock_skew FROM devices WHERE device_id = ?`, d.DeviceID).Scan(&tz, &skew)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		_, err = tx.Exec(`
+UPDATE devices SET label = ?, owner = ?, quota_bytes = ?, priority = ?, timezone = ?, clock_skew = ?
+WHERE device_id = ?
+`, d.Label, d.Owner, d.QuotaBytes, d.Priority, d.Timezone, d.ClockSkew, d.DeviceID)
+		if err != nil || (tz == d.Timezone && skew == d.ClockSkew) {
+			return err
+		}
+		return recaptureDevice(tx, d.DeviceID)
+	})
+	return found, err
+}
+
+func ListDevices(db *sql.DB) ([]model.Device, error) {
+	rows, err := db.Query(`SELECT ` + deviceColumns + ` FROM devices ORDER BY device_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.Device
+	for rows.Next() {
+		d, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func GetDevice(db *sql.DB, deviceID string) (model.Device, error) {
+	d, err := scanDevice(db.QueryRow(`SELECT `+deviceColumns+` FROM devices WHERE device_id = ?`, deviceID))
+	if err == sql.ErrNoRows {
+		return d, fmt.Errorf("device %s not found", deviceID)
+	}
+	return d, err
+}
+
+// DeviceQuota returns the staging quota set for a device, 0 if none
+func DeviceQuota(db *sql.DB, deviceID string) (int64, error) {
+	var q int64
+	err := db.QueryRow(`SELECT quota_bytes FROM devices WHERE device_id = ?`, deviceID).Scan(&q)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return q, err
+}
+
+// StagedBytes is what a device's files take up in staging: everything past
+// DISCOVERED that isn't DONE, and FAILED files that got past copying
+func StagedBytes(db *sql.DB, deviceID string) (int64, error) {
+	args := []any{deviceID}
+	for _, s := range model.StagingStates {
+		args = append(args, string(s))
+	}
+	copying := model.Stages()[0]
+	args = append(args, string(model.StateFailed), string(copying.Input), string(copying.Claim))
+	var n int64
+	err := db.QueryRow(`
+SELECT COALESCE(SUM(size), 0)
+FROM files
+WHERE device_id = ?
+  AND (state IN (`+placeholders(len(model.StagingStates))+`)
+       OR (state = ? AND failed_from NOT IN ('', ?, ?)))
+`, args...).Scan(&n)
+	return n, err
+}
+
+// PendingBytes is what is still to be copied off a device
+func PendingBytes(db *sql.DB, deviceID string) (files, bytes int64, err error) {
+	err = db.QueryRow(`
+SELECT COUNT(*), COALESCE(SUM(size), 0)
+FROM files
+WHERE device_id = ? AND state = ?
+`, deviceID, string(model.StateDiscovered)).Scan(&files, &bytes)
+	return files, bytes, err
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// countIngested adds a file that just reached COPIED to its device's totals
+func countIngested(tx *sql.Tx, fileID int64) error {
+	_, err := tx.Exec(`
+UPDATE devices
+SET files_ingested = files_ingested + 1,
+    bytes_ingested = bytes_ingested + (SELECT size FROM files WHERE id = ?)
+WHERE device_id = (SELECT device_id FROM files WHERE id = ?)
+`, fileID, fileID)
+	return err
+}
+
+func scanDevice(r rowScanner) (model.Device, error) {
+	var d model.Device
+	err := r.Scan(
+		&d.DeviceID, &d.IDSource, &d.Label, &d.Owner, &d.FirstSeen, &d.LastSeen,
+		&d.DevNode, &d.MountPoint, &d.Present, &d.FilesIngested, &d.BytesIngested, &d.QuotaBytes, &d.Priority,
+		&d.Timezone, &d.ClockSkew,
+	)
+	return d, err
+}
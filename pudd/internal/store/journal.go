@@ -0,0 +1,187 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"pudd/internal/model"
+)
+
+// Every state change of a files row goes through change.apply, which checks
+// it against model.CanTransition and writes a file_events row in the same
+// transaction as the update. The journal is append-only; nothing in pudd
+// updates or deletes from it.
+
+// ErrIllegalTransition means a state change isn't in the model's transition graph
+var ErrIllegalTransition = errors.New("illegal state transition")
+
+// change is one journaled state change of a single files row
+type change struct {
+	fileID int64
+	to     model.FileState
+
+	// guard on the current row, e.g. "state = ?"; the change is a no-op if it doesn't match
+	where string
+	args  []any
+
+	// extra assignments ending in ", ", e.g. "claimed_by = ?, "
+	set     string
+	setArgs []any
+
+	worker string // who made the change; empty means the row's claimed_by
+	errMsg string
+}
+
+// apply reports false if the guard didn't match, and fails with
+// ErrIllegalTransition if it did but the change isn't legal
+func (c change) apply(tx *sql.Tx) (bool, error) {
+	var from model.FileState
+	err := tx.QueryRow(`SELECT state FROM files WHERE id = ? AND (`+c.where+`)`,
+		append([]any{c.fileID}, c.args...)...).Scan(&from)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !model.CanTransition(from, c.to) {
+		return false, fmt.Errorf("file=%d %s -> %s: %w", c.fileID, from, c.to, ErrIllegalTransition)
+	}
+
+	// journal first: from_state has to be read before the update overwrites it
+	args := append([]any{string(c.to), c.worker, c.worker, c.errMsg, c.fileID}, c.args...)
+	res, err := tx.Exec(`
+INSERT INTO file_events (file_id, from_state, to_state, worker, error, bytes)
+SELECT id, state, ?, CASE WHEN ? <> '' THEN ? ELSE claimed_by END, ?, size
+FROM files
+WHERE id = ? AND (`+c.where+`)`, args...)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return false, nil
+	}
+
+	args = append([]any{string(c.to)}, c.setArgs...)
+	args = append(args, c.fileID)
+	args = append(args, c.args...)
+	res, err = tx.Exec(`
+UPDATE files
+SET state = ?, `+c.set+`updated_at = CURRENT_TIMESTAMP
+WHERE id = ? AND (`+c.where+`)`, args...)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// applyChange runs a single change in its own transaction
+func applyChange(db *sql.DB, c change) (bool, error) {
+	var ok bool
+	err := withTx(db, func(tx *sql.Tx) error {
+		var err error
+		ok, err = c.apply(tx)
+		return err
+	})
+	return ok, err
+}
+
+// withTx commits if fn returns nil and rolls back otherwise.
+// Open sets _txlock=immediate so the write lock is taken at BEGIN.
+func withTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback: %v)", err, rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// FileHistory returns the journal for a file, oldest first
+func FileHistory(db *sql.DB, fileID int64) ([]model.FileEvent, error) {
+	rows, err := db.Query(`
+SELECT id, file_id, from_state, to_state, worker, error, bytes, at
+FROM file_events
+WHERE file_id = ?
+ORDER BY id
+`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.FileEvent
+	for rows.Next() {
+		var ev model.FileEvent
+		var from, to string
+		if err := rows.Scan(&ev.ID, &ev.FileID, &from, &to, &ev.Worker, &ev.Error, &ev.Bytes, &ev.At); err != nil {
+			return nil, err
+		}
+		ev.From, ev.To = model.FileState(from), model.FileState(to)
+		out = append(out, ev)
+	}
+	return out, rows.Err()
+}
+
+// CorruptSince returns the files found corrupt that workers touched since
+// since, for the session summary
+func CorruptSince(db *sql.DB, since time.Time, workers []string) ([]model.FileRow, error) {
+	if len(workers) == 0 {
+		return nil, nil
+	}
+	args := []any{formatTime(since)}
+	for _, w := range workers {
+		args = append(args, w)
+	}
+	rows, err := db.Query(`
+SELECT `+fileColumns+`
+FROM files
+WHERE corrupt <> '' AND id IN (
+  SELECT file_id FROM file_events WHERE at >= ? AND worker IN (`+placeholders(len(workers))+`)
+)
+ORDER BY id
+`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanFiles(rows)
+}
+
+// CountEvents counts the journal entries written by workers since a point in
+// time, by the state they moved files to
+func CountEvents(db *sql.DB, since time.Time, workers []string) (map[model.FileState]int64, error) {
+	out := map[model.FileState]int64{}
+	for _, w := range workers {
+		rows, err := db.Query(`
+SELECT to_state, COUNT(*)
+FROM file_events
+WHERE worker = ? AND at >= ?
+GROUP BY to_state
+`, w, formatTime(since))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var state string
+			var n int64
+			if err := rows.Scan(&state, &n); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			out[model.FileState(state)] += n
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
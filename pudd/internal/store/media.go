@@ -0,0 +1,81 @@
+package store
+
+import (
+	"database/sql"
+
+	"pudd/internal/model"
+)
+
+// The media table holds what the probe stage read out of a file's container.
+// It is written with the stage's transitions, see SetMedia.
+
+const mediaColumns = `file_id, format, created_at, duration_ms, width, height, frame_rate, codec, make, model,
+       timecode, serial, lens, orientation, gps_lat, gps_lon, gps_alt, probed_at`
+
+// SetMedia records m for its file, replacing what an earlier probe found,
+// and works the file's capture time out again from it
+func SetMedia(m model.Media) Update {
+	return func(tx *sql.Tx, fileID int64) error {
+		var lat, lon, alt any
+		if m.GPS != nil {
+			lat, lon, alt = m.GPS.Lat, m.GPS.Lon, m.GPS.Alt
+		}
+		_, err := tx.Exec(`
+INSERT INTO media (file_id, format, created_at, duration_ms, width, height, frame_rate, codec, make, model, timecode,
+                   serial, lens, orientation, gps_lat, gps_lon, gps_alt)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(file_id) DO UPDATE SET
+  format      = excluded.format,
+  created_at  = excluded.created_at,
+  duration_ms = excluded.duration_ms,
+  width       = excluded.width,
+  height      = excluded.height,
+  frame_rate  = excluded.frame_rate,
+  codec       = excluded.codec,
+  make        = excluded.make,
+  model       = excluded.model,
+  timecode    = excluded.timecode,
+  serial      = excluded.serial,
+  lens        = excluded.lens,
+  orientation = excluded.orientation,
+  gps_lat     = excluded.gps_lat,
+  gps_lon     = excluded.gps_lon,
+  gps_alt     = excluded.gps_alt,
+  probed_at   = CURRENT_TIMESTAMP
+`, fileID, m.Format, m.CreatedAt, m.DurationMS, m.Width, m.Height, m.FrameRate, m.Codec, m.Make, m.Model, m.Timecode,
+			m.Serial, m.Lens, m.Orientation, lat, lon, alt)
+		if err != nil {
+			return err
+		}
+		return recapture(tx, fileID)
+	}
+}
+
+// SetCorrupt records what validation found wrong with a file; empty clears
+// an earlier finding, e.g. after the file was copied again
+func SetCorrupt(detail string) Update {
+	return func(tx *sql.Tx, fileID int64) error {
+		_, err := tx.Exec(`UPDATE files SET corrupt = ? WHERE id = ?`, detail, fileID)
+		return err
+	}
+}
+
+// GetMedia returns what was probed from a file; false if it never was, or
+// held nothing the probe reads
+func GetMedia(db *sql.DB, fileID int64) (model.Media, bool, error) {
+	var m model.Media
+	var lat, lon, alt sql.NullFloat64
+	err := db.QueryRow(`SELECT `+mediaColumns+` FROM media WHERE file_id = ?`, fileID).Scan(
+		&m.FileID, &m.Format, &m.CreatedAt, &m.DurationMS, &m.Width, &m.Height, &m.FrameRate, &m.Codec, &m.Make, &m.Model,
+		&m.Timecode, &m.Serial, &m.Lens, &m.Orientation, &lat, &lon, &alt, &m.ProbedAt)
+	if err == sql.ErrNoRows {
+		return model.Media{}, false, nil
+	}
+	if err != nil {
+		return model.Media{}, false, err
+	}
+	if lat.Valid && lon.Valid {
+		m.GPS = &model.GPS{Lat: lat.Float64, Lon: lon.Float64, Alt: alt.Float64}
+	}
+	return m, true, nil
+}
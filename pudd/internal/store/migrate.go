@@ -0,0 +1,131 @@
+package store
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema changes after the baseline in Init are migrations, numbered from 1
+// and tracked in PRAGMA user_version. SQL migrations live in migrations/ as
+// NNNN_name.sql; migrations that need Go go in goMigrations. Versions must be
+// unique and contiguous across both.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type Migration struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	up      func(tx *sql.Tx) error
+}
+
+// migrations written in Go, for changes SQL alone can't express
+var goMigrations []Migration
+
+// timeLayout is the one timestamp format in the db: UTC, as CURRENT_TIMESTAMP writes it
+const timeLayout = "2006-01-02 15:04:05"
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+// Migrations returns every known migration in version order
+func Migrations() ([]Migration, error) {
+	all := append([]Migration(nil), goMigrations...)
+
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".sql")
+		num, rest, ok := strings.Cut(name, "_")
+		v, err := strconv.Atoi(num)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("bad migration file name %q", e.Name())
+		}
+		b, err := migrationFiles.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		stmts := string(b)
+		all = append(all, Migration{
+			Version: v,
+			Name:    rest,
+			up: func(tx *sql.Tx) error {
+				_, err := tx.Exec(stmts)
+				return err
+			},
+		})
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	for i, m := range all {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must run 1..n without gaps, found %d at position %d", m.Version, i+1)
+		}
+	}
+	return all, nil
+}
+
+func SchemaVersion(db *sql.DB) (int, error) {
+	var v int
+	err := db.QueryRow(`PRAGMA user_version`).Scan(&v)
+	return v, err
+}
+
+// PendingMigrations returns the migrations newer than the db's user_version
+func PendingMigrations(db *sql.DB) ([]Migration, error) {
+	all, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	v, err := SchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if v > len(all) {
+		return nil, fmt.Errorf("db schema version %d is newer than this pudd (%d)", v, len(all))
+	}
+	return all[v:], nil
+}
+
+// Migrate applies all pending migrations in a single transaction, so the db
+// ends up either fully migrated or untouched
+func Migrate(db *sql.DB) ([]Migration, error) {
+	pending, err := PendingMigrations(db)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, m := range pending {
+		if err := m.up(tx); err != nil {
+			return nil, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+	// user_version lives in the db header and is covered by the transaction
+	last := pending[len(pending)-1].Version
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, last)); err != nil {
+		return nil, err
+	}
+	return pending, tx.Commit()
+}
+
+// Backup writes a consistent copy of the db to path, which must not exist.
+// It is safe while other connections are writing.
+func Backup(db *sql.DB, path string) error {
+	_, err := db.Exec(`VACUUM INTO ?`, path)
+	return err
+}
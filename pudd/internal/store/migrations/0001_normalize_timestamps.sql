@@ -0,0 +1,17 @@
+-- Every timestamp column becomes UTC 'YYYY-MM-DD HH:MM:SS', the format
+-- CURRENT_TIMESTAMP and datetime() produce, so string comparisons against
+-- them are correct.
+--
+-- next_run_at used to be written from Go with the layout
+-- "2006-01-02 16:01:02": the right date, then a literal 16 for the hour and
+-- the month and day again for minute and second. Those parse as valid times,
+-- so they are recognized by that shape and cleared, which makes the row
+-- runnable now. A real backoff that happens to look the same only ends early.
+UPDATE files SET next_run_at = NULL
+WHERE strftime('%H', next_run_at) = '16'
+  AND strftime('%M', next_run_at) = strftime('%m', next_run_at)
+  AND strftime('%S', next_run_at) = strftime('%d', next_run_at);
+UPDATE files SET next_run_at = datetime(next_run_at) WHERE next_run_at IS NOT NULL;
+UPDATE files SET claim_until = datetime(claim_until) WHERE claim_until IS NOT NULL;
+UPDATE files SET updated_at = COALESCE(datetime(updated_at), CURRENT_TIMESTAMP);
+UPDATE file_events SET at = COALESCE(datetime(at), at);
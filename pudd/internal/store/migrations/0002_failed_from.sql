@@ -0,0 +1,3 @@
+-- State a file was in when it ran out of attempts, so requeue knows which
+-- stage to send it back to.
+ALTER TABLE files ADD COLUMN failed_from TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- errclass.Class of last_error. Rows parked with 'device_absent' are woken
+-- when their device is plugged back in.
+ALTER TABLE files ADD COLUMN error_class TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,33 @@
+-- One row per device pudd has ever seen, kept up to date by udev add/remove.
+-- devnode and mountpoint describe the current plug-in and are cleared when
+-- the device goes away. files/bytes_ingested count files copied off it.
+CREATE TABLE devices (
+  device_id       TEXT PRIMARY KEY,
+  id_source       TEXT NOT NULL DEFAULT '',
+  label           TEXT NOT NULL DEFAULT '',
+  owner           TEXT NOT NULL DEFAULT '',
+  first_seen      TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  last_seen       TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP),
+  devnode         TEXT NOT NULL DEFAULT '',
+  mountpoint      TEXT NOT NULL DEFAULT '',
+  present         INTEGER NOT NULL DEFAULT 0,
+  files_ingested  INTEGER NOT NULL DEFAULT 0,
+  bytes_ingested  INTEGER NOT NULL DEFAULT 0
+);
+
+-- Devices known so far only through their files. They start out absent;
+-- the next add event marks them present again.
+INSERT INTO devices (device_id, first_seen, last_seen, files_ingested, bytes_ingested)
+SELECT f.device_id,
+       MIN(e.at),
+       MAX(e.at),
+       COUNT(DISTINCT CASE WHEN e.to_state = 'COPIED' THEN f.id END),
+       COALESCE((SELECT SUM(c.size) FROM files c
+                 WHERE c.device_id = f.device_id
+                   AND c.id IN (SELECT file_id FROM file_events WHERE to_state = 'COPIED')), 0)
+FROM files f
+JOIN file_events e ON e.file_id = f.id
+GROUP BY f.device_id;
+
+INSERT OR IGNORE INTO devices (device_id)
+SELECT DISTINCT device_id FROM files;
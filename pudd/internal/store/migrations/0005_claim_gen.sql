@@ -0,0 +1,4 @@
+-- Fencing token: bumped on every claim. Workers guard their transitions with
+-- the generation they claimed, so one whose lease was taken over can't touch
+-- the row any more.
+ALTER TABLE files ADD COLUMN claim_gen INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- Per-device cap on bytes in staging; 0 falls back to the -device-quota flag.
+ALTER TABLE devices ADD COLUMN quota_bytes INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,8 @@
+-- Scheduler inputs. Devices with a higher priority are served first.
+-- captured_at starts out as the source file's mtime, which on a camera card
+-- is when the clip was recorded.
+ALTER TABLE devices ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE files ADD COLUMN captured_at TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_files_device_state
+ON files(device_id, state);
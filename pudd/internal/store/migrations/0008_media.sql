@@ -0,0 +1,17 @@
+-- What the probe stage read out of each file's container, one row per file.
+-- created_at keeps the zone the file gave, so it is RFC 3339 rather than the
+-- UTC timestamps used elsewhere.
+CREATE TABLE IF NOT EXISTS media (
+  file_id      INTEGER PRIMARY KEY REFERENCES files(id) ON DELETE CASCADE,
+  format       TEXT NOT NULL DEFAULT '',
+  created_at   TEXT NOT NULL DEFAULT '',
+  duration_ms  INTEGER NOT NULL DEFAULT 0,
+  width        INTEGER NOT NULL DEFAULT 0,
+  height       INTEGER NOT NULL DEFAULT 0,
+  frame_rate   REAL NOT NULL DEFAULT 0,
+  codec        TEXT NOT NULL DEFAULT '',
+  make         TEXT NOT NULL DEFAULT '',
+  model        TEXT NOT NULL DEFAULT '',
+  timecode     TEXT NOT NULL DEFAULT '',
+  probed_at    TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP)
+);
@@ -0,0 +1,3 @@
+-- What the validate stage found wrong with a file, empty if nothing. Such
+-- files are uploaded to the quarantine prefix and end up CORRUPT, not DONE.
+ALTER TABLE files ADD COLUMN corrupt TEXT NOT NULL DEFAULT '';
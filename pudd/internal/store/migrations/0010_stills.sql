@@ -0,0 +1,8 @@
+-- Fields stills record, read by the probe stage from EXIF and XMP. No GPS
+-- fix is NULL, as 0,0 is a place.
+ALTER TABLE media ADD COLUMN serial TEXT NOT NULL DEFAULT '';
+ALTER TABLE media ADD COLUMN lens TEXT NOT NULL DEFAULT '';
+ALTER TABLE media ADD COLUMN orientation INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE media ADD COLUMN gps_lat REAL;
+ALTER TABLE media ADD COLUMN gps_lon REAL;
+ALTER TABLE media ADD COLUMN gps_alt REAL;
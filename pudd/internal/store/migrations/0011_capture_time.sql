@@ -0,0 +1,22 @@
+-- Capture time correction. A device's timezone is what its clock is set to
+-- ('' for UTC) and clock_skew how many seconds it runs ahead of true time.
+-- mtime keeps the source file's mtime as the card gives it; captured_at
+-- becomes the corrected time, from the file's metadata where the probe
+-- stage found one, and capture_source says which. Existing rows get the
+-- uncorrected times, which is what a device with no timezone or skew set
+-- gets too.
+ALTER TABLE devices ADD COLUMN timezone TEXT NOT NULL DEFAULT '';
+ALTER TABLE devices ADD COLUMN clock_skew INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE files ADD COLUMN mtime TEXT;
+ALTER TABLE files ADD COLUMN capture_source TEXT NOT NULL DEFAULT '';
+
+UPDATE files SET mtime = captured_at, capture_source = 'mtime'
+WHERE captured_at IS NOT NULL;
+
+UPDATE files
+SET captured_at = (SELECT datetime(created_at) FROM media WHERE file_id = files.id),
+    capture_source = 'metadata'
+WHERE id IN (SELECT file_id FROM media WHERE datetime(created_at) IS NOT NULL);
+
+CREATE INDEX IF NOT EXISTS idx_files_captured
+ON files(captured_at);
@@ -0,0 +1,20 @@
+-- Telemetry the probe stage found in a file: a GoPro clip's GPMF track or a
+-- DJI .SRT sidecar. Only a summary is kept; the points are read from the
+-- file again to write the GPX and GeoJSON uploaded next to the clip.
+-- markers are moments flagged in a clip, like GoPro HiLight tags.
+CREATE TABLE IF NOT EXISTS telemetry (
+  file_id     INTEGER PRIMARY KEY REFERENCES files(id) ON DELETE CASCADE,
+  source      TEXT NOT NULL,
+  device      TEXT NOT NULL DEFAULT '',
+  points      INTEGER NOT NULL DEFAULT 0,
+  started_at  TEXT,
+  ended_at    TEXT
+);
+
+CREATE TABLE IF NOT EXISTS markers (
+  file_id    INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+  offset_ms  INTEGER NOT NULL,
+  kind       TEXT NOT NULL,
+  at         TEXT,
+  PRIMARY KEY (file_id, kind, offset_ms)
+);
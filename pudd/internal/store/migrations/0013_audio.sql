@@ -0,0 +1,28 @@
+-- What the probe stage read from a field recorder's WAV beyond the media
+-- row: the format, bext and iXML fields that line audio up with picture.
+-- time_reference is the start in samples since midnight, NULL if the file
+-- gives none, as 0 is midnight. audio_tracks holds the channel names.
+CREATE TABLE IF NOT EXISTS audio (
+  file_id         INTEGER PRIMARY KEY REFERENCES files(id) ON DELETE CASCADE,
+  sample_rate     INTEGER NOT NULL DEFAULT 0,
+  channels        INTEGER NOT NULL DEFAULT 0,
+  bit_depth       INTEGER NOT NULL DEFAULT 0,
+  time_reference  INTEGER,
+  timecode_rate   TEXT NOT NULL DEFAULT '',
+  originator      TEXT NOT NULL DEFAULT '',
+  description     TEXT NOT NULL DEFAULT '',
+  project         TEXT NOT NULL DEFAULT '',
+  scene           TEXT NOT NULL DEFAULT '',
+  take            TEXT NOT NULL DEFAULT '',
+  tape            TEXT NOT NULL DEFAULT '',
+  note            TEXT NOT NULL DEFAULT '',
+  circled         INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS audio_tracks (
+  file_id   INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+  channel   INTEGER NOT NULL,
+  name      TEXT NOT NULL DEFAULT '',
+  function  TEXT NOT NULL DEFAULT '',
+  PRIMARY KEY (file_id, channel)
+);
@@ -0,0 +1,22 @@
+-- Shoots: files from different devices recorded over the same stretch of
+-- time, as `pudd shoots group` last grouped them. A file is in at most one
+-- shoot. start_at and end_at are the stretch the file covers, worked out
+-- from its timecode or capture time as basis says; they and the shoot's
+-- bounds are UTC to the millisecond, as sync needs better than seconds.
+-- Ids are not reused, so a shoot keeps its id across regrouping.
+CREATE TABLE IF NOT EXISTS shoots (
+  id          INTEGER PRIMARY KEY AUTOINCREMENT,
+  started_at  TEXT NOT NULL,
+  ended_at    TEXT NOT NULL,
+  grouped_at  TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE IF NOT EXISTS shoot_files (
+  file_id   INTEGER PRIMARY KEY REFERENCES files(id) ON DELETE CASCADE,
+  shoot_id  INTEGER NOT NULL REFERENCES shoots(id) ON DELETE CASCADE,
+  start_at  TEXT NOT NULL,
+  end_at    TEXT NOT NULL,
+  basis     TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_shoot_files_shoot ON shoot_files(shoot_id, start_at);
@@ -0,0 +1,19 @@
+-- Cloud audit. object is the name a file was uploaded under, so the audit
+-- looks for the object that was written even if the layout or the file's
+-- capture time changed since; '' for files uploaded before it was kept.
+-- audited_at is when the auditor last checked a DONE file, and audits
+-- keeps what it found wrong.
+ALTER TABLE files ADD COLUMN object TEXT NOT NULL DEFAULT '';
+ALTER TABLE files ADD COLUMN audited_at TEXT;
+
+CREATE TABLE IF NOT EXISTS audits (
+  id        INTEGER PRIMARY KEY AUTOINCREMENT,
+  file_id   INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+  object    TEXT NOT NULL,
+  problem   TEXT NOT NULL,
+  detail    TEXT NOT NULL DEFAULT '',
+  found_at  TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE INDEX IF NOT EXISTS idx_files_audit ON files(state, audited_at);
+CREATE INDEX IF NOT EXISTS idx_audits_file ON audits(file_id);
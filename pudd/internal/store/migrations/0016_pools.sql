@@ -0,0 +1,10 @@
+-- The worker pools the daemon last started with, so `pudd status` can show
+-- them instead of whatever the CLI's own flags say. Rewritten at startup.
+CREATE TABLE IF NOT EXISTS pools (
+  stage       TEXT PRIMARY KEY,
+  state       TEXT NOT NULL,
+  workers     INTEGER NOT NULL,
+  per_device  INTEGER NOT NULL DEFAULT 0,
+  per_dest    INTEGER NOT NULL DEFAULT 0,
+  started_at  TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP)
+);
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"database/sql"
+
+	"pudd/internal/model"
+)
+
+// SavePools records the worker pools the daemon is starting with, in place
+// of the last run's
+func SavePools(db *sql.DB, pools []model.Pool) error {
+	return withTx(db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM pools`); err != nil {
+			return err
+		}
+		for _, p := range pools {
+			_, err := tx.Exec(`INSERT INTO pools (stage, state, workers, per_device, per_dest) VALUES (?, ?, ?, ?, ?)`,
+				string(p.Stage), string(p.State), p.Workers, p.PerDevice, p.PerDest)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListPools returns the pools SavePools last recorded, in pipeline order,
+// and when; none if no daemon has recorded any
+func ListPools(db *sql.DB) ([]model.Pool, string, error) {
+	rows, err := db.Query(`SELECT stage, state, workers, per_device, per_dest, started_at FROM pools`)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	byStage := map[model.Stage]model.Pool{}
+	var started string
+	for rows.Next() {
+		var p model.Pool
+		if err := rows.Scan(&p.Stage, &p.State, &p.Workers, &p.PerDevice, &p.PerDest, &started); err != nil {
+			return nil, "", err
+		}
+		byStage[p.Stage] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	// stages this binary doesn't know about go last
+	var out []model.Pool
+	for _, def := range model.Stages() {
+		if p, ok := byStage[def.Name]; ok {
+			out = append(out, p)
+			delete(byStage, def.Name)
+		}
+	}
+	for _, p := range byStage {
+		out = append(out, p)
+	}
+	return out, started, nil
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"database/sql"
+
+	"pudd/internal/model"
+)
+
+// Queries behind the reconcile package, which repairs what a crash leaves
+// behind. Rows with a live lease are always left alone: their worker may
+// still be on them.
+
+// FetchStuck returns rows in states no worker picks up, a stage's Claim or
+// Via states, whose lease has run out: their worker died mid-stage or
+// between steps without handing them back
+func FetchStuck(db *sql.DB) ([]model.FileRow, error) {
+	states := append(model.ClaimStates(), model.ViaStates()...)
+	args := make([]any, len(states))
+	for i, s := range states {
+		args[i] = string(s)
+	}
+	rows, err := db.Query(`
+SELECT `+fileColumns+`
+FROM files
+WHERE state IN (`+placeholders(len(states))+`) AND (claim_until IS NULL OR claim_until < CURRENT_TIMESTAMP)
+ORDER BY id
+`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanFiles(rows)
+}
+
+// TakeOver claims a row in place, without changing its state, if its lease
+// has run out. Returns the new claim generation, or 0 if the row moved on or
+// is leased.
+func TakeOver(db *sql.DB, fileID int64, state model.FileState, workerID string) (int64, error) {
+	var gen int64
+	err := withTx(db, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`
+UPDATE files
+SET claimed_by = ?, claim_until = NULL, claim_gen = claim_gen + 1
+WHERE id = ? AND state = ? AND (claim_until IS NULL OR claim_until < CURRENT_TIMESTAMP)
+`, workerID, fileID, string(state))
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			return nil
+		}
+		return tx.QueryRow(`SELECT claim_gen FROM files WHERE id = ?`, fileID).Scan(&gen)
+	})
+	return gen, err
+}
+
+// StagedPaths maps the staged path of every row to whether a worker holds a
+// live lease on it
+func StagedPaths(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(`
+SELECT staged_path, claim_until IS NOT NULL AND claim_until >= CURRENT_TIMESTAMP
+FROM files
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]bool{}
+	for rows.Next() {
+		var path string
+		var leased bool
+		if err := rows.Scan(&path, &leased); err != nil {
+			return nil, err
+		}
+		out[path] = out[path] || leased
+	}
+	return out, rows.Err()
+}
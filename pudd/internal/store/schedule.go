@@ -0,0 +1,268 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"pudd/internal/model"
+)
+
+// Queries behind the scheduler package. Runnable rows are those in one of
+// the requested states whose backoff is over, except DISCOVERED rows of
+// devices that aren't plugged in: copying them could only fail.
+
+// RunnableQuery says which runnable rows a caller wants
+type RunnableQuery struct {
+	States []model.FileState
+	// rows the caller already has in flight
+	Skip []int64
+	// at most this many rows per device, counting those in Busy (0 = no limit)
+	PerDevice int
+	Busy      map[string]int
+}
+
+// DeviceRoom is how many more rows q allows for a device
+func (q RunnableQuery) DeviceRoom(deviceID string, n int) int {
+	if q.PerDevice <= 0 {
+		return n
+	}
+	return min(n, q.PerDevice-q.Busy[deviceID])
+}
+
+func (q RunnableQuery) where() (string, []any) {
+	args := make([]any, 0, len(q.States)+len(q.Skip)+1)
+	for _, s := range q.States {
+		args = append(args, string(s))
+	}
+	// files still to be copied need their device
+	args = append(args, string(model.Stages()[0].Input))
+	where := `(next_run_at IS NULL OR next_run_at <= CURRENT_TIMESTAMP)
+  AND state IN (` + placeholders(len(q.States)) + `)
+  AND (state <> ? OR device_id IN (SELECT device_id FROM devices WHERE present = 1))`
+	if len(q.Skip) > 0 {
+		where += `
+  AND id NOT IN (` + placeholders(len(q.Skip)) + `)`
+		for _, id := range q.Skip {
+			args = append(args, id)
+		}
+	}
+	return where, args
+}
+
+// FetchRunnable returns runnable rows in id order
+func FetchRunnable(db *sql.DB, q RunnableQuery, limit int) ([]model.FileRow, error) {
+	where, args := q.where()
+	if q.PerDevice <= 0 {
+		rows, err := db.Query(`
+SELECT `+fileColumns+`
+FROM files
+WHERE `+where+`
+ORDER BY id
+LIMIT ?
+`, append(args, limit)...)
+		if err != nil {
+			return nil, err
+		}
+		return scanFiles(rows)
+	}
+
+	// number each device's rows and keep those that fit next to its busy ones
+	busy := `0`
+	var busyArgs []any
+	if len(q.Busy) > 0 {
+		busy = `CASE device_id`
+		for id, n := range q.Busy {
+			busy += ` WHEN ? THEN ?`
+			busyArgs = append(busyArgs, id, n)
+		}
+		busy += ` ELSE 0 END`
+	}
+	args = append(busyArgs, args...)
+	rows, err := db.Query(`
+SELECT `+fileColumns+`
+FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY device_id ORDER BY id) + `+busy+` AS taken
+      FROM files
+      WHERE `+where+`)
+WHERE taken <= ?
+ORDER BY id
+LIMIT ?
+`, append(args, q.PerDevice, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanFiles(rows)
+}
+
+// SmallestRunnable returns the size of the smallest runnable row q asks for;
+// false if there is none
+func SmallestRunnable(db *sql.DB, q RunnableQuery) (int64, bool, error) {
+	where, args := q.where()
+	var size sql.NullInt64
+	err := db.QueryRow(`SELECT MIN(size) FROM files WHERE `+where, args...).Scan(&size)
+	return size.Int64, size.Valid, err
+}
+
+// DeviceQueue is a device with runnable rows
+type DeviceQueue struct {
+	DeviceID string
+	Priority int64
+}
+
+// RunnableDevices lists the devices with runnable rows, highest priority first
+func RunnableDevices(db *sql.DB, q RunnableQuery) ([]DeviceQueue, error) {
+	where, args := q.where()
+	rows, err := db.Query(`
+SELECT r.device_id, COALESCE((SELECT priority FROM devices d WHERE d.device_id = r.device_id), 0) AS priority
+FROM (SELECT DISTINCT device_id FROM files WHERE `+where+`) r
+ORDER BY priority DESC, r.device_id
+`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []DeviceQueue
+	for rows.Next() {
+		var q DeviceQueue
+		if err := rows.Scan(&q.DeviceID, &q.Priority); err != nil {
+			return nil, err
+		}
+		out = append(out, q)
+	}
+	return out, rows.Err()
+}
+
+// NextRunAt returns when the earliest row in one of states comes out of
+// backoff, or the zero time if none is backing off
+func NextRunAt(db *sql.DB, states []model.FileState) (time.Time, error) {
+	if len(states) == 0 {
+		return time.Time{}, nil
+	}
+	args := make([]any, len(states))
+	for i, s := range states {
+		args[i] = string(s)
+	}
+	var next sql.NullString
+	err := db.QueryRow(`
+SELECT MIN(next_run_at)
+FROM files
+WHERE next_run_at > CURRENT_TIMESTAMP AND state IN (`+placeholders(len(states))+`)
+`, args...).Scan(&next)
+	if err != nil || !next.Valid {
+		return time.Time{}, err
+	}
+	return time.ParseInLocation(timeLayout, next.String, time.UTC)
+}
+
+// SortKey orders a device's runnable rows; ties are broken by id
+type SortKey string
+
+const (
+	SortDiscovered SortKey = "discovered"     // first discovered first
+	SortSmallest   SortKey = "smallest"       // smallest file first
+	SortCapture    SortKey = "oldest-capture" // oldest recording first, unknown last
+	SortClass      SortKey = "class"          // by media class, in Order.Classes order
+)
+
+type Order struct {
+	Key SortKey
+	// for SortClass, most urgent first; classes not listed go last
+	Classes []model.MediaClass
+}
+
+func (o Order) Validate() error {
+	_, _, err := o.expr()
+	return err
+}
+
+func (o Order) expr() (string, []any, error) {
+	switch o.Key {
+	case SortDiscovered, "":
+		return `0`, nil, nil
+	case SortSmallest:
+		return `size`, nil, nil
+	case SortCapture:
+		return `COALESCE(captured_at, '9999')`, nil, nil
+	case SortClass:
+		expr := `CASE`
+		var args []any
+		for rank, c := range o.Classes {
+			for _, ext := range c.Extensions() {
+				expr += ` WHEN lower(src_path) LIKE ? THEN ?`
+				args = append(args, "%"+ext, rank)
+			}
+		}
+		return expr + fmt.Sprintf(` ELSE %d END`, len(o.Classes)), args, nil
+	}
+	return "", nil, fmt.Errorf("unknown sort key %q", o.Key)
+}
+
+// Cursor is a keyset position in a device's runnable rows. The zero Cursor
+// is the start.
+type Cursor struct {
+	Key any
+	ID  int64
+}
+
+// RunnableFile is a runnable row and its position for keyset pagination
+type RunnableFile struct {
+	File   model.FileRow
+	Cursor Cursor
+}
+
+// FetchRunnableAfter returns up to limit of a device's runnable rows in order,
+// starting after cursor
+func FetchRunnableAfter(db *sql.DB, deviceID string, q RunnableQuery, order Order, after Cursor, limit int) ([]RunnableFile, error) {
+	key, keyArgs, err := order.expr()
+	if err != nil {
+		return nil, err
+	}
+	where, whereArgs := q.where()
+
+	args := append([]any{}, keyArgs...)
+	args = append(args, deviceID)
+	args = append(args, whereArgs...)
+	query := `
+SELECT ` + fileColumns + `, ` + key + ` AS sort_key
+FROM files
+WHERE device_id = ? AND ` + where
+	if after.ID != 0 {
+		query += `
+  AND (` + key + `, id) > (?, ?)`
+		args = append(args, keyArgs...)
+		args = append(args, after.Key, after.ID)
+	}
+	query += `
+ORDER BY sort_key, id
+LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []RunnableFile
+	for rows.Next() {
+		var rf RunnableFile
+		rf.File, err = scanFile(withExtra{rows, []any{&rf.Cursor.Key}})
+		if err != nil {
+			return nil, err
+		}
+		rf.Cursor.ID = rf.File.ID
+		out = append(out, rf)
+	}
+	return out, rows.Err()
+}
+
+// withExtra scans columns selected after fileColumns into extra
+type withExtra struct {
+	r     rowScanner
+	extra []any
+}
+
+func (w withExtra) Scan(dest ...any) error {
+	return w.r.Scan(append(dest, w.extra...)...)
+}
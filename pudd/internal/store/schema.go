@@ -2,7 +2,8 @@ package store
 
 import "database/sql"
 
-
+// Init creates the baseline schema (user_version 0). It must stay idempotent;
+// every later change to the schema is a migration (see migrate.go).
 func Init(db *sql.DB) error {
 	stmts := []string{
 		`PRAGMA journal_mode=WAL;`,
@@ -35,6 +36,20 @@ ON files(state, next_run_at);
 
 CREATE INDEX IF NOT EXISTS idx_files_claim_until
 ON files(claim_until);
+
+CREATE TABLE IF NOT EXISTS file_events (
+  id          INTEGER PRIMARY KEY AUTOINCREMENT,
+  file_id     INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+  from_state  TEXT NOT NULL,
+  to_state    TEXT NOT NULL,
+  worker      TEXT NOT NULL DEFAULT '',
+  error       TEXT NOT NULL DEFAULT '',
+  bytes       INTEGER NOT NULL DEFAULT 0,
+  at          TEXT NOT NULL DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_events_file
+ON file_events(file_id, id);
 `,
 	}
 
@@ -0,0 +1,186 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	"pudd/internal/model"
+)
+
+// The shoots table holds the groups shoot grouping last found, see package
+// shoot. Regrouping replaces them all at once.
+
+// SpanLayout formats the times in shoots: UTC, to the millisecond
+const SpanLayout = "2006-01-02 15:04:05.000"
+
+// ListRecordings returns what shoot grouping needs of every file with a
+// capture time, but skipped ones
+func ListRecordings(db *sql.DB) ([]model.Recording, error) {
+	rows, err := db.Query(`
+SELECT f.id, f.device_id, f.captured_at, f.capture_source, COALESCE(m.duration_ms, 0), COALESCE(m.timecode, ''),
+       COALESCE(m.frame_rate, 0), COALESCE(a.timecode_rate, ''), COALESCE(d.timezone, '')
+FROM files f
+LEFT JOIN media m ON m.file_id = f.id
+LEFT JOIN audio a ON a.file_id = f.id
+LEFT JOIN devices d ON d.device_id = f.device_id
+WHERE f.captured_at IS NOT NULL AND f.state != ?
+ORDER BY f.id
+`, string(model.StateSkipped))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []model.Recording
+	for rows.Next() {
+		var r model.Recording
+		var audioRate string
+		if err := rows.Scan(&r.FileID, &r.DeviceID, &r.CapturedAt, &r.CaptureSource, &r.DurationMS, &r.Timecode,
+			&r.TimecodeRate, &audioRate, &r.Timezone); err != nil {
+			return nil, err
+		}
+		// a recording's timecode counts at the rate iXML gives, not a
+		// frame rate it doesn't have
+		if audioRate != "" {
+			r.TimecodeRate = model.ParseRate(audioRate)
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// SaveShoots replaces the stored shoots with shoots, whose ids are ignored.
+// A new shoot takes over the id of the old one most of its files were in,
+// so regrouping after more files came in keeps the ids editors know. It
+// returns shoots with their ids set.
+func SaveShoots(db *sql.DB, shoots []model.Shoot) ([]model.Shoot, error) {
+	err := withTx(db, func(tx *sql.Tx) error {
+		old := map[int64]int64{}
+		rows, err := tx.Query(`SELECT file_id, shoot_id FROM shoot_files`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var fileID, shootID int64
+			if err := rows.Scan(&fileID, &shootID); err != nil {
+				rows.Close()
+				return err
+			}
+			old[fileID] = shootID
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`DELETE FROM shoot_files`); err != nil {
+			return err
+		}
+		taken := map[int64]bool{}
+		for i := range shoots {
+			s := &shoots[i]
+			s.ID = 0
+			votes := map[int64]int{}
+			for _, f := range s.Files {
+				if id, ok := old[f.FileID]; ok && !taken[id] {
+					votes[id]++
+				}
+			}
+			for id, n := range votes {
+				// ties go to the older shoot
+				if s.ID == 0 || n > votes[s.ID] || (n == votes[s.ID] && id < s.ID) {
+					s.ID = id
+				}
+			}
+
+			if s.ID != 0 {
+				taken[s.ID] = true
+				_, err = tx.Exec(`UPDATE shoots SET started_at = ?, ended_at = ?, grouped_at = CURRENT_TIMESTAMP WHERE id = ?`,
+					s.StartedAt, s.EndedAt, s.ID)
+			} else {
+				var res sql.Result
+				res, err = tx.Exec(`INSERT INTO shoots (started_at, ended_at) VALUES (?, ?)`, s.StartedAt, s.EndedAt)
+				if err == nil {
+					s.ID, err = res.LastInsertId()
+					taken[s.ID] = true
+				}
+			}
+			if err != nil {
+				return err
+			}
+			for _, f := range s.Files {
+				_, err := tx.Exec(`INSERT INTO shoot_files (file_id, shoot_id, start_at, end_at, basis) VALUES (?, ?, ?, ?, ?)`,
+					f.FileID, s.ID, f.StartAt, f.EndAt, f.Basis)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		_, err = tx.Exec(`DELETE FROM shoots WHERE id NOT IN (SELECT shoot_id FROM shoot_files)`)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shoots, nil
+}
+
+// ListShoots returns the stored shoots in time order, without their files
+func ListShoots(db *sql.DB) ([]model.Shoot, error) {
+	rows, err := db.Query(`
+SELECT s.id, s.started_at, s.ended_at, s.grouped_at, COUNT(DISTINCT f.device_id)
+FROM shoots s
+JOIN shoot_files sf ON sf.shoot_id = s.id
+JOIN files f ON f.id = sf.file_id
+GROUP BY s.id
+ORDER BY s.started_at, s.id
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []model.Shoot
+	for rows.Next() {
+		var s model.Shoot
+		if err := rows.Scan(&s.ID, &s.StartedAt, &s.EndedAt, &s.GroupedAt, &s.Devices); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// GetShoot returns a stored shoot with its files, in the order they start
+func GetShoot(db *sql.DB, id int64) (model.Shoot, error) {
+	s := model.Shoot{ID: id}
+	err := db.QueryRow(`SELECT started_at, ended_at, grouped_at FROM shoots WHERE id = ?`, id).
+		Scan(&s.StartedAt, &s.EndedAt, &s.GroupedAt)
+	if err == sql.ErrNoRows {
+		return s, fmt.Errorf("shoot %d not found", id)
+	}
+	if err != nil {
+		return s, err
+	}
+
+	rows, err := db.Query(`
+SELECT sf.file_id, f.device_id, sf.start_at, sf.end_at, sf.basis
+FROM shoot_files sf
+JOIN files f ON f.id = sf.file_id
+WHERE sf.shoot_id = ?
+ORDER BY sf.start_at, sf.file_id
+`, id)
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+	devices := map[string]bool{}
+	for rows.Next() {
+		var f model.ShootFile
+		if err := rows.Scan(&f.FileID, &f.DeviceID, &f.StartAt, &f.EndAt, &f.Basis); err != nil {
+			return s, err
+		}
+		devices[f.DeviceID] = true
+		s.Files = append(s.Files, f)
+	}
+	s.Devices = len(devices)
+	return s, rows.Err()
+}
@@ -2,9 +2,12 @@ package store
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"math/rand/v2"
 	"time"
 
+	"pudd/internal/errclass"
 	"pudd/internal/model"
 
 	_ "modernc.org/sqlite"
@@ -16,198 +19,454 @@ type DiscoveredRow struct {
 	StagedPath string
 	Size int64
 	State model.FileState
+	By string // worker in the journal; "discover" if empty
+	MTime time.Time // the source file's mtime; zero if unknown
 }
 
+// columns selected for a model.FileRow, in scanFile order
+const fileColumns = `id, device_id, src_path, staged_path, size, sha256, crc32c, state, attempts, last_error,
+       COALESCE(next_run_at, ''), updated_at, failed_from, error_class, claim_gen, COALESCE(captured_at, ''), capture_source,
+       COALESCE(mtime, ''), corrupt, object`
+
 func Open(path string) (*sql.DB, error) {
-	return sql.Open("sqlite", path)
+	// busy_timeout is per connection, so it goes in the DSN rather than Init.
+	// This lets the CLI and the daemon share the db without SQLITE_BUSY.
+	// _txlock=immediate takes the write lock at BEGIN, so a transaction that
+	// reads before it writes can't fail with SQLITE_BUSY halfway through.
+	return sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_txlock=immediate")
 }
 
-func InsertDiscovered(db *sql.DB, r DiscoveredRow) error {
-	_, err := db.Exec(`
-INSERT OR IGNORE INTO files (device_id, src_path, staged_path, size, state)
-VALUES (?, ?, ?, ?, ?)
-`, r.DeviceID, r.SrcPath, r.StagedPath, r.Size, string(r.State))
-	return err
+// OpenReadOnly opens the db so that nothing, not even a pragma, can write to it
+func OpenReadOnly(path string) (*sql.DB, error) {
+	return sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
 }
 
-func FetchRunnable(db *sql.DB, limit int) ([]model.FileRow, error) {
-	rows, err := db.Query(`
-SELECT id, device_id, src_path, staged_path, size, sha256, crc32c, state, attempts, last_error
-FROM files
-WHERE (next_run_at IS NULL OR next_run_at <= CURRENT_TIMESTAMP) AND state IN ('DISCOVERED','QUEUED','VERIFIED')
-ORDER BY id
-LIMIT ?
-`, limit)
+// OpenMemory opens a db of its own in memory, initialized and migrated, for
+// tests
+func OpenMemory() (*sql.DB, error) {
+	db, err := sql.Open("sqlite", ":memory:?_pragma=foreign_keys(1)&_txlock=immediate")
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var out []model.FileRow
-	for rows.Next() {
-		var f model.FileRow
-		var stateStr string
-		var crc32c int64
-		if err := rows.Scan(
-			&f.ID, &f.DeviceID, &f.SrcPath, &f.StagedPath,
-			&f.Size, &f.SHA256, &crc32c,
-			&stateStr, &f.Attempts, &f.LastError,
-		); err != nil {
-			return nil, err
-		}
-		f.CRC32C = uint32(crc32c)
-		f.State = model.FileState(stateStr)
-		out = append(out, f)
+	// every connection to :memory: is a db of its own
+	db.SetMaxOpenConns(1)
+	if err := Init(db); err != nil {
+		db.Close()
+		return nil, err
 	}
-	return out, rows.Err()
+	if _, err := Migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
 }
 
-func FetchRunnableQueued(db *sql.DB, limit int) ([]model.FileRow, error) {
-	rows, err := db.Query(`
-SELECT id, device_id, src_path, staged_path, size, sha256, crc32c, state, attempts, last_error
-FROM files
-WHERE state = ? AND (next_run_at IS NULL OR next_run_at <= CURRENT_TIMESTAMP)
-ORDER BY id
-LIMIT ?
-`, string(model.StateQueued), limit)
-	if err != nil {
-		return nil, err
+// InsertDiscovered reports false if the row was already known
+func InsertDiscovered(db *sql.DB, r DiscoveredRow) (bool, error) {
+	var mtime any
+	if !r.MTime.IsZero() {
+		mtime = formatTime(r.MTime)
 	}
-	defer rows.Close()
+	inserted := false
+	err := withTx(db, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`
+INSERT OR IGNORE INTO files (device_id, src_path, staged_path, size, state, mtime)
+VALUES (?, ?, ?, ?, ?, ?)
+`, r.DeviceID, r.SrcPath, r.StagedPath, r.Size, string(r.State), mtime)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			return nil
+		}
+		inserted = true
 
-	var out []model.FileRow
-	for rows.Next() {
-		var f model.FileRow
-		var stateStr string
-		var crc32c int64
-		if err := rows.Scan(
-			&f.ID, &f.DeviceID, &f.SrcPath, &f.StagedPath, &f.Size, &f.SHA256, &crc32c, &stateStr, &f.Attempts, &f.LastError,
-		); err != nil {
-			return nil, err
+		// until the probe stage reads the file's own, capture time is the
+		// mtime corrected with the device's clock
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
 		}
-		f.CRC32C = uint32(crc32c)
-		f.State = model.FileState(stateStr)
-		out = append(out, f)
+		if err := recapture(tx, id); err != nil {
+			return err
+		}
+
+		// first journal entry; there is no from_state yet
+		by := r.By
+		if by == "" {
+			by = "discover"
+		}
+		_, err = tx.Exec(`
+INSERT INTO file_events (file_id, from_state, to_state, worker, bytes)
+VALUES (?, '', ?, ?, ?)
+`, id, string(r.State), by, r.Size)
+		return err
+	})
+	return inserted, err
+}
+
+// ErrLeaseLost means a fenced update was refused because the row has been
+// claimed again since, or released, so the caller no longer owns it
+var ErrLeaseLost = errors.New("lease lost")
+
+// The Claim functions return the claim generation (fencing token), or 0 if
+// the row couldn't be claimed. The worker passes it to Renew, Transition and
+// MarkErrorWithBackoff, which refuse to act once the row has been re-claimed.
+
+// Claim takes a file waiting in stage's Input into its Claim state
+func Claim(db *sql.DB, fileID int64, workerID string, stage model.Stage, lease time.Duration) (int64, error) {
+	def, ok := stage.Def()
+	if !ok {
+		return 0, fmt.Errorf("claim file=%d: unknown stage %q", fileID, stage)
 	}
-	
-	return out, rows.Err()
+	return claim(db, fileID, workerID, lease, def.Input, def.Claim)
 }
 
-// claim file for upload with lease
-func ClaimForUpload(db *sql.DB, fileID int64, claimedBy string, lease time.Duration) (bool, error) {
+// claim moves a row from -> to under a lease, or takes over a row whose lease in `to` expired
+func claim(db *sql.DB, fileID int64, workerID string, lease time.Duration, from, to model.FileState) (int64, error) {
+	var gen int64
+	err := withTx(db, func(tx *sql.Tx) error {
+		ok, err := (change{
+			fileID:  fileID,
+			to:      to,
+			where:   `state = ? OR (state = ? AND (claim_until IS NULL OR claim_until < CURRENT_TIMESTAMP))`,
+			args:    []any{string(from), string(to)},
+			set:     `claimed_by = ?, claim_until = datetime('now', ?), claim_gen = claim_gen + 1, `,
+			setArgs: []any{workerID, sqliteDuration(lease)},
+			worker:  workerID,
+		}).apply(tx)
+		if err != nil || !ok {
+			return err
+		}
+		return tx.QueryRow(`SELECT claim_gen FROM files WHERE id = ?`, fileID).Scan(&gen)
+	})
+	return gen, err
+}
+
+// Renew extends the lease of claim gen on a file. It reports false once the
+// row has been claimed by someone else or released; the caller must then stop
+// and not touch the staged file.
+func Renew(db *sql.DB, fileID, gen int64, lease time.Duration) (bool, error) {
 	res, err := db.Exec(`
 UPDATE files
-SET state = ?, claimed_by = ?, claim_until = datetime('now', ?), updated_at = CURRENT_TIMESTAMP
-WHERE id = ? AND (state = ? OR (state = ? AND (claim_until IS NULL OR claim_until < CURRENT_TIMESTAMP)))	
-`, string(model.StateUploading), claimedBy, sqliteDuration(lease), fileID, string(model.StateQueued), string(model.StateUploading),
-	)
+SET claim_until = datetime('now', ?)
+WHERE id = ? AND claim_gen = ? AND claimed_by <> ''
+`, sqliteDuration(lease), fileID, gen)
 	if err != nil {
 		return false, err
 	}
-	n, _ := res.RowsAffected()
-	return n == 1, nil
+	n, err := res.RowsAffected()
+	return n == 1, err
 }
 
-func ClaimDiscovered(db *sql.DB, fileID int64, workerID string, lease time.Duration) (bool, error) {
-	res, err := db.Exec(`
-UPDATE files
-SET state='COPYING',
-    claimed_by=?,
-    claim_until=datetime('now', ?),
-    updated_at=CURRENT_TIMESTAMP
-WHERE id=?
-  AND (
-    state='DISCOVERED'
-    OR (state='COPYING' AND (claim_until IS NULL OR claim_until < CURRENT_TIMESTAMP))
-  )
-`, workerID, sqliteDuration(lease), fileID)
-	if err != nil { return false, err }
-	n, _ := res.RowsAffected()
-	return n == 1, nil
-}
-
-func ClaimQueued(db *sql.DB, fileID int64, workerID string, lease time.Duration) (bool, error) {
-	res, err := db.Exec(`
-UPDATE files
-SET state='UPLOADING', claimed_by=?, claim_until=datetime('now', ?), updated_at=CURRENT_TIMESTAMP
-WHERE id=?
-  AND (
-    state='QUEUED'
-    OR (state='UPLOADING' AND (claim_until IS NULL OR claim_until < CURRENT_TIMESTAMP))
-  )
-`, workerID, sqliteDuration(lease), fileID)
-	if err != nil { return false, err }
-	n, _ := res.RowsAffected()
-	return n == 1, nil
-}
-
-func ClaimVerified(db *sql.DB, fileID int64, workerID string, lease time.Duration) (bool, error) {
-	res, err := db.Exec(`
-UPDATE files
-SET state='CLEANING', claimed_by=?, claim_until=datetime('now', ?), updated_at=CURRENT_TIMESTAMP
-WHERE id=? AND ( state='VERIFIED' OR (state='CLEANING' AND (claim_until IS NULL OR claim_until < CURRENT_TIMESTAMP)))
-`, workerID, sqliteDuration(lease), fileID)
-	if err != nil { return false, err }
-	n, _ := res.RowsAffected()
-	return n == 1, nil
+// leaseLost tells a refused fenced change apart from a plain state mismatch
+func leaseLost(tx *sql.Tx, fileID, gen int64) error {
+	var cur int64
+	if err := tx.QueryRow(`SELECT claim_gen FROM files WHERE id = ?`, fileID).Scan(&cur); err != nil {
+		return err
+	}
+	if cur != gen {
+		return fmt.Errorf("file=%d claim %d superseded by %d: %w", fileID, gen, cur, ErrLeaseLost)
+	}
+	return nil
 }
 
+// ReleaseClaims hands back the rows workers were in the middle of when they
+// stopped, e.g. at shutdown. Each goes to its stage's retry state without
+// using an attempt, and its lease is cleared. Returns the number released.
+func ReleaseClaims(db *sql.DB, workers []string) (int64, error) {
+	var n int64
+	err := withTx(db, func(tx *sql.Tx) error {
+		for _, from := range model.AllStates {
+			to := from.Stage().RetryState()
+			if to == "" || to == from {
+				continue
+			}
+			for _, w := range workers {
+				ids, err := queryIDs(tx, `SELECT id FROM files WHERE state = ? AND claimed_by = ?`, string(from), w)
+				if err != nil {
+					return err
+				}
+				for _, id := range ids {
+					ok, err := (change{
+						fileID: id,
+						to:     to,
+						where:  `state = ? AND claimed_by = ?`,
+						args:   []any{string(from), w},
+						set:    `claimed_by = '', claim_until = NULL, claim_gen = claim_gen + 1, `,
+						errMsg: "interrupted by shutdown",
+					}).apply(tx)
+					if err != nil {
+						return fmt.Errorf("release file=%d: %w", id, err)
+					}
+					if ok {
+						n++
+					}
+				}
+			}
+		}
+		return nil
+	})
+	return n, err
+}
 
-// transition a file to a state
-func Transition(db *sql.DB, fileID int64, from, to model.FileState) error {
-	res, err := db.Exec(`
-UPDATE files
-SET state = ?, updated_at = CURRENT_TIMESTAMP
-WHERE id = ? AND state = ?
-`, string (to), fileID, string(from))
-	if err != nil {
-		return err
-	}
-	n, _ := res.RowsAffected()
-	if n != 1 {
-		return fmt.Errorf("Transition %s -> %s failed for file=%d", from, to, fileID)
+// transition a file to a state, as the holder of claim gen (ErrLeaseLost if it isn't)
+func Transition(db *sql.DB, fileID, gen int64, from, to model.FileState) error {
+	return withTx(db, func(tx *sql.Tx) error {
+		return transition(tx, fileID, gen, from, to)
+	})
+}
+
+// Complete moves a file its worker is done with from stage's Claim state
+// along the stage's Path to its Output, see Advance
+func Complete(db *sql.DB, fileID, gen int64, stage model.Stage, updates ...Update) error {
+	def, ok := stage.Def()
+	if !ok {
+		return fmt.Errorf("complete file=%d: unknown stage %q", fileID, stage)
 	}
-	return nil;
+	return Advance(db, fileID, gen, def.Claim, def.Path(), updates...)
 }
 
-// for updating hashes post network action
-func UpdateHashes(db *sql.DB, fileID int64, size int64, sha256 string, crc32c uint32) error {
-	_, err := db.Exec(`
+// Advance moves a file from `from` through each state of path in turn, as
+// the holder of claim gen, and applies updates, all in one transaction: a
+// crash leaves the file where it was, with none of the updates. Every step is
+// journaled and has to be legal. A file that failed validation ends up
+// CORRUPT where path ends in DONE.
+func Advance(db *sql.DB, fileID, gen int64, from model.FileState, path []model.FileState, updates ...Update) error {
+	return withTx(db, func(tx *sql.Tx) error {
+		for _, u := range updates {
+			if err := u(tx, fileID); err != nil {
+				return err
+			}
+		}
+		for _, to := range path {
+			msg := ""
+			if to == model.StateDone {
+				if err := tx.QueryRow(`SELECT corrupt FROM files WHERE id = ?`, fileID).Scan(&msg); err != nil {
+					return err
+				}
+				if msg != "" {
+					to = model.StateCorrupt
+				}
+			}
+			if err := transitionMsg(tx, fileID, gen, from, to, msg); err != nil {
+				return err
+			}
+			from = to
+		}
+		return nil
+	})
+}
+
+// An Update changes a file's row along with its state, see Advance
+type Update func(tx *sql.Tx, fileID int64) error
+
+const updateHashes = `
 UPDATE files
 SET size=?, sha256=?, crc32c=?, updated_at=CURRENT_TIMESTAMP
-WHERE id=?`,
-		size, sha256, int64(crc32c), fileID,
-	)
-	return err
+WHERE id=?`
+
+// SetHashes records a file's size and checksums
+func SetHashes(size int64, sha256 string, crc32c uint32) Update {
+	return func(tx *sql.Tx, fileID int64) error {
+		_, err := tx.Exec(updateHashes, size, sha256, int64(crc32c), fileID)
+		return err
+	}
+}
+
+func transition(tx *sql.Tx, fileID, gen int64, from, to model.FileState) error {
+	return transitionMsg(tx, fileID, gen, from, to, "")
 }
 
-func MarkErrorWithBackoff(db *sql.DB, fileID int64, cause error) {
-	var attempts int64
-	_ = db.QueryRow(`SELECT attempts FROM files WHERE id=?`, fileID).Scan(&attempts)
-	attempts++
+// transitionMsg is transition with msg in the journal entry's error
+func transitionMsg(tx *sql.Tx, fileID, gen int64, from, to model.FileState, msg string) error {
+	c := change{
+		fileID: fileID,
+		to:     to,
+		where:  `state = ? AND claim_gen = ?`,
+		args:   []any{string(from), gen},
+		errMsg: msg,
+	}
+	// attempts are counted per stage, so finishing a stage starts the next one fresh
+	if from.Stage() != to.Stage() {
+		c.set = `attempts = 0, last_error = '', error_class = '', next_run_at = NULL, `
+	}
+	ok, err := c.apply(tx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := leaseLost(tx, fileID, gen); err != nil {
+			return err
+		}
+		return fmt.Errorf("Transition %s -> %s failed for file=%d", from, to, fileID)
+	}
+	if to == model.StateCopied {
+		return countIngested(tx, fileID)
+	}
+	return nil
+}
 
-	// exponential backoff
-	delay := time.Second * time.Duration(1 << min64(attempts, 10))
-	nextRun := time.Now().Add(delay).UTC().Format("2006-01-02 16:01:02")
+const (
+	// how long rows wait on an unplugged device before trying again;
+	// WakeDevice cuts it short when the device comes back
+	deviceAbsentWait = 10 * time.Minute
+	// rows hit by a stage-wide problem (disk full, credentials) are re-offered
+	// after this; the pipeline keeps the stage paused meanwhile
+	stagePausedWait = 30 * time.Second
+)
 
+// MarkErrorWithBackoff records a failed attempt at the file's current stage
+// and decides what happens next from the error's errclass.Class:
+//   - Transient: back to the stage's retry state with jittered exponential
+//     backoff, or FAILED once the stage has used maxAttempts (0 = unlimited)
+//   - DeviceAbsent: wait for the device without using an attempt
+//   - ResourceExhausted, Auth: re-offered shortly without using an attempt;
+//     the pipeline pauses the stage until the cause clears
+//   - Permanent: FAILED right away
+//
+// Reports whether the file is now FAILED. gen is the caller's claim; a caller
+// that lost it gets ErrLeaseLost and the row is left to its new owner.
+func MarkErrorWithBackoff(db *sql.DB, fileID, gen int64, cause error, maxAttempts int) (bool, error) {
 	msg := cause.Error()
 	if len(msg) > 500 {
 		msg = msg[:500]
 	}
+	class := errclass.Of(cause)
 
-	_, _ = db.Exec(`
-UPDATE files
-SET state = ?, attempts = ?, last_error = ?, next_run_at = ?, updated_at = CURRENT_TIMESTAMP
-WHERE id=?`, 
-		string(model.StateError), attempts, msg, nextRun, fileID,
-	)
+	failed := false
+	err := withTx(db, func(tx *sql.Tx) error {
+		var attempts, curGen int64
+		var stateStr string
+		if err := tx.QueryRow(`SELECT attempts, state, claim_gen FROM files WHERE id=?`, fileID).Scan(&attempts, &stateStr, &curGen); err != nil {
+			return fmt.Errorf("read attempts file=%d: %w", fileID, err)
+		}
+		if curGen != gen {
+			return fmt.Errorf("mark error file=%d claim %d superseded by %d: %w", fileID, gen, curGen, ErrLeaseLost)
+		}
+
+		from := model.FileState(stateStr)
+		retryTo := from.Stage().RetryState()
+		if retryTo == "" {
+			return fmt.Errorf("mark error file=%d: not in a pipeline stage (state %s)", fileID, from)
+		}
+
+		var delay time.Duration
+		switch class {
+		case errclass.Permanent:
+			attempts++
+			failed = true
+		case errclass.DeviceAbsent:
+			delay = deviceAbsentWait
+		case errclass.ResourceExhausted, errclass.Auth:
+			delay = stagePausedWait
+		default:
+			attempts++
+			failed = maxAttempts > 0 && attempts >= int64(maxAttempts)
+			delay = backoff(attempts)
+		}
+
+		if failed {
+			if _, err := (change{
+				fileID:  fileID,
+				to:      model.StateFailed,
+				where:   `state = ?`,
+				args:    []any{stateStr},
+				set:     `attempts = ?, last_error = ?, error_class = ?, failed_from = ?, next_run_at = NULL, claim_until = NULL, `,
+				setArgs: []any{attempts, msg, string(class), stateStr},
+				errMsg:  msg,
+			}).apply(tx); err != nil {
+				return fmt.Errorf("mark failed file=%d: %w", fileID, err)
+			}
+			return nil
+		}
 
-	_, _ = db.Exec(`
+		if _, err := (change{
+			fileID:  fileID,
+			to:      model.StateError,
+			where:   `state = ?`,
+			args:    []any{stateStr},
+			set:     `attempts = ?, last_error = ?, error_class = ?, next_run_at = ?, `,
+			setArgs: []any{attempts, msg, string(class), formatTime(time.Now().Add(delay))},
+			errMsg:  msg,
+		}).apply(tx); err != nil {
+			return fmt.Errorf("mark error file=%d: %w", fileID, err)
+		}
+
+		if _, err := (change{
+			fileID: fileID,
+			to:     retryTo,
+			where:  `state = ?`,
+			args:   []any{string(model.StateError)},
+		}).apply(tx); err != nil {
+			return fmt.Errorf("requeue file=%d: %w", fileID, err)
+		}
+		return nil
+	})
+	return failed, err
+}
+
+// backoff is exponential in attempts, capped at 1024s, with jitter over the
+// upper half so files that failed together don't retry in lockstep
+func backoff(attempts int64) time.Duration {
+	d := time.Second * time.Duration(1 << min64(attempts, 10))
+	return d/2 + rand.N(d/2)
+}
+
+// WakeDevice clears the wait on rows that were parked because deviceID was
+// unplugged. Returns the number of rows woken.
+func WakeDevice(db *sql.DB, deviceID string) (int64, error) {
+	res, err := db.Exec(`
 UPDATE files
-SET state=?, updated_at=CURRENT_TIMESTAMP
-WHERE id=? AND state=?`,
-		string(model.StateQueued), fileID, string(model.StateError),
-	)
+SET next_run_at = NULL, updated_at = CURRENT_TIMESTAMP
+WHERE device_id = ? AND error_class = ? AND next_run_at IS NOT NULL
+`, deviceID, string(errclass.DeviceAbsent))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// querier is a *sql.DB or a *sql.Tx
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func scanFile(r rowScanner) (model.FileRow, error) {
+	var f model.FileRow
+	var stateStr, failedFrom string
+	var crc32c int64
+	if err := r.Scan(
+		&f.ID, &f.DeviceID, &f.SrcPath, &f.StagedPath,
+		&f.Size, &f.SHA256, &crc32c,
+		&stateStr, &f.Attempts, &f.LastError,
+		&f.NextRunAt, &f.UpdatedAt, &failedFrom, &f.ErrorClass, &f.ClaimGen, &f.CapturedAt, &f.CaptureSource,
+		&f.MTime, &f.Corrupt, &f.Object,
+	); err != nil {
+		return model.FileRow{}, err
+	}
+	f.CRC32C = uint32(crc32c)
+	f.State = model.FileState(stateStr)
+	f.FailedFrom = model.FileState(failedFrom)
+	return f, nil
+}
+
+func scanFiles(rows *sql.Rows) ([]model.FileRow, error) {
+	defer rows.Close()
+
+	var out []model.FileRow
+	for rows.Next() {
+		f, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
 }
 
 // utility to convert time.Duration into sql friendly format
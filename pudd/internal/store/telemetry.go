@@ -0,0 +1,94 @@
+package store
+
+import (
+	"database/sql"
+
+	"pudd/internal/model"
+)
+
+// SetTelemetry records the telemetry found in a file, replacing what an
+// earlier probe found
+func SetTelemetry(t model.Telemetry) Update {
+	return func(tx *sql.Tx, fileID int64) error {
+		_, err := tx.Exec(`
+INSERT INTO telemetry (file_id, source, device, points, started_at, ended_at)
+VALUES (?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''))
+ON CONFLICT(file_id) DO UPDATE SET
+  source     = excluded.source,
+  device     = excluded.device,
+  points     = excluded.points,
+  started_at = excluded.started_at,
+  ended_at   = excluded.ended_at
+`, fileID, t.Source, t.Device, t.Points, t.StartedAt, t.EndedAt)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM markers WHERE file_id = ?`, fileID); err != nil {
+			return err
+		}
+		for _, m := range t.Markers {
+			_, err := tx.Exec(`
+INSERT OR IGNORE INTO markers (file_id, offset_ms, kind, at) VALUES (?, ?, ?, NULLIF(?, ''))
+`, fileID, m.OffsetMS, m.Kind, m.At)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// GetTelemetry returns the telemetry summary of a file; false if it has
+// none
+func GetTelemetry(db *sql.DB, fileID int64) (model.Telemetry, bool, error) {
+	t := model.Telemetry{FileID: fileID}
+	err := db.QueryRow(`
+SELECT source, device, points, COALESCE(started_at, ''), COALESCE(ended_at, '')
+FROM telemetry WHERE file_id = ?
+`, fileID).Scan(&t.Source, &t.Device, &t.Points, &t.StartedAt, &t.EndedAt)
+	if err == sql.ErrNoRows {
+		return model.Telemetry{}, false, nil
+	}
+	if err != nil {
+		return model.Telemetry{}, false, err
+	}
+
+	rows, err := db.Query(`
+SELECT offset_ms, kind, COALESCE(at, '') FROM markers WHERE file_id = ? ORDER BY offset_ms
+`, fileID)
+	if err != nil {
+		return model.Telemetry{}, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m model.Marker
+		if err := rows.Scan(&m.OffsetMS, &m.Kind, &m.At); err != nil {
+			return model.Telemetry{}, false, err
+		}
+		t.Markers = append(t.Markers, m)
+	}
+	return t, true, rows.Err()
+}
+
+// FindSource returns the latest file of a device at any of srcPaths; false
+// if there is none. Sidecars use it to find the clip they belong to.
+func FindSource(db *sql.DB, deviceID string, srcPaths []string) (model.FileRow, bool, error) {
+	if len(srcPaths) == 0 {
+		return model.FileRow{}, false, nil
+	}
+	args := []any{deviceID}
+	for _, p := range srcPaths {
+		args = append(args, p)
+	}
+	f, err := scanFile(db.QueryRow(`
+SELECT `+fileColumns+`
+FROM files
+WHERE device_id = ? AND src_path IN (`+placeholders(len(srcPaths))+`)
+ORDER BY id DESC
+LIMIT 1
+`, args...))
+	if err == sql.ErrNoRows {
+		return model.FileRow{}, false, nil
+	}
+	return f, err == nil, err
+}
@@ -0,0 +1,164 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// GPX and GeoJSON renderings of a track, uploaded next to the clip: a line
+// of the GPS fixes, and a waypoint per marker placed at the fix nearest it.
+// Markers with no fix nearby are left out of GPX, which has no waypoints
+// without a position, and have a null geometry in GeoJSON.
+
+type gpxDoc struct {
+	XMLName  xml.Name    `xml:"gpx"`
+	Version  string      `xml:"version,attr"`
+	Creator  string      `xml:"creator,attr"`
+	NS       string      `xml:"xmlns,attr"`
+	Metadata gpxMetadata `xml:"metadata"`
+	Wpts     []gpxPoint  `xml:"wpt"`
+	Trk      *gpxTrack   `xml:"trk,omitempty"`
+}
+
+type gpxMetadata struct {
+	Name string `xml:"name"`
+	Desc string `xml:"desc,omitempty"`
+	Time string `xml:"time,omitempty"`
+}
+
+type gpxTrack struct {
+	Name string     `xml:"name"`
+	Src  string     `xml:"src,omitempty"`
+	Seg  []gpxPoint `xml:"trkseg>trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  float64  `xml:"lat,attr"`
+	Lon  float64  `xml:"lon,attr"`
+	Ele  *float64 `xml:"ele,omitempty"`
+	Time string   `xml:"time,omitempty"`
+	Name string   `xml:"name,omitempty"`
+	Type string   `xml:"type,omitempty"`
+}
+
+// WriteGPX writes t as GPX 1.1; name names the track, usually the clip
+func WriteGPX(w io.Writer, t Track, name string) error {
+	doc := gpxDoc{
+		Version:  "1.1",
+		Creator:  "pudd",
+		NS:       "http://www.topografix.com/GPX/1/1",
+		Metadata: gpxMetadata{Name: name, Desc: t.Source},
+	}
+	if start, _ := t.Span(); !start.IsZero() {
+		doc.Metadata.Time = gpxTime(start)
+	}
+	for _, m := range t.Markers {
+		p, ok := t.At(m.Offset)
+		if !ok {
+			continue
+		}
+		wpt := gpxPoint{Lat: p.Lat, Lon: p.Lon, Name: m.Kind + " " + formatOffset(m.Offset), Type: m.Kind}
+		if !m.Time.IsZero() {
+			wpt.Time = gpxTime(m.Time)
+		}
+		doc.Wpts = append(doc.Wpts, wpt)
+	}
+	if len(t.Points) > 0 {
+		trk := &gpxTrack{Name: name, Src: t.Device}
+		for _, p := range t.Points {
+			alt := p.Alt
+			pt := gpxPoint{Lat: p.Lat, Lon: p.Lon, Ele: &alt}
+			if !p.Time.IsZero() {
+				pt.Time = gpxTime(p.Time)
+			}
+			trk.Seg = append(trk.Seg, pt)
+		}
+		doc.Trk = trk
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", " ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func gpxTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// formatOffset formats an offset into a clip as m:ss.s
+func formatOffset(d time.Duration) string {
+	d = d.Round(100 * time.Millisecond)
+	m := d / time.Minute
+	return fmt.Sprintf("%d:%04.1f", m, (d - m*time.Minute).Seconds())
+}
+
+type geoJSON struct {
+	Type     string       `json:"type"`
+	Features []geoFeature `json:"features"`
+}
+
+type geoFeature struct {
+	Type       string         `json:"type"`
+	Geometry   *geoGeometry   `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+type geoGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+// WriteGeoJSON writes t as a GeoJSON FeatureCollection: a LineString of the
+// fixes, with their times in a coordTimes property as most tools expect,
+// and a Point feature per marker
+func WriteGeoJSON(w io.Writer, t Track, name string) error {
+	fc := geoJSON{Type: "FeatureCollection", Features: []geoFeature{}}
+	if len(t.Points) > 0 {
+		coords := make([][3]float64, 0, len(t.Points))
+		var times []string
+		for _, p := range t.Points {
+			coords = append(coords, [3]float64{p.Lon, p.Lat, p.Alt})
+			if !p.Time.IsZero() {
+				times = append(times, gpxTime(p.Time))
+			}
+		}
+		props := map[string]any{"name": name, "source": t.Source}
+		if t.Device != "" {
+			props["device"] = t.Device
+		}
+		// only if every fix has one, so they line up with coordinates
+		if len(times) == len(coords) {
+			props["coordTimes"] = times
+		}
+		// a LineString needs two positions
+		geom := &geoGeometry{Type: "LineString", Coordinates: coords}
+		if len(coords) == 1 {
+			geom = &geoGeometry{Type: "Point", Coordinates: coords[0]}
+		}
+		fc.Features = append(fc.Features, geoFeature{Type: "Feature", Geometry: geom, Properties: props})
+	}
+	for _, m := range t.Markers {
+		f := geoFeature{
+			Type:       "Feature",
+			Properties: map[string]any{"name": name, "kind": m.Kind, "offset": m.Offset.Seconds()},
+		}
+		if !m.Time.IsZero() {
+			f.Properties["time"] = gpxTime(m.Time)
+		}
+		if p, ok := t.At(m.Offset); ok {
+			f.Geometry = &geoGeometry{Type: "Point", Coordinates: [3]float64{p.Lon, p.Lat, p.Alt}}
+		}
+		fc.Features = append(fc.Features, f)
+	}
+	return json.NewEncoder(w).Encode(fc)
+}
@@ -0,0 +1,63 @@
+package telemetry
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func exportTrack() Track {
+	start := time.Date(2024, 5, 1, 10, 11, 12, 0, time.UTC)
+	return Track{
+		Source: SourceGPMF,
+		Device: "HERO12 Black",
+		Points: []Point{
+			{Offset: 0, Time: start, Lat: 47.3769, Lon: 8.5417, Alt: 408.123},
+			{Offset: time.Second, Time: start.Add(time.Second), Lat: 47.377, Lon: 8.5418, Alt: 408.5},
+			{Offset: 2 * time.Second, Time: start.Add(2 * time.Second), Lat: 47.3772, Lon: 8.542, Alt: 409},
+		},
+		Markers: []Marker{
+			{Offset: 1400 * time.Millisecond, Time: start.Add(1400 * time.Millisecond), Kind: MarkerHiLight},
+			// long after the last fix, so nowhere
+			{Offset: 75 * time.Second, Time: start.Add(75 * time.Second), Kind: MarkerHiLight},
+		},
+	}
+}
+
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestWriteGPX(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteGPX(&b, exportTrack(), "GX010001.MP4"); err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "track.gpx", b.Bytes())
+}
+
+func TestWriteGeoJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteGeoJSON(&b, exportTrack(), "GX010001.MP4"); err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "track.geojson", b.Bytes())
+}
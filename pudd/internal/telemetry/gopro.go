@@ -0,0 +1,202 @@
+package telemetry
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"pudd/internal/errclass"
+	"pudd/internal/gpmf"
+	"pudd/internal/mp4"
+)
+
+// A GoPro clip's metadata track ("gpmd") has a sample a second, each a DEVC
+// holding a stream (STRM) per sensor. GPS is GPS5 (lat, lon, alt, 2D and 3D
+// speed) with the fix in GPSF, the time of the sample's first value in
+// GPSU and precision in GPSP; HERO11 and later write GPS9, which carries
+// time, precision and fix per value. HiLight tags are in udta: HMMT on older
+// cameras, a GPMF HLMT nest holding MANL on newer ones, both as
+// milliseconds into the clip.
+
+// a metadata sample is a few KB; this keeps a bad stsz from allocating much
+const maxSample = 4 << 20
+
+// fixes below 2D are no position at all
+const minFix = 2
+
+// the day GPS9 counts days from
+var gps9Epoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ReadGoProFile reads the telemetry of a GoPro clip; the track is empty for
+// clips without any
+func ReadGoProFile(path string) (Track, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Track{}, errclass.FromOS(err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return Track{}, errclass.FromOS(err)
+	}
+	return ReadGoPro(f, st.Size())
+}
+
+// ReadGoPro reads the telemetry of a GoPro clip of the given size
+func ReadGoPro(r io.ReaderAt, size int64) (Track, error) {
+	t := Track{Source: SourceGPMF}
+	samples, err := mp4.TrackSamples(r, size, "gpmd")
+	if err != nil {
+		return Track{}, err
+	}
+	for i, s := range samples {
+		if s.Size <= 0 || s.Size > maxSample {
+			return Track{}, fmt.Errorf("gpmd sample %d: bad size %d", i, s.Size)
+		}
+		b := make([]byte, s.Size)
+		if _, err := r.ReadAt(b, s.Off); err != nil {
+			return Track{}, fmt.Errorf("gpmd sample %d: %w", i, err)
+		}
+		klvs, err := gpmf.Parse(b)
+		if err != nil {
+			return Track{}, fmt.Errorf("gpmd sample %d: %w", i, err)
+		}
+		for _, devc := range klvs {
+			if devc.Key != "DEVC" {
+				continue
+			}
+			for _, k := range devc.Nest {
+				switch k.Key {
+				case "DVNM":
+					if t.Device == "" {
+						t.Device = k.Text()
+					}
+				case "STRM":
+					t.Points = append(t.Points, gpsPoints(k.Nest, s)...)
+				}
+			}
+		}
+	}
+
+	if t.Markers, err = hiLights(r, size); err != nil {
+		return Track{}, err
+	}
+	t.timeMarkers()
+	return t, nil
+}
+
+// gpsPoints reads the GPS values of a stream. s is the sample the stream is
+// in; its values are spread evenly over the sample's duration.
+func gpsPoints(strm []gpmf.KLV, s mp4.Sample) []Point {
+	var scal []float64
+	var types string
+	var start time.Time
+	fix, dop := -1.0, 0.0
+	var out []Point
+	for _, k := range strm {
+		switch k.Key {
+		case "SCAL":
+			scal = k.Flat()
+		case "TYPE":
+			types = k.Text()
+		case "GPSU":
+			start, _ = k.Time()
+		case "GPSF":
+			if v := k.Flat(); len(v) > 0 {
+				fix = v[0]
+			}
+		case "GPSP":
+			if v := k.Flat(); len(v) > 0 {
+				dop = v[0] / 100
+			}
+		case "GPS5":
+			rows, err := k.Numbers("")
+			if err != nil || (fix >= 0 && fix < minFix) {
+				continue
+			}
+			gpmf.Scale(rows, scal)
+			for i, row := range rows {
+				if len(row) < 4 {
+					continue
+				}
+				at := s.Duration * time.Duration(i) / time.Duration(len(rows))
+				p := Point{Offset: s.Time + at, Lat: row[0], Lon: row[1], Alt: row[2], Speed: row[3], DOP: dop}
+				if !start.IsZero() {
+					p.Time = start.Add(at)
+				}
+				out = append(out, p)
+			}
+		case "GPS9":
+			rows, err := k.Numbers(types)
+			if err != nil {
+				continue
+			}
+			gpmf.Scale(rows, scal)
+			for i, row := range rows {
+				// lat, lon, alt, 2D speed, 3D speed, days, seconds, DOP, fix
+				if len(row) < 9 || row[8] < minFix {
+					continue
+				}
+				at := s.Duration * time.Duration(i) / time.Duration(len(rows))
+				out = append(out, Point{
+					Offset: s.Time + at,
+					Time:   gps9Epoch.AddDate(0, 0, int(row[5])).Add(time.Duration(row[6] * float64(time.Second))),
+					Lat:    row[0],
+					Lon:    row[1],
+					Alt:    row[2],
+					Speed:  row[3],
+					DOP:    row[7],
+				})
+			}
+		}
+	}
+	return out
+}
+
+// hiLights reads a clip's HiLight tags
+func hiLights(r io.ReaderAt, size int64) ([]Marker, error) {
+	var ms []uint32
+	hmmt, err := mp4.UserData(r, size, "HMMT")
+	if err != nil {
+		return nil, err
+	}
+	// a count, then the tags
+	if len(hmmt) >= 4 {
+		n := int(binary.BigEndian.Uint32(hmmt))
+		for i := 0; i < n && 8+4*i <= len(hmmt); i++ {
+			ms = append(ms, binary.BigEndian.Uint32(hmmt[4+4*i:]))
+		}
+	}
+
+	if len(ms) == 0 {
+		udta, err := mp4.UserData(r, size, "GPMF")
+		if err != nil {
+			return nil, err
+		}
+		// udta GPMF is best effort: it holds settings we don't read
+		klvs, _ := gpmf.Parse(udta)
+		for _, k := range klvs {
+			if k.Key != "HLMT" {
+				continue
+			}
+			for _, m := range k.Nest {
+				if m.Key == "MANL" {
+					for _, v := range m.Flat() {
+						ms = append(ms, uint32(v))
+					}
+				}
+			}
+		}
+	}
+
+	var out []Marker
+	for _, v := range ms {
+		if v == 0 {
+			continue
+		}
+		out = append(out, Marker{Offset: time.Duration(v) * time.Millisecond, Kind: MarkerHiLight})
+	}
+	return out, nil
+}
@@ -0,0 +1,142 @@
+package telemetry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+	"time"
+)
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+func box(typ string, parts ...[]byte) []byte {
+	payload := bytes.Join(parts, nil)
+	return append(append(u32(uint32(8+len(payload))), typ...), payload...)
+}
+
+// klv packs a GPMF entry of values of size bytes each, padded to 4
+func klv(key string, typ byte, size int, data []byte) []byte {
+	b := append([]byte(key), typ, byte(size))
+	b = append(b, u16(uint16(len(data)/size))...)
+	b = append(b, data...)
+	return append(b, make([]byte, (4-len(data)%4)%4)...)
+}
+
+func nest(key string, klvs ...[]byte) []byte {
+	return klv(key, 0, 1, bytes.Join(klvs, nil))
+}
+
+func int32s(vs ...int32) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = append(b, u32(uint32(v))...)
+	}
+	return b
+}
+
+// gpsSample is a second of metadata: fix and the GPS5 fixes, lat and lon in
+// 1e-7 degrees, alt and speeds in mm
+func gpsSample(fix byte, gpsu string, fixes ...int32) []byte {
+	return nest("DEVC",
+		klv("DVNM", 'c', 1, []byte("HERO12 Black")),
+		nest("STRM",
+			klv("GPSF", 'L', 4, u32(uint32(fix))),
+			klv("GPSU", 'U', 16, []byte(gpsu)),
+			klv("GPSP", 'S', 2, u16(150)),
+			klv("SCAL", 'l', 4, int32s(10000000, 10000000, 1000, 1000, 1000)),
+			klv("GPS5", 'l', 20, int32s(fixes...)),
+		),
+	)
+}
+
+// goProClip has a gpmd track of a sample a second and HiLight tags in udta
+func goProClip(hmmt []uint32, samples ...[]byte) []byte {
+	ftyp := box("ftyp", []byte("mp41"), u32(0), []byte("mp41"))
+	var sizes []byte
+	for _, s := range samples {
+		sizes = append(sizes, u32(uint32(len(s)))...)
+	}
+	tags := u32(uint32(len(hmmt)))
+	for _, ms := range hmmt {
+		tags = append(tags, u32(ms)...)
+	}
+	moov := func(at uint32) []byte {
+		gpmd := box("gpmd", make([]byte, 6), u16(1))
+		return box("moov",
+			box("trak", box("mdia",
+				box("mdhd", make([]byte, 12), u32(1000), u32(0), make([]byte, 4)),
+				box("minf", box("stbl",
+					box("stsd", u32(0), u32(1), gpmd),
+					box("stts", u32(0), u32(1), u32(uint32(len(samples))), u32(1000)),
+					// all samples in one chunk
+					box("stsc", u32(0), u32(1), u32(1), u32(uint32(len(samples))), u32(1)),
+					box("stsz", u32(0), u32(0), u32(uint32(len(samples))), sizes),
+					box("stco", u32(0), u32(1), u32(at)),
+				)),
+			)),
+			box("udta", box("HMMT", tags)),
+		)
+	}
+	at := uint32(len(ftyp) + len(moov(0)) + 8)
+	return bytes.Join([][]byte{ftyp, moov(at), box("mdat", samples...)}, nil)
+}
+
+func TestReadGoPro(t *testing.T) {
+	clip := goProClip([]uint32{500, 0, 1500},
+		gpsSample(3, "240501101112.000",
+			473769000, 85417000, 408123, 1500, 1600,
+			473770000, 85418000, 408500, 2000, 2100,
+		),
+		// lost the fix: a position now would be a guess
+		gpsSample(0, "240501101113.000",
+			0, 0, 0, 0, 0,
+		),
+		gpsSample(3, "240501101114.000",
+			473772000, 85420000, 409000, 2500, 2500,
+		),
+	)
+	track, err := ReadGoPro(bytes.NewReader(clip), int64(len(clip)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 5, 1, 10, 11, 12, 0, time.UTC)
+	want := Track{
+		Source: SourceGPMF,
+		Device: "HERO12 Black",
+		Points: []Point{
+			{Offset: 0, Time: start, Lat: 47.3769, Lon: 8.5417, Alt: 408.123, Speed: 1.5, DOP: 1.5},
+			// a sample's fixes are spread over its second
+			{Offset: 500 * time.Millisecond, Time: start.Add(500 * time.Millisecond), Lat: 47.377, Lon: 8.5418, Alt: 408.5, Speed: 2, DOP: 1.5},
+			{Offset: 2 * time.Second, Time: start.Add(2 * time.Second), Lat: 47.3772, Lon: 8.542, Alt: 409, Speed: 2.5, DOP: 1.5},
+		},
+		// tag 0 is an unused slot
+		Markers: []Marker{
+			{Offset: 500 * time.Millisecond, Time: start.Add(500 * time.Millisecond), Kind: MarkerHiLight},
+			{Offset: 1500 * time.Millisecond, Time: start.Add(1500 * time.Millisecond), Kind: MarkerHiLight},
+		},
+	}
+	if track.Source != want.Source || track.Device != want.Device {
+		t.Errorf("source %q device %q", track.Source, track.Device)
+	}
+	if !slices.Equal(track.Points, want.Points) {
+		t.Errorf("points\ngot  %+v\nwant %+v", track.Points, want.Points)
+	}
+	if !slices.Equal(track.Markers, want.Markers) {
+		t.Errorf("markers\ngot  %+v\nwant %+v", track.Markers, want.Markers)
+	}
+}
+
+func TestReadGoProNoTelemetry(t *testing.T) {
+	clip := box("ftyp", []byte("mp41"), u32(0), []byte("mp41"))
+	clip = append(clip, box("moov", box("udta"))...)
+	track, err := ReadGoPro(bytes.NewReader(clip), int64(len(clip)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !track.Empty() {
+		t.Errorf("got %+v", track)
+	}
+}
@@ -0,0 +1,118 @@
+package telemetry
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"pudd/internal/errclass"
+)
+
+// A DJI .SRT is a subtitle per frame whose text is the frame's telemetry.
+// Newer drones write fields in brackets:
+//
+//	2023-06-01 14:00:00.123
+//	[iso: 100] ... [latitude: 47.123456] [longitude: 8.123456] [rel_alt: 10.000 abs_alt: 500.000]
+//
+// older ones GPS(lon,lat,alt). The date is the drone's wall-clock time.
+
+var (
+	srtTiming = regexp.MustCompile(`^(\d+):(\d\d):(\d\d)[,.](\d{3})\s*-->`)
+	srtDate   = regexp.MustCompile(`(\d{4}-\d\d-\d\d \d\d:\d\d:\d\d)(?:[.,](\d+))?`)
+	srtLat    = regexp.MustCompile(`\[latitude\s*:\s*(-?[\d.]+)`)
+	srtLon    = regexp.MustCompile(`\[longitude\s*:\s*(-?[\d.]+)`)
+	srtAbsAlt = regexp.MustCompile(`abs_alt\s*:\s*(-?[\d.]+)`)
+	srtAlt    = regexp.MustCompile(`\[altitude\s*:\s*(-?[\d.]+)`)
+	srtGPS    = regexp.MustCompile(`GPS\s*\(\s*(-?[\d.]+)\s*,\s*(-?[\d.]+)\s*,\s*(-?[\d.]+)`)
+	srtTags   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// ReadSRTFile reads a DJI .SRT sidecar. correct turns the drone's
+// wall-clock times into true UTC.
+func ReadSRTFile(path string, correct func(time.Time) time.Time) (Track, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Track{}, errclass.FromOS(err)
+	}
+	defer f.Close()
+	return ReadSRT(f, correct)
+}
+
+// ReadSRT reads a DJI .SRT sidecar, see ReadSRTFile
+func ReadSRT(r io.Reader, correct func(time.Time) time.Time) (Track, error) {
+	t := Track{Source: SourceSRT}
+	var offset time.Duration
+	var text strings.Builder
+	flush := func() {
+		if p, ok := srtPoint(text.String(), offset, correct); ok {
+			t.Points = append(t.Points, p)
+		}
+		text.Reset()
+	}
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if m := srtTiming.FindStringSubmatch(line); m != nil {
+			flush()
+			h, _ := strconv.Atoi(m[1])
+			mi, _ := strconv.Atoi(m[2])
+			s, _ := strconv.Atoi(m[3])
+			ms, _ := strconv.Atoi(m[4])
+			offset = time.Duration(h)*time.Hour + time.Duration(mi)*time.Minute +
+				time.Duration(s)*time.Second + time.Duration(ms)*time.Millisecond
+			continue
+		}
+		text.WriteString(line)
+		text.WriteByte('\n')
+	}
+	flush()
+	return t, sc.Err()
+}
+
+// srtPoint reads one subtitle's telemetry; false if it has no fix
+func srtPoint(text string, offset time.Duration, correct func(time.Time) time.Time) (Point, bool) {
+	text = srtTags.ReplaceAllString(text, "")
+	p := Point{Offset: offset}
+	if m := srtGPS.FindStringSubmatch(text); m != nil {
+		p.Lon, _ = strconv.ParseFloat(m[1], 64)
+		p.Lat, _ = strconv.ParseFloat(m[2], 64)
+		p.Alt, _ = strconv.ParseFloat(m[3], 64)
+	} else {
+		p.Lat = srtFloat(srtLat, text)
+		p.Lon = srtFloat(srtLon, text)
+		p.Alt = srtFloat(srtAbsAlt, text)
+		if p.Alt == 0 {
+			p.Alt = srtFloat(srtAlt, text)
+		}
+	}
+	// drones write 0,0 until they have a fix
+	if p.Lat == 0 && p.Lon == 0 {
+		return Point{}, false
+	}
+
+	if m := srtDate.FindStringSubmatch(text); m != nil {
+		if at, err := time.Parse("2006-01-02 15:04:05", m[1]); err == nil {
+			// the first group of digits after the seconds is milliseconds
+			if len(m[2]) >= 3 {
+				ms, _ := strconv.Atoi(m[2][:3])
+				at = at.Add(time.Duration(ms) * time.Millisecond)
+			}
+			p.Time = correct(at)
+		}
+	}
+	return p, true
+}
+
+func srtFloat(re *regexp.Regexp, text string) float64 {
+	m := re.FindStringSubmatch(text)
+	if m == nil {
+		return 0
+	}
+	v, _ := strconv.ParseFloat(m[1], 64)
+	return v
+}
@@ -0,0 +1,65 @@
+package telemetry
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadSRT(t *testing.T) {
+	tests := []struct {
+		name string
+		srt  string
+		want []Point
+	}{
+		{"bracketed", `1
+00:00:00,000 --> 00:00:00,033
+<font size="28">FrameCnt: 1, DiffTime: 33ms
+2023-06-01 14:00:00.123
+[iso: 100] [shutter: 1/1000.0] [fnum: 2.8] [latitude: 0.000000] [longitude: 0.000000] [rel_alt: 0.000 abs_alt: 0.000]</font>
+
+2
+00:00:00,033 --> 00:00:00,066
+<font size="28">FrameCnt: 2, DiffTime: 33ms
+2023-06-01 14:00:00.156
+[iso: 100] [shutter: 1/1000.0] [fnum: 2.8] [latitude: 47.123456] [longitude: -8.123456] [rel_alt: 10.000 abs_alt: 500.250]</font>
+
+3
+00:01:02,500 --> 00:01:02,533
+<font size="28">FrameCnt: 1876, DiffTime: 33ms
+2023-06-01 14:01:02.623,456,789
+[iso: 100] [latitude: 47.123500] [longitude: -8.123400] [altitude: 12.5]</font>
+`, []Point{
+			// the first frame has no fix yet
+			{Offset: 33 * time.Millisecond, Time: time.Date(2023, 6, 1, 12, 0, 0, 156e6, time.UTC), Lat: 47.123456, Lon: -8.123456, Alt: 500.25},
+			{Offset: 62500 * time.Millisecond, Time: time.Date(2023, 6, 1, 12, 1, 2, 623e6, time.UTC), Lat: 47.1235, Lon: -8.1234, Alt: 12.5},
+		}},
+		{"older GPS", `1
+00:00:01,000 --> 00:00:02,000
+HOME(8.5000,47.3000) 2019.03.01 10:00:00
+GPS(8.5417,47.3769,18) BAROMETER:20.5
+ISO:100 Shutter:60 EV:0 Fnum:F2.8
+
+`, []Point{
+			// no date the parser reads
+			{Offset: time.Second, Lat: 47.3769, Lon: 8.5417, Alt: 18},
+		}},
+	}
+	// the drone's clock ran two hours ahead of UTC
+	correct := func(t time.Time) time.Time { return t.Add(-2 * time.Hour) }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			track, err := ReadSRT(strings.NewReader(tt.srt), correct)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if track.Source != SourceSRT {
+				t.Errorf("source %q", track.Source)
+			}
+			if !slices.Equal(track.Points, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", track.Points, tt.want)
+			}
+		})
+	}
+}
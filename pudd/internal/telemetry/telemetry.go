@@ -0,0 +1,103 @@
+package telemetry
+
+import "time"
+
+// Telemetry recorded alongside footage, from whatever the camera wrote it
+// in, as one model: a GPS track and markers. GoPro clips carry theirs in a
+// GPMF metadata track, DJI drones write a .SRT subtitle sidecar per clip.
+
+// sources
+const (
+	SourceGPMF = "gopro-gpmf"
+	SourceSRT  = "dji-srt"
+)
+
+// Track is the telemetry of one clip
+type Track struct {
+	Source  string
+	Device  string // camera name, if the telemetry gives one
+	Points  []Point
+	Markers []Marker
+}
+
+// Point is a GPS fix
+type Point struct {
+	Offset time.Duration // from the start of the clip
+	Time   time.Time     // UTC; zero if the source doesn't say
+	Lat    float64
+	Lon    float64
+	Alt    float64 // metres
+	Speed  float64 // ground speed, m/s; 0 if not recorded
+	DOP    float64 // dilution of precision; 0 if not recorded
+}
+
+// Marker is a moment someone flagged in a clip, like a GoPro HiLight
+type Marker struct {
+	Offset time.Duration
+	Time   time.Time // UTC; zero if unknown
+	Kind   string
+}
+
+// marker kinds
+const (
+	MarkerHiLight = "hilight"
+)
+
+// Empty reports whether t holds nothing worth writing out
+func (t Track) Empty() bool {
+	return len(t.Points) == 0 && len(t.Markers) == 0
+}
+
+// Span is the time the track covers; zero if its points have no times
+func (t Track) Span() (start, end time.Time) {
+	for _, p := range t.Points {
+		if p.Time.IsZero() {
+			continue
+		}
+		if start.IsZero() || p.Time.Before(start) {
+			start = p.Time
+		}
+		if p.Time.After(end) {
+			end = p.Time
+		}
+	}
+	return start, end
+}
+
+// At returns the point nearest offset, if one is within a few seconds
+func (t Track) At(offset time.Duration) (Point, bool) {
+	const near = 5 * time.Second
+	var best Point
+	found := false
+	for _, p := range t.Points {
+		d := abs(p.Offset - offset)
+		if d <= near && (!found || d < abs(best.Offset-offset)) {
+			best, found = p, true
+		}
+	}
+	return best, found
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// timeMarkers gives markers the time of their offset, going by the first
+// point that has one
+func (t *Track) timeMarkers() {
+	for _, p := range t.Points {
+		if p.Time.IsZero() {
+			continue
+		}
+		start := p.Time.Add(-p.Offset)
+		for i := range t.Markers {
+			if t.Markers[i].Time.IsZero() {
+				t.Markers[i].Time = start.Add(t.Markers[i].Offset)
+			}
+		}
+		return
+	}
+}
@@ -3,8 +3,11 @@ package udev
 import (
 	"bufio"
 	"context"
+	"log/slog"
 	"os/exec"
 	"strings"
+
+	"pudd/internal/logging"
 )
 
 type Event struct {
@@ -16,7 +19,8 @@ type Event struct {
 
 // Run listens to udev block events and calls onEvent for USB partitions only.
 func Run(ctx context.Context, onEvent func(Event)) error {
-	cmd := exec.Command(
+	// killed on context cancellation
+	cmd := exec.CommandContext(ctx,
 		"udevadm",
 		"monitor",
 		"--udev",
@@ -31,11 +35,11 @@ func Run(ctx context.Context, onEvent func(Event)) error {
 	if err := cmd.Start(); err != nil {
 		return err
 	}
-
-	// Ensure subprocess is killed on context cancellation.
-	go func() {
-		<-ctx.Done()
-		_ = cmd.Process.Kill()
+	// reap the subprocess so restarts don't leave zombies behind
+	defer func() {
+		if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+			slog.Debug("udevadm exited", logging.Err, err)
+		}
 	}()
 
 	sc := bufio.NewScanner(stdout)
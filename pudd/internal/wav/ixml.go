@@ -0,0 +1,123 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// iXML is an XML document in its own chunk. Recorders write different
+// subsets of it and not always the encoding they declare, so it is read
+// leniently: what parses is kept, bext wins where both give a field.
+
+type ixmlDoc struct {
+	Project string `xml:"PROJECT"`
+	Scene   string `xml:"SCENE"`
+	Take    string `xml:"TAKE"`
+	Tape    string `xml:"TAPE"`
+	Note    string `xml:"NOTE"`
+	Circled string `xml:"CIRCLED"`
+	Speed   struct {
+		TimecodeRate string `xml:"TIMECODE_RATE"`
+		TimecodeFlag string `xml:"TIMECODE_FLAG"`
+		SamplesHi    string `xml:"TIMESTAMP_SAMPLES_SINCE_MIDNIGHT_HI"`
+		SamplesLo    string `xml:"TIMESTAMP_SAMPLES_SINCE_MIDNIGHT_LO"`
+	} `xml:"SPEED"`
+	Tracks []struct {
+		Channel    string `xml:"CHANNEL_INDEX"`
+		Interleave string `xml:"INTERLEAVE_INDEX"`
+		Name       string `xml:"NAME"`
+		Function   string `xml:"FUNCTION"`
+	} `xml:"TRACK_LIST>TRACK"`
+	Bext struct {
+		Description string `xml:"BWF_DESCRIPTION"`
+		Originator  string `xml:"BWF_ORIGINATOR"`
+		Date        string `xml:"BWF_ORIGINATION_DATE"`
+		Time        string `xml:"BWF_ORIGINATION_TIME"`
+		RefLo       string `xml:"BWF_TIME_REFERENCE_LOW"`
+		RefHi       string `xml:"BWF_TIME_REFERENCE_HIGH"`
+	} `xml:"BEXT"`
+}
+
+func readIXML(b []byte, info *Info) {
+	var doc ixmlDoc
+	b = bytes.TrimRight(b, "\x00")
+	// the decoder gives up on the first byte that isn't UTF-8; recorders
+	// that don't write UTF-8 write Latin-1
+	if !utf8.Valid(b) {
+		b = latin1(b)
+	}
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	d.Strict = false
+	if err := d.Decode(&doc); err != nil {
+		return
+	}
+
+	info.Project = strings.TrimSpace(doc.Project)
+	info.Scene = strings.TrimSpace(doc.Scene)
+	info.Take = strings.TrimSpace(doc.Take)
+	info.Tape = strings.TrimSpace(doc.Tape)
+	info.Note = strings.TrimSpace(doc.Note)
+	info.Circled = strings.EqualFold(strings.TrimSpace(doc.Circled), "true")
+	info.TimecodeRate = strings.TrimSpace(doc.Speed.TimecodeRate)
+	info.DropFrame = strings.EqualFold(strings.TrimSpace(doc.Speed.TimecodeFlag), "DF")
+
+	if info.Description == "" {
+		info.Description = strings.TrimSpace(doc.Bext.Description)
+	}
+	if info.Originator == "" {
+		info.Originator = strings.TrimSpace(doc.Bext.Originator)
+	}
+	if info.RecordedAt.IsZero() {
+		info.RecordedAt = origination(doc.Bext.Date, doc.Bext.Time)
+	}
+	if info.TimeReference == nil {
+		if ref, ok := split64(doc.Speed.SamplesHi, doc.Speed.SamplesLo); ok {
+			info.TimeReference = &ref
+		} else if ref, ok := split64(doc.Bext.RefHi, doc.Bext.RefLo); ok {
+			info.TimeReference = &ref
+		}
+	}
+
+	for _, t := range doc.Tracks {
+		ch, err := strconv.Atoi(strings.TrimSpace(t.Channel))
+		if err != nil {
+			ch, err = strconv.Atoi(strings.TrimSpace(t.Interleave))
+		}
+		if err != nil || ch <= 0 {
+			continue
+		}
+		info.Tracks = append(info.Tracks, Track{
+			Channel:  ch,
+			Name:     strings.TrimSpace(t.Name),
+			Function: strings.TrimSpace(t.Function),
+		})
+	}
+	sort.Slice(info.Tracks, func(i, j int) bool { return info.Tracks[i].Channel < info.Tracks[j].Channel })
+}
+
+func latin1(b []byte) []byte {
+	out := make([]byte, 0, len(b)+len(b)/4)
+	for _, c := range b {
+		out = utf8.AppendRune(out, rune(c))
+	}
+	return out
+}
+
+// split64 joins a 64-bit count iXML gives as two 32-bit halves
+func split64(hi, lo string) (uint64, bool) {
+	l, err := strconv.ParseUint(strings.TrimSpace(lo), 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	h, err := strconv.ParseUint(strings.TrimSpace(hi), 10, 32)
+	if err != nil {
+		h = 0
+	}
+	return h<<32 | l, true
+}
@@ -0,0 +1,101 @@
+package wav
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadIXML(t *testing.T) {
+	ref := uint64(oneHour)
+	tests := []struct {
+		name string
+		doc  string
+		info Info // what bext already gave
+		want Info
+	}{
+		{"scene take tracks", `<?xml version="1.0" encoding="UTF-8"?>
+<BWFXML>
+ <PROJECT>Harbour</PROJECT>
+ <SCENE> 12A </SCENE>
+ <TAKE>3</TAKE>
+ <TAPE>240501</TAPE>
+ <NOTE>plane overhead</NOTE>
+ <CIRCLED>TRUE</CIRCLED>
+ <SPEED>
+  <TIMECODE_RATE>30000/1001</TIMECODE_RATE>
+  <TIMECODE_FLAG>DF</TIMECODE_FLAG>
+  <TIMESTAMP_SAMPLES_SINCE_MIDNIGHT_HI>0</TIMESTAMP_SAMPLES_SINCE_MIDNIGHT_HI>
+  <TIMESTAMP_SAMPLES_SINCE_MIDNIGHT_LO>172800000</TIMESTAMP_SAMPLES_SINCE_MIDNIGHT_LO>
+ </SPEED>
+ <TRACK_LIST>
+  <TRACK_COUNT>4</TRACK_COUNT>
+  <TRACK><CHANNEL_INDEX>2</CHANNEL_INDEX><NAME>Boom</NAME><FUNCTION>M</FUNCTION></TRACK>
+  <TRACK><CHANNEL_INDEX>1</CHANNEL_INDEX><NAME>Mix L</NAME></TRACK>
+  <TRACK><INTERLEAVE_INDEX>3</INTERLEAVE_INDEX><NAME>Lav 1</NAME></TRACK>
+  <TRACK><NAME>no channel</NAME></TRACK>
+ </TRACK_LIST>
+</BWFXML>`, Info{}, Info{
+			Project: "Harbour", Scene: "12A", Take: "3", Tape: "240501", Note: "plane overhead", Circled: true,
+			TimecodeRate: "30000/1001", DropFrame: true,
+			TimeReference: &ref,
+			Tracks: []Track{
+				{Channel: 1, Name: "Mix L"},
+				{Channel: 2, Name: "Boom", Function: "M"},
+				{Channel: 3, Name: "Lav 1"},
+			},
+		}},
+		// bext wins, iXML's copy fills in what it lacks
+		{"bext fallback", `<BWFXML><BEXT>
+ <BWF_DESCRIPTION>from ixml</BWF_DESCRIPTION>
+ <BWF_ORIGINATOR>Sound Devices 833</BWF_ORIGINATOR>
+ <BWF_ORIGINATION_DATE>2024:05:01</BWF_ORIGINATION_DATE>
+ <BWF_ORIGINATION_TIME>10-11-12</BWF_ORIGINATION_TIME>
+ <BWF_TIME_REFERENCE_LOW>172800000</BWF_TIME_REFERENCE_LOW>
+ <BWF_TIME_REFERENCE_HIGH>0</BWF_TIME_REFERENCE_HIGH>
+</BEXT></BWFXML>`, Info{Description: "from bext"}, Info{
+			Description:   "from bext",
+			Originator:    "Sound Devices 833",
+			RecordedAt:    time.Date(2024, 5, 1, 10, 11, 12, 0, time.UTC),
+			TimeReference: &ref,
+		}},
+		// Latin-1, NUL padded to the chunk's size
+		{"lenient", "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><BWFXML><SCENE>Caf\xe9</SCENE><TAKE>7</TAKE></BWFXML>\x00\x00\x00",
+			Info{}, Info{Scene: "Café", Take: "7"}},
+		{"not xml", "\x00\x00\x00\x00", Info{Scene: "kept"}, Info{Scene: "kept"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := tt.info
+			readIXML([]byte(tt.doc), &info)
+			if !reflect.DeepEqual(info, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", info, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimecode(t *testing.T) {
+	tests := []struct {
+		rate string
+		drop bool
+		want string
+	}{
+		{"25/1", false, "01:00:00:00"},
+		{"25", false, "01:00:00:00"},
+		// 23.976 counts 24 frames to a second that is 1.001 real ones
+		{"24000/1001", false, "00:59:56:09"},
+		// drop frame keeps 29.97 on the wall clock
+		{"30000/1001", true, "01:00:00;00"},
+		{"30000/1001", false, "00:59:56:12"},
+		{"", false, ""},
+		{"0/0", false, ""},
+	}
+	ref := uint64(oneHour)
+	for _, tt := range tests {
+		info := Info{SampleRate: 48000, TimeReference: &ref, TimecodeRate: tt.rate, DropFrame: tt.drop}
+		if got := timecode(info); got != tt.want {
+			t.Errorf("rate %q drop %v: %q, want %q", tt.rate, tt.drop, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"pudd/internal/errclass"
+	"pudd/internal/mp4"
+)
+
+// A reader for the metadata of WAV files as field recorders (Zoom, Sound
+// Devices, Sony...) write them: Broadcast WAV, whose bext chunk holds the
+// recorder, the date and the start of the recording as a count of samples
+// since midnight, and iXML, which adds scene, take, tape, the timecode rate
+// and track names. RF64 and BW64, for recordings past 4 GB, keep their real
+// sizes in a ds64 chunk. The audio itself is skipped.
+
+// Info is what a WAV file says about itself. Fields it doesn't record are
+// zero.
+type Info struct {
+	Format     string        `json:"format"` // wav; bwf with a bext chunk; rf64
+	Codec      string        `json:"codec"`  // pcm, float, or the WAVE format tag
+	SampleRate int           `json:"sample_rate"`
+	Channels   int           `json:"channels"`
+	BitDepth   int           `json:"bit_depth"`
+	Duration   time.Duration `json:"duration"`
+
+	Description   string `json:"description"`
+	Originator    string `json:"originator"` // the recorder, e.g. "ZOOM F8n"
+	OriginatorRef string `json:"originator_ref"`
+	// when recording started, by the recorder's clock, which BWF gives no
+	// zone for: local time labelled UTC
+	RecordedAt time.Time `json:"recorded_at"`
+	// the start, in samples since midnight; nil if not recorded
+	TimeReference *uint64 `json:"time_reference,omitempty"`
+
+	Project      string  `json:"project"`
+	Scene        string  `json:"scene"`
+	Take         string  `json:"take"`
+	Tape         string  `json:"tape"`
+	Note         string  `json:"note"`
+	Circled      bool    `json:"circled"`
+	TimecodeRate string  `json:"timecode_rate"` // as iXML gives it, e.g. 24000/1001
+	DropFrame    bool    `json:"drop_frame"`
+	Timecode     string  `json:"timecode"` // start; empty without a time reference and rate
+	Tracks       []Track `json:"tracks,omitempty"`
+}
+
+// Track is a channel the recorder named
+type Track struct {
+	Channel  int    `json:"channel"` // from 1
+	Name     string `json:"name"`
+	Function string `json:"function,omitempty"`
+}
+
+var ErrNotWAV = errors.New("not a RIFF WAVE file")
+
+// metadata chunks are a few KB; this keeps a bad size from allocating much
+const maxChunk = 4 << 20
+
+// WAVE format tags
+const (
+	formatPCM        = 0x0001
+	formatFloat      = 0x0003
+	formatExtensible = 0xfffe
+)
+
+// ReadFile reads the metadata of the WAV file at path
+func ReadFile(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, errclass.FromOS(err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return Info{}, errclass.FromOS(err)
+	}
+	return Read(f, st.Size())
+}
+
+// Read reads the metadata of a WAV file of the given size
+func Read(r io.ReaderAt, size int64) (Info, error) {
+	var hdr [12]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil || string(hdr[8:]) != "WAVE" {
+		return Info{}, ErrNotWAV
+	}
+	info := Info{Format: "wav"}
+	switch string(hdr[:4]) {
+	case "RIFF":
+	case "RF64", "BW64":
+		info.Format = "rf64"
+	default:
+		return Info{}, ErrNotWAV
+	}
+
+	var blockAlign int
+	var dataSize, ds64Data int64 = -1, -1
+	var haveFmt bool
+	var ixml []byte
+	for off := int64(12); off+8 <= size; {
+		var ch [8]byte
+		if _, err := r.ReadAt(ch[:], off); err != nil {
+			return Info{}, err
+		}
+		id, n := string(ch[:4]), int64(binary.LittleEndian.Uint32(ch[4:]))
+		body := off + 8
+		if id == "data" {
+			// RF64 gives the size in ds64; a recording cut short may
+			// claim more than there is
+			if n == 0xffffffff && ds64Data >= 0 {
+				n = ds64Data
+			}
+			dataSize = min(n, size-body)
+			off = body + n + n&1
+			continue
+		}
+
+		switch id {
+		case "fmt ", "bext", "iXML", "ds64":
+		default:
+			off = body + n + n&1
+			continue
+		}
+		if n > maxChunk || body+n > size {
+			return Info{}, fmt.Errorf("%q chunk at %d: bad size %d", id, off, n)
+		}
+		b := make([]byte, n)
+		if _, err := r.ReadAt(b, body); err != nil {
+			return Info{}, err
+		}
+		switch id {
+		case "ds64":
+			// RIFF size, data size, sample count
+			if len(b) >= 16 {
+				ds64Data = int64(binary.LittleEndian.Uint64(b[8:]))
+			}
+		case "fmt ":
+			if len(b) < 16 {
+				return Info{}, fmt.Errorf("fmt chunk: %d bytes", len(b))
+			}
+			tag := binary.LittleEndian.Uint16(b)
+			// the real tag is the first two bytes of the sub-format GUID
+			if tag == formatExtensible && len(b) >= 26 {
+				tag = binary.LittleEndian.Uint16(b[24:])
+			}
+			info.Codec = codec(tag)
+			info.Channels = int(binary.LittleEndian.Uint16(b[2:]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(b[4:]))
+			blockAlign = int(binary.LittleEndian.Uint16(b[12:]))
+			info.BitDepth = int(binary.LittleEndian.Uint16(b[14:]))
+			haveFmt = true
+		case "bext":
+			info.Format = strings.Replace(info.Format, "wav", "bwf", 1)
+			readBext(b, &info)
+		case "iXML":
+			ixml = b
+		}
+		off = body + n + n&1
+	}
+	if !haveFmt {
+		return Info{}, errors.New("no fmt chunk")
+	}
+
+	if dataSize > 0 && blockAlign > 0 && info.SampleRate > 0 {
+		frames := dataSize / int64(blockAlign)
+		info.Duration = time.Duration(float64(frames) / float64(info.SampleRate) * float64(time.Second))
+	}
+	// iXML fills in what bext left out; a recorder that can't write it
+	// right is no reason to lose the rest
+	if ixml != nil {
+		readIXML(ixml, &info)
+	}
+	info.Timecode = timecode(info)
+	return info, nil
+}
+
+func codec(tag uint16) string {
+	switch tag {
+	case formatPCM:
+		return "pcm"
+	case formatFloat:
+		return "float"
+	}
+	return fmt.Sprintf("0x%04x", tag)
+}
+
+// readBext reads a bext chunk: description, originator, originator
+// reference, date and time of origination, then the time reference
+func readBext(b []byte, info *Info) {
+	if len(b) < 346 {
+		return
+	}
+	info.Description = text(b[0:256])
+	info.Originator = text(b[256:288])
+	info.OriginatorRef = text(b[288:320])
+	info.RecordedAt = origination(text(b[320:330]), text(b[330:338]))
+	ref := binary.LittleEndian.Uint64(b[338:])
+	info.TimeReference = &ref
+}
+
+// text is a fixed-size ASCII field, NUL padded
+func text(b []byte) string {
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// origination reads a bext date and time, yyyy-mm-dd and hh:mm:ss, whatever
+// separators the recorder used; zero if they don't make a time
+func origination(date, clock string) time.Time {
+	digits := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, s)
+	}
+	t, err := time.Parse("20060102150405", digits(date)+digits(clock))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// timecode is the start of the recording as timecode at its iXML rate
+func timecode(info Info) string {
+	if info.TimeReference == nil || info.SampleRate <= 0 {
+		return ""
+	}
+	num, den, ok := rate(info.TimecodeRate)
+	if !ok {
+		return ""
+	}
+	fps := int((num + den/2) / den)
+	frames := *info.TimeReference * num / (den * uint64(info.SampleRate))
+	return mp4.Timecode(int(frames), fps, info.DropFrame && fps%30 == 0)
+}
+
+// rate reads a timecode rate as iXML writes it, 24000/1001 or 25/1
+func rate(s string) (num, den uint64, ok bool) {
+	n, d, found := strings.Cut(strings.TrimSpace(s), "/")
+	if !found {
+		d = "1"
+	}
+	if _, err := fmt.Sscan(n, &num); err != nil {
+		return 0, 0, false
+	}
+	if _, err := fmt.Sscan(d, &den); err != nil || num == 0 || den == 0 {
+		return 0, 0, false
+	}
+	return num, den, true
+}
@@ -0,0 +1,142 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func u16(v uint16) []byte { return binary.LittleEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.LittleEndian.AppendUint64(nil, v) }
+
+// chunk packs body into a RIFF chunk, padded to an even size
+func chunk(id string, body ...[]byte) []byte {
+	b := bytes.Join(body, nil)
+	out := append(append([]byte(id), u32(uint32(len(b)))...), b...)
+	if len(b)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+func riff(form string, chunks ...[]byte) []byte {
+	b := bytes.Join(chunks, nil)
+	return append(append([]byte(form), u32(uint32(4+len(b)))...), append([]byte("WAVE"), b...)...)
+}
+
+func fmtPCM(rate uint32, channels, bits uint16) []byte {
+	align := channels * bits / 8
+	return chunk("fmt ", u16(formatPCM), u16(channels), u32(rate), u32(rate*uint32(align)), u16(align), u16(bits))
+}
+
+// field is a NUL padded fixed-size bext field
+func field(s string, n int) []byte {
+	return append([]byte(s), make([]byte, n-len(s))...)
+}
+
+func bext(desc, originator, date, clock string, ref uint64) []byte {
+	return chunk("bext",
+		field(desc, 256), field(originator, 32), field("USZOOM0123", 32),
+		field(date, 10), field(clock, 8), u64(ref),
+		// version, UMID, loudness, reserved
+		make([]byte, 602-346),
+	)
+}
+
+// 48 kHz samples since midnight at 01:00:00
+const oneHour = 3600 * 48000
+
+func TestRead(t *testing.T) {
+	// a tenth of a second of 24-bit stereo
+	data := chunk("data", make([]byte, 4800*6))
+	ixml := chunk("iXML", []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<BWFXML><SCENE>12A</SCENE><TAKE>3</TAKE><SPEED><TIMECODE_RATE>25/1</TIMECODE_RATE><TIMECODE_FLAG>NDF</TIMECODE_FLAG></SPEED></BWFXML>`))
+	ref := uint64(oneHour)
+
+	tests := []struct {
+		name string
+		file []byte
+		want Info
+	}{
+		{"bwf", riff("RIFF",
+			fmtPCM(48000, 2, 24),
+			// odd-sized, so the next chunk starts after a pad byte
+			chunk("LIST", []byte("INFOabc")),
+			bext("sc12A tk3", "ZOOM F8n", "2024-05-01", "10:11:12", oneHour),
+			ixml,
+			data,
+		), Info{
+			Format: "bwf", Codec: "pcm", SampleRate: 48000, Channels: 2, BitDepth: 24,
+			Duration:    100 * time.Millisecond,
+			Description: "sc12A tk3", Originator: "ZOOM F8n", OriginatorRef: "USZOOM0123",
+			RecordedAt:    time.Date(2024, 5, 1, 10, 11, 12, 0, time.UTC),
+			TimeReference: &ref,
+			Scene:         "12A", Take: "3", TimecodeRate: "25/1", Timecode: "01:00:00:00",
+		}},
+		// a plain WAV: no bext, no timecode
+		{"no bext", riff("RIFF",
+			chunk("junk", []byte{1, 2, 3}),
+			fmtPCM(44100, 1, 16),
+			chunk("data", make([]byte, 44100*2)),
+		), Info{
+			Format: "wav", Codec: "pcm", SampleRate: 44100, Channels: 1, BitDepth: 16,
+			Duration: time.Second,
+		}},
+		// the data chunk's size is in ds64
+		{"rf64", riff("RF64",
+			chunk("ds64", u64(0), u64(48000*6), u64(48000), u32(0)),
+			fmtPCM(48000, 2, 24),
+			append(append([]byte("data"), u32(0xffffffff)...), make([]byte, 48000*6)...),
+		), Info{
+			Format: "rf64", Codec: "pcm", SampleRate: 48000, Channels: 2, BitDepth: 24,
+			Duration: time.Second,
+		}},
+		// cut short: the duration is what's there
+		{"truncated data", riff("RIFF",
+			fmtPCM(48000, 2, 24),
+			append(append([]byte("data"), u32(48000*6)...), make([]byte, 4800*6)...),
+		), Info{
+			Format: "wav", Codec: "pcm", SampleRate: 48000, Channels: 2, BitDepth: 24,
+			Duration: 100 * time.Millisecond,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Read(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", info, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   []byte
+		notWAV bool
+	}{
+		{"not riff", []byte("RIFX\x04\x00\x00\x00WAVE"), true},
+		{"not wave", []byte("RIFF\x04\x00\x00\x00AVI "), true},
+		{"no fmt", riff("RIFF", chunk("data", make([]byte, 10))), false},
+		{"bext past the end", riff("RIFF", fmtPCM(48000, 2, 24), bext("", "", "", "", 0)[:100]), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Read(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if errors.Is(err, ErrNotWAV) != tt.notWAV {
+				t.Errorf("got %v", err)
+			}
+		})
+	}
+}